@@ -0,0 +1,170 @@
+package ai
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+const (
+	// Consecutive 429/5xx responses before a model is taken out of the chain
+	defaultBreakerThreshold = 3
+
+	// How long a tripped model is skipped before one trial request is let through
+	defaultBreakerCooldown = 60 * time.Second
+)
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStatus is a snapshot of one breaker
+type BreakerStatus struct {
+	Key      string    `json:"key"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+}
+
+// circuitBreaker guards one provider/model pair
+type circuitBreaker struct {
+	mu       sync.Mutex
+	key      string
+	state    string
+	failures int
+	openedAt time.Time
+	trialOut bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[string]*circuitBreaker)
+)
+
+// breakerFor returns the breaker of a provider/model pair, creating it on first use
+func breakerFor(provider, model string) *circuitBreaker {
+	key := provider + "/" + model
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[key]
+	if !ok {
+		b = &circuitBreaker{key: key, state: BreakerClosed}
+		breakers[key] = b
+	}
+	return b
+}
+
+// Breakers returns the state of every breaker that has seen traffic
+func Breakers() []BreakerStatus {
+	breakersMu.Lock()
+	list := make([]*circuitBreaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	breakersMu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(list))
+	for _, b := range list {
+		b.mu.Lock()
+		statuses = append(statuses, BreakerStatus{Key: b.key, State: b.state, Failures: b.failures, OpenedAt: b.openedAt})
+		b.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
+
+// breakerSettings reads AI_BREAKER ({"threshold": 3, "cooldownSec": 60})
+func breakerSettings() (int, time.Duration) {
+	var settings struct {
+		Threshold   int `json:"threshold"`
+		CooldownSec int `json:"cooldownSec"`
+	}
+	threshold, cooldown := defaultBreakerThreshold, defaultBreakerCooldown
+	if config.DecodeConfigValue("AI_BREAKER", &settings) {
+		if settings.Threshold > 0 {
+			threshold = settings.Threshold
+		}
+		if settings.CooldownSec > 0 {
+			cooldown = time.Duration(settings.CooldownSec) * time.Second
+		}
+	}
+	return threshold, cooldown
+}
+
+// Allow reports whether a request may be sent
+// After the cooldown an open breaker lets exactly one trial request through
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		_, cooldown := breakerSettings()
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trialOut = true
+		log.Printf("[INFO] Circuit breaker %s half-open, sending a trial request", b.key)
+		return true
+	case BreakerHalfOpen:
+		if b.trialOut {
+			return false
+		}
+		b.trialOut = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success closes the breaker
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		log.Printf("[INFO] Circuit breaker %s closed again", b.key)
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trialOut = false
+}
+
+// Failure counts an error, only rate limits and server errors can trip the breaker
+func (b *circuitBreaker) Failure(class ErrorClass) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if class != ErrorRateLimit && class != ErrorServer {
+		// The trial request still has to come back before another is allowed
+		b.trialOut = false
+		return
+	}
+
+	b.failures++
+	threshold, _ := breakerSettings()
+	if b.state == BreakerHalfOpen || b.failures >= threshold {
+		if b.state != BreakerOpen {
+			log.Printf("[WARNING] Circuit breaker %s open after %d failures (%s)", b.key, b.failures, class)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.trialOut = false
+	}
+}
+
+// Release hands back a trial that ended without an answer either way, e.g. a cancelled request
+// Without it a half-open breaker would wait for a trial that never reports back
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trialOut = false
+	}
+}
@@ -0,0 +1,107 @@
+package ai
+
+import (
+	"testing"
+	"time"
+)
+
+// resetBreakers starts a test with no breakers
+func resetBreakers(t *testing.T) {
+	breakersMu.Lock()
+	breakers = make(map[string]*circuitBreaker)
+	breakersMu.Unlock()
+	t.Cleanup(func() {
+		breakersMu.Lock()
+		breakers = make(map[string]*circuitBreaker)
+		breakersMu.Unlock()
+	})
+}
+
+// tripBreaker opens a breaker whose cooldown has already passed
+func tripBreaker(b *circuitBreaker) {
+	for i := 0; i < defaultBreakerThreshold; i++ {
+		b.Failure(ErrorServer)
+	}
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-2 * defaultBreakerCooldown)
+	b.mu.Unlock()
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	resetBreakers(t)
+	b := breakerFor("test", "model")
+
+	for i := 1; i < defaultBreakerThreshold; i++ {
+		b.Failure(ErrorServer)
+		if !b.Allow() {
+			t.Fatalf("breaker open after %d failures, threshold is %d", i, defaultBreakerThreshold)
+		}
+	}
+	b.Failure(ErrorRateLimit)
+	if b.Allow() {
+		t.Fatal("breaker still allows requests after reaching the threshold")
+	}
+	if status := Breakers(); len(status) != 1 || status[0].State != BreakerOpen {
+		t.Fatalf("Breakers() = %+v, want one open breaker", status)
+	}
+}
+
+func TestBreakerIgnoresOtherErrors(t *testing.T) {
+	resetBreakers(t)
+	b := breakerFor("test", "model")
+
+	for i := 0; i < 2*defaultBreakerThreshold; i++ {
+		b.Failure(ErrorAuth)
+		b.Failure(ErrorTimeout)
+	}
+	if !b.Allow() {
+		t.Fatal("auth and timeout errors tripped the breaker")
+	}
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	resetBreakers(t)
+	b := breakerFor("test", "model")
+	tripBreaker(b)
+
+	if !b.Allow() {
+		t.Fatal("no trial request after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("a second request was let through while the trial is out")
+	}
+
+	b.Failure(ErrorServer)
+	if b.Allow() {
+		t.Fatal("a failed trial did not reopen the breaker")
+	}
+
+	tripBreaker(b)
+	if !b.Allow() {
+		t.Fatal("no trial request after the second cooldown")
+	}
+	b.Success()
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("a successful trial did not close the breaker")
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	resetBreakers(t)
+	b := breakerFor("test", "model")
+
+	// Releasing a closed breaker changes nothing
+	b.Release()
+	if !b.Allow() {
+		t.Fatal("release blocked a closed breaker")
+	}
+
+	tripBreaker(b)
+	if !b.Allow() {
+		t.Fatal("no trial request after the cooldown")
+	}
+	b.Release()
+	if !b.Allow() {
+		t.Fatal("a released trial was not handed out again")
+	}
+}
@@ -0,0 +1,204 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ChainLinkConfig is one entry of AI_CHAIN in set.json
+// Model is used for sheets and fixes, HelperModel for the helpers; either falls back to the provider default
+// APIKey and BaseURL default to AI_API and AI_BASE_URL when the link uses the AI_PROVIDER provider
+type ChainLinkConfig struct {
+	Provider    string `json:"provider"`
+	Model       string `json:"model"`
+	HelperModel string `json:"helperModel"`
+	APIKey      string `json:"apiKey"`
+	BaseURL     string `json:"baseUrl"`
+}
+
+// chainLink is a built provider with the models it should be asked for
+type chainLink struct {
+	provider    Provider
+	model       string
+	helperModel string
+}
+
+// modelFor picks the model of a link for a purpose
+func (l chainLink) modelFor(purpose Purpose) string {
+	if purpose == PurposeHelper && l.helperModel != "" {
+		return l.helperModel
+	}
+	if purpose != PurposeHelper && l.model != "" {
+		return l.model
+	}
+	return l.provider.DefaultModel(purpose)
+}
+
+// Chain is an ordered list of provider/model pairs, e.g. pro -> flash -> local
+// Each request goes down the chain until a model answers, skipping models whose breaker is open
+type Chain struct {
+	links []chainLink
+}
+
+// NewChain builds a chain from its config, wrapping each link for recording when mode is set
+func NewChain(configs []ChainLinkConfig, defaults ProviderConfig, defaultProvider, recordMode, recordDir string) (*Chain, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("AI_CHAIN is empty")
+	}
+
+	chain := &Chain{}
+	for i, cfg := range configs {
+		if cfg.Provider == "" {
+			return nil, fmt.Errorf("AI_CHAIN entry %d has no provider", i)
+		}
+		pc := ProviderConfig{APIKey: cfg.APIKey, BaseURL: cfg.BaseURL}
+		if cfg.Provider == defaultProvider {
+			if pc.APIKey == "" {
+				pc.APIKey = defaults.APIKey
+			}
+			if pc.BaseURL == "" {
+				pc.BaseURL = defaults.BaseURL
+			}
+		}
+
+		provider, err := NewProvider(cfg.Provider, pc)
+		if recordMode != "" {
+			if err != nil && recordMode == RecordModeReplay {
+				provider, err = nil, nil
+			}
+			if err == nil {
+				provider, err = WithRecording(provider, recordMode, recordDir)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("AI_CHAIN entry %d (%s): %w", i, cfg.Provider, err)
+		}
+
+		chain.links = append(chain.links, chainLink{provider: provider, model: cfg.Model, helperModel: cfg.HelperModel})
+	}
+	return chain, nil
+}
+
+func (c *Chain) Name() string {
+	names := make([]string, len(c.links))
+	for i, l := range c.links {
+		names[i] = l.provider.Name()
+	}
+	return "chain(" + strings.Join(names, ",") + ")"
+}
+
+// DefaultModel is the model of the first link, the one used when nothing fails
+func (c *Chain) DefaultModel(purpose Purpose) string {
+	return c.links[0].modelFor(purpose)
+}
+
+// SupportsSchema is true only when every link does, a fallback must understand the same prompt
+func (c *Chain) SupportsSchema() bool {
+	for _, l := range c.links {
+		if !SupportsSchema(l.provider) {
+			return false
+		}
+	}
+	return true
+}
+
+// Generate goes down the chain, the model set on req is ignored in favour of each link's model
+func (c *Chain) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return Run(ctx, c, req, nil)
+}
+
+// Run sends a request with the retry policies and circuit breakers, falling back down the chain
+// A plain provider is treated as a chain of one, keeping the model on req
+// Streamed chunks carry a running attempt number so listeners can drop output of failed attempts
+func Run(ctx context.Context, p Provider, req *Request, onChunk StreamFunc) (*Response, error) {
+	if p == nil {
+		return nil, fmt.Errorf("no AI provider configured")
+	}
+
+	var links []chainLink
+	if chain, ok := p.(*Chain); ok {
+		links = chain.links
+	} else {
+		model := req.Model
+		if model == "" {
+			model = ResolveModel(p, req.Purpose)
+		}
+		links = []chainLink{{provider: p, model: model, helperModel: model}}
+	}
+
+	attempt := 0
+	var lastErr error
+	for i, link := range links {
+		linkReq := *req
+		linkReq.Model = link.modelFor(req.Purpose)
+		breaker := breakerFor(link.provider.Name(), linkReq.Model)
+
+		for tries := 1; ; tries++ {
+			if !breaker.Allow() {
+				log.Printf("[WARNING] Skipping %s/%s, circuit breaker open", link.provider.Name(), linkReq.Model)
+				lastErr = fmt.Errorf("%s/%s: %w", link.provider.Name(), linkReq.Model, ErrCircuitOpen)
+				break
+			}
+
+			attempt++
+			resp, err := sendLink(ctx, link.provider, &linkReq, breaker, onChunk, attempt)
+			if err == nil {
+				if i > 0 {
+					log.Printf("[INFO] %s answered by fallback %s/%s", req.Purpose, resp.Provider, resp.Model)
+				}
+				return resp, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			class := ClassifyError(err)
+			lastErr = fmt.Errorf("%s/%s: %w", link.provider.Name(), linkReq.Model, err)
+			log.Printf("[ERROR] %s/%s attempt %d failed (%s): %v", link.provider.Name(), linkReq.Model, tries, class, err)
+
+			policy := retryPolicyFor(class)
+			if tries >= policy.MaxAttempts {
+				break
+			}
+			if err := sleepCtx(ctx, policy.Backoff(tries, err)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(links) > 1 {
+		return nil, fmt.Errorf("all %d models in the chain failed, last error: %w", len(links), lastErr)
+	}
+	return nil, fmt.Errorf("failed after %d attempts: %w", attempt, lastErr)
+}
+
+// sendLink sends one attempt to a link and reports the outcome to its breaker
+// An attempt that ends without a verdict, cancelled or panicking, releases its trial instead
+func sendLink(ctx context.Context, p Provider, req *Request, breaker *circuitBreaker, onChunk StreamFunc, attempt int) (resp *Response, err error) {
+	reported := false
+	defer func() {
+		if !reported {
+			breaker.Release()
+		}
+	}()
+
+	if onChunk != nil {
+		resp, err = GenerateStream(ctx, p, req, func(text string) {
+			onChunk(StreamChunk{Text: text, Attempt: attempt})
+		})
+	} else {
+		resp, err = Generate(ctx, p, req)
+	}
+
+	switch {
+	case err == nil:
+		breaker.Success()
+		reported = true
+	case ctx.Err() == nil:
+		breaker.Failure(ClassifyError(err))
+		reported = true
+	}
+	return resp, err
+}
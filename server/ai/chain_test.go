@@ -0,0 +1,123 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// scriptedProvider answers with the next error of its script, then with text
+type scriptedProvider struct {
+	name   string
+	errs   []error
+	calls  int
+	before func(ctx context.Context)
+}
+
+func (p *scriptedProvider) Name() string {
+	return p.name
+}
+
+func (p *scriptedProvider) DefaultModel(purpose Purpose) string {
+	return p.name + "-model"
+}
+
+func (p *scriptedProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	p.calls++
+	if p.before != nil {
+		p.before(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.calls <= len(p.errs) {
+		return nil, p.errs[p.calls-1]
+	}
+	return &Response{Text: "answer from " + p.name}, nil
+}
+
+// testChain builds a chain straight from providers, skipping the registry
+func testChain(providers ...Provider) *Chain {
+	chain := &Chain{}
+	for _, p := range providers {
+		chain.links = append(chain.links, chainLink{provider: p})
+	}
+	return chain
+}
+
+func TestRunFallsBack(t *testing.T) {
+	resetBreakers(t)
+	first := &scriptedProvider{name: "first", errs: []error{&APIError{Status: http.StatusUnauthorized}}}
+	second := &scriptedProvider{name: "second"}
+
+	resp, err := Run(context.Background(), testChain(first, second), &Request{Purpose: PurposeSheet}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.Provider != "second" || resp.Model != "second-model" {
+		t.Errorf("answered by %s/%s, want second/second-model", resp.Provider, resp.Model)
+	}
+	if first.calls != 1 {
+		t.Errorf("auth error was sent %d times, want no retries", first.calls)
+	}
+}
+
+func TestRunSkipsOpenBreaker(t *testing.T) {
+	resetBreakers(t)
+	first := &scriptedProvider{name: "first"}
+	second := &scriptedProvider{name: "second"}
+	for i := 0; i < defaultBreakerThreshold; i++ {
+		breakerFor("first", "first-model").Failure(ErrorServer)
+	}
+
+	resp, err := Run(context.Background(), testChain(first, second), &Request{Purpose: PurposeSheet}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if first.calls != 0 {
+		t.Errorf("open breaker still sent %d requests", first.calls)
+	}
+	if resp.Provider != "second" {
+		t.Errorf("answered by %s, want second", resp.Provider)
+	}
+}
+
+func TestRunAllLinksFail(t *testing.T) {
+	resetBreakers(t)
+	bad := &APIError{Status: http.StatusBadRequest}
+	first := &scriptedProvider{name: "first", errs: []error{bad}}
+	second := &scriptedProvider{name: "second", errs: []error{bad}}
+
+	_, err := Run(context.Background(), testChain(first, second), &Request{Purpose: PurposeSheet}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("Run error = %v, want the last link's bad request", err)
+	}
+}
+
+func TestRunCancelledTrialReleasesBreaker(t *testing.T) {
+	resetBreakers(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	provider := &scriptedProvider{name: "flaky", before: func(context.Context) { cancel() }}
+	breaker := breakerFor("flaky", "flaky-model")
+	tripBreaker(breaker)
+
+	_, err := Run(ctx, provider, &Request{Purpose: PurposeSheet}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if !breaker.Allow() {
+		t.Fatal("cancelled trial left the breaker half-open with no trial to send")
+	}
+
+	breaker.Release()
+	provider.before = nil
+	if _, err := Run(context.Background(), provider, &Request{Purpose: PurposeSheet}, nil); err != nil {
+		t.Fatalf("Run after the cancelled trial: %v", err)
+	}
+	if status := Breakers(); status[0].State != BreakerClosed {
+		t.Errorf("breaker is %s after a successful trial, want closed", status[0].State)
+	}
+}
@@ -0,0 +1,93 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrEmptyResponse is returned when a provider answers without any text
+var ErrEmptyResponse = errors.New("empty response from API")
+
+// ErrIncompleteStream is returned when a stream ends before the provider said it was done
+// The connection was cut mid answer, so it is retried like a network error
+var ErrIncompleteStream = errors.New("stream ended before the final chunk")
+
+// ErrCircuitOpen is returned for a model whose circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// APIError is a non-200 answer from a provider
+type APIError struct {
+	Provider   string
+	Status     int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API returned non-200 status: %d - %s", e.Status, e.Body)
+}
+
+// newAPIError builds an APIError, reading Retry-After when the provider sent one
+func newAPIError(provider string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{Provider: provider, Status: resp.StatusCode, Body: string(body)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// ErrorClass groups errors that deserve the same retry policy
+type ErrorClass string
+
+const (
+	ErrorRateLimit  ErrorClass = "rate_limit"
+	ErrorServer     ErrorClass = "server"
+	ErrorTimeout    ErrorClass = "timeout"
+	ErrorNetwork    ErrorClass = "network"
+	ErrorAuth       ErrorClass = "auth"
+	ErrorBadRequest ErrorClass = "bad_request"
+	ErrorEmpty      ErrorClass = "empty"
+	ErrorUnknown    ErrorClass = "unknown"
+)
+
+// ClassifyError sorts a provider error into an ErrorClass
+func ClassifyError(err error) ErrorClass {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Status == http.StatusTooManyRequests:
+			return ErrorRateLimit
+		case apiErr.Status >= 500:
+			return ErrorServer
+		case apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden:
+			return ErrorAuth
+		case apiErr.Status == http.StatusRequestTimeout:
+			return ErrorTimeout
+		default:
+			return ErrorBadRequest
+		}
+	}
+
+	if errors.Is(err, ErrEmptyResponse) {
+		return ErrorEmpty
+	}
+	if errors.Is(err, ErrIncompleteStream) {
+		return ErrorNetwork
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+	return ErrorUnknown
+}
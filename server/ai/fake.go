@@ -0,0 +1,208 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FakeSheetResponse is the canned worksheet returned by the fake provider
+// It compiles with Tectonic so the whole queue -> AI -> LaTeX -> PDF pipeline can run offline
+const FakeSheetResponse = `<Output>
+\documentclass[12pt]{article}
+
+\usepackage[margin=1in]{geometry}
+\usepackage{amsmath,amssymb,amsthm}
+\usepackage{enumitem}
+\usepackage{xcolor}
+\usepackage{tcolorbox}
+
+\definecolor{primary}{RGB}{25,103,210}
+\definecolor{light}{RGB}{242,242,242}
+
+\setlength{\parindent}{0pt}
+\setlength{\parskip}{6pt}
+
+\title{\textcolor{primary}{\Large Fake Worksheet}}
+\author{Course: Test Course}
+\date{}
+
+\begin{document}
+
+\maketitle
+
+\begin{tcolorbox}[colback=light,colframe=primary]
+\textbf{Instructions:} This worksheet was produced by the fake AI provider.
+\end{tcolorbox}
+
+\section{Warm up}
+\begin{enumerate}[label=\arabic*.]
+    \item Compute $2 + 3$.
+    \item Solve $x^2 = 9$ for $x > 0$.
+\end{enumerate}
+
+\section{Knowledge Check}
+\begin{itemize}
+    \item Explain why $a^2 - b^2 = (a - b)(a + b)$.
+\end{itemize}
+
+\end{document}
+</Output>
+
+<meta-data>
+Subject: Testing
+Level: Any
+EstimatedTime: 5 minutes
+Keywords: fake,test,offline
+Notes: Canned response from the fake provider
+</meta-data>`
+
+// FakeProvider returns canned responses without any network access
+// Responses can be set per purpose, anything missing falls back to the built in answers
+type FakeProvider struct {
+	Responses map[Purpose]string
+}
+
+func init() {
+	RegisterProvider("fake", newFakeProvider)
+}
+
+// newFakeProvider loads optional canned answers from AI_FAKE_DIR (sheet.txt, helper.txt, fix.txt)
+func newFakeProvider(cfg ProviderConfig) (Provider, error) {
+	fake := &FakeProvider{Responses: make(map[Purpose]string)}
+
+	dir := configString("AI_FAKE_DIR")
+	if dir == "" {
+		return fake, nil
+	}
+
+	for _, purpose := range []Purpose{PurposeSheet, PurposeHelper, PurposeFix} {
+		data, err := os.ReadFile(filepath.Join(dir, string(purpose)+".txt"))
+		if err == nil {
+			fake.Responses[purpose] = string(data)
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read fake %s response: %w", purpose, err)
+		}
+	}
+	return fake, nil
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) DefaultModel(purpose Purpose) string {
+	return "fake-" + string(purpose)
+}
+
+func (f *FakeProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	text, ok := f.Responses[req.Purpose]
+	if !ok {
+		text = fakeAnswer(req)
+		if req.Schema != nil {
+			text = fakeStructuredAnswer(req.Schema, text)
+		}
+	}
+
+	// Roughly four characters per token, good enough for exercising usage accounting
+	usage := Usage{
+		PromptTokens:     (len(req.SystemPrompt) + len(req.UserPrompt)) / 4,
+		CompletionTokens: len(text) / 4,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return &Response{
+		Text:     text,
+		Model:    req.Model,
+		Provider: f.Name(),
+		Usage:    usage,
+	}, nil
+}
+
+// SupportsSchema is true so structured mode can be exercised offline
+func (f *FakeProvider) SupportsSchema() bool {
+	return true
+}
+
+// fakeAnswer builds a deterministic answer from the request itself
+func fakeAnswer(req *Request) string {
+	switch req.Purpose {
+	case PurposeSheet:
+		return FakeSheetResponse
+	case PurposeFix:
+		// In patch mode answer with a diff that changes nothing
+		const numberedMarker = "LATEX DOCUMENT (numbered lines):\n"
+		if idx := strings.LastIndex(req.UserPrompt, numberedMarker); idx >= 0 {
+			first := strings.SplitN(req.UserPrompt[idx+len(numberedMarker):], "\n", 2)[0]
+			if parts := strings.SplitN(first, " | ", 2); len(parts) == 2 {
+				return "@@ -1,1 +1,1 @@\n " + parts[1]
+			}
+		}
+
+		// Hand the document back unchanged
+		const marker = "LATEX DOCUMENT:\n"
+		if idx := strings.LastIndex(req.UserPrompt, marker); idx >= 0 {
+			return req.UserPrompt[idx+len(marker):]
+		}
+		return req.UserPrompt
+	default:
+		if strings.Contains(req.SystemPrompt, "JSON array") {
+			return `["fake", "test", "offline"]`
+		}
+		return "Fake " + firstPromptValue(req.UserPrompt)
+	}
+}
+
+// firstPromptValue returns the value of the first "Key: value" line of a helper prompt
+func firstPromptValue(prompt string) string {
+	for _, line := range strings.Split(prompt, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return "response"
+}
+
+// fakeStructuredAnswer wraps the plain fake answer in JSON matching the schema
+func fakeStructuredAnswer(schema *Schema, text string) string {
+	var value interface{}
+	switch schema.Name {
+	case WorksheetSchema.Name:
+		latex, _, err := extractContent(text)
+		if err != nil {
+			latex = text
+		}
+		value = WorksheetOutput{
+			LaTeX:         latex,
+			Title:         "Fake Worksheet",
+			Subject:       "Testing",
+			Level:         "Any",
+			EstimatedTime: "5 minutes",
+			Keywords:      []string{"fake", "test", "offline"},
+			Notes:         "Canned response from the fake provider",
+			Questions: []WorksheetQuestion{
+				{Number: 1, Section: "Warm up", Type: "short-answer", Difficulty: "easy", Prompt: "Compute 2 + 3.", Answer: "5"},
+				{Number: 2, Section: "Warm up", Type: "short-answer", Difficulty: "easy", Prompt: "Solve x^2 = 9 for x > 0.", Answer: "3"},
+				{Number: 3, Section: "Knowledge Check", Type: "long-answer", Difficulty: "medium", Prompt: "Explain why a^2 - b^2 = (a - b)(a + b).", Answer: "Expand the right hand side"},
+			},
+		}
+	case TagsSchema.Name:
+		value = map[string][]string{"tags": {"fake", "test", "offline"}}
+	default:
+		return text
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return text
+	}
+	return string(data)
+}
@@ -0,0 +1,267 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Gemini REST base URL, overridable through AI_BASE_URL
+	GeminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+	// Model used for the quick helper generations
+	GeminiHelperModel = "gemini-2.5-flash-lite"
+)
+
+// GeminiRequest represents the request body for Gemini API
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiInstruction      `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiContent represents a content part
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart represents a text part
+type GeminiPart struct {
+	Text string `json:"text"`
+}
+
+// GeminiInstruction represents the system instruction
+type GeminiInstruction struct {
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiGenerationConfig holds the sampling options
+type GeminiGenerationConfig struct {
+	Temperature        *float32               `json:"temperature,omitempty"`
+	MaxOutputTokens    int                    `json:"maxOutputTokens,omitempty"`
+	ResponseMimeType   string                 `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema map[string]interface{} `json:"responseJsonSchema,omitempty"`
+}
+
+// GeminiResponse represents the response from Gemini API
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+// GeminiUsageMetadata holds the token counts of a response
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// GeminiCandidate represents a candidate response
+type GeminiCandidate struct {
+	Content GeminiContent `json:"content"`
+}
+
+// geminiProvider talks to the Gemini generateContent REST API
+type geminiProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func init() {
+	RegisterProvider("gemini", newGeminiProvider)
+}
+
+func newGeminiProvider(cfg ProviderConfig) (Provider, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("missing API key for Gemini, set AI_API in set.json")
+	}
+
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = GeminiBaseURL
+	}
+
+	return &geminiProvider{
+		apiKey:  cfg.APIKey,
+		baseURL: baseURL,
+		// Sheet generation can take minutes, the caller's context does the real limiting
+		client: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (g *geminiProvider) Name() string {
+	return "gemini"
+}
+
+func (g *geminiProvider) DefaultModel(purpose Purpose) string {
+	if purpose == PurposeHelper {
+		return GeminiHelperModel
+	}
+	return DefaultModel
+}
+
+func (g *geminiProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := g.post(ctx, req, "generateContent")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(respBody, &geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	text := geminiResp.text()
+	if text == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	return &Response{
+		Text:     text,
+		Model:    req.Model,
+		Provider: g.Name(),
+		Usage:    geminiResp.usage(),
+	}, nil
+}
+
+// GenerateStream uses streamGenerateContent over SSE, calling onText for every piece
+func (g *geminiProvider) GenerateStream(ctx context.Context, req *Request, onText func(text string)) (*Response, error) {
+	resp, err := g.post(ctx, req, "streamGenerateContent")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var usage Usage
+	err = readSSE(resp.Body, func(data []byte) error {
+		var event GeminiResponse
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		// Every event carries the running totals, the last one wins
+		if event.UsageMetadata != nil {
+			usage = event.usage()
+		}
+		if piece := event.text(); piece != "" {
+			text.WriteString(piece)
+			onText(piece)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if text.Len() == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	return &Response{
+		Text:     text.String(),
+		Model:    req.Model,
+		Provider: g.Name(),
+		Usage:    usage,
+	}, nil
+}
+
+// SupportsSchema is true, Gemini takes a JSON schema through responseJsonSchema
+func (g *geminiProvider) SupportsSchema() bool {
+	return true
+}
+
+// post sends the request to the given Gemini method and checks the status code
+func (g *geminiProvider) post(ctx context.Context, req *Request, method string) (*http.Response, error) {
+	body := GeminiRequest{
+		Contents: []GeminiContent{
+			{
+				Role:  "user",
+				Parts: []GeminiPart{{Text: req.UserPrompt}},
+			},
+		},
+	}
+	if req.SystemPrompt != "" {
+		body.SystemInstruction = &GeminiInstruction{
+			Parts: []GeminiPart{{Text: req.SystemPrompt}},
+		}
+	}
+	if req.Temperature != nil || req.MaxOutputTokens > 0 || req.Schema != nil {
+		body.GenerationConfig = &GeminiGenerationConfig{
+			Temperature:     req.Temperature,
+			MaxOutputTokens: req.MaxOutputTokens,
+		}
+		if req.Schema != nil {
+			body.GenerationConfig.ResponseMimeType = "application/json"
+			body.GenerationConfig.ResponseJSONSchema = req.Schema.Definition
+		}
+	}
+
+	reqJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/models/%s:%s?key=%s", g.baseURL, req.Model, method, g.apiKey)
+	if method == "streamGenerateContent" {
+		url += "&alt=sse"
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	log.Printf("[DEBUG] Sending %s request to Gemini API with model: %s (%d bytes)", method, req.Model, len(reqJSON))
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(g.Name(), resp, respBody)
+	}
+
+	return resp, nil
+}
+
+// text joins the parts of the first candidate, long answers can be split over several
+func (r *GeminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, p := range r.Candidates[0].Content.Parts {
+		text.WriteString(p.Text)
+	}
+	return text.String()
+}
+
+// usage converts the usage metadata, if Gemini sent any
+func (r *GeminiResponse) usage() Usage {
+	if r.UsageMetadata == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     r.UsageMetadata.PromptTokenCount,
+		CompletionTokens: r.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:      r.UsageMetadata.TotalTokenCount,
+	}
+}
@@ -5,30 +5,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"regexp"
 _	"strconv"
 	"strings"
-	"time"
 
 	"nadhi.dev/sarvar/fun/latex"
+	"nadhi.dev/sarvar/fun/prompts"
 )
 
 const (
-	// Default model to use for sheet generation
+	// Default Gemini model for sheet generation, AI_MODEL or AI_CHAIN pick others
 	DefaultModel = "gemini-2.5-pro"
-
-	// Gemini API endpoint
-	GeminiEndpoint = "https://generativelanguage.googleapis.com/v1/models/%s:generateContent?key=%s"
-
-	// Maximum retries for API requests
-	MaxRetries = 3
-
-	// Delay between retries
-	RetryDelay = 2 * time.Second
 )
 
 // GenerationRequest represents the request structure for sheet generation
@@ -39,267 +28,185 @@ type GenerationRequest struct {
 	Tags                []string `json:"tags"`
 	Curriculum          string   `json:"curriculum"`
 	SpecialInstructions string   `json:"specialInstructions"`
+
+	// NotebookID is the notebook the sheet is generated for, its pinned prompt versions are copied in at enqueue time
+	NotebookID     int            `json:"notebookId,omitempty"`
+	PromptVersions map[string]int `json:"promptVersions,omitempty"`
+
+	// Engine is the LaTeX engine to compile with, empty for the server default
+	Engine string `json:"engine,omitempty"`
+
+	// Batch jobs wait for interactive ones, for bulk generation nobody is watching live
+	Batch bool `json:"batch,omitempty"`
+
+	// Review holds the extracted LaTeX for the user to approve before it is compiled
+	Review bool `json:"review,omitempty"`
 }
 
 // GenerationResult contains the generated content and metadata
 type GenerationResult struct {
 	LaTeX    string            `json:"latex"`
 	Metadata map[string]string `json:"metadata"`
-}
 
-// GenerateSheet takes user input and produces LaTeX content using Gemini 2.5 Pro
-func GenerateSheet(apiKey string, request *GenerationRequest) (*GenerationResult, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("API key is required")
-	}
+	// Worksheet is only set when the answer came back as validated JSON
+	Worksheet  *WorksheetOutput `json:"worksheet,omitempty"`
+	OutputMode string           `json:"outputMode"`
 
-	if request == nil {
-		return nil, fmt.Errorf("generation request cannot be nil")
-	}
+	// PromptVersion is the version of the sheet template that produced this result
+	PromptVersion int `json:"promptVersion"`
 
-	model := DefaultModel
-	systemPrompt := buildSystemPrompt()
-	userPrompt := buildUserPrompt(request)
+	// Provider and Model are the chain link that actually answered
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
 
-	log.Printf("Generating sheet with model: %s", model)
+// GenerateSheet takes user input and produces LaTeX content using the given provider
+func GenerateSheet(ctx context.Context, provider Provider, request *GenerationRequest) (*GenerationResult, error) {
+	return GenerateSheetStream(ctx, provider, request, nil)
+}
 
-	response, err := generateGeminiResponse(apiKey, model, systemPrompt, userPrompt, MaxRetries)
+// GenerateSheetStream is GenerateSheet that hands partial output to onChunk while it is written
+func GenerateSheetStream(ctx context.Context, provider Provider, request *GenerationRequest, onChunk StreamFunc) (*GenerationResult, error) {
+	prompt, err := BuildSheetPrompt(ctx, provider, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate content: %w", err)
+		return nil, err
 	}
-
-	// Parse the response to extract LaTeX content and metadata
-	latex, metadata, err := extractContent(response)
+	response, err := GenerateFromPrompt(ctx, provider, prompt, onChunk)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse generated content: %w", err)
+		return nil, err
+	}
+	result, err := ParseSheetResponse(response.Text, prompt.Structured)
+	if err != nil {
+		return nil, err
 	}
+	result.PromptVersion = prompt.Version
+	result.Provider = response.Provider
+	result.Model = response.Model
+	return result, nil
+}
 
-	return &GenerationResult{
-		LaTeX:    latex,
-		Metadata: metadata,
-	}, nil
+// SheetPrompt is a rendered sheet prompt, kept so generation can run again without rendering it
+type SheetPrompt struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	System  string `json:"system"`
+	User    string `json:"user"`
+	// Structured prompts ask for the JSON worksheet, for providers that support schemas
+	Structured bool `json:"structured"`
 }
 
-// buildSystemPrompt creates the system prompt for the Gemini model
-func buildSystemPrompt() string {
-    return `You are a professional educator and LaTeX expert. Your task is to generate 
-a comprehensive educational worksheet that will maximize student learning outcomes.
-
-IMPORTANT: Your response MUST follow this exact format with NO DEVIATIONS:
-
-<Output>
-\\documentclass[12pt]{article}
-
-% Essential packages for reliable compilation
-\\usepackage[margin=1in]{geometry}
-\\usepackage{amsmath,amssymb,amsthm}
-\\usepackage{graphicx}
-\\usepackage{enumitem}
-\\usepackage{xcolor}
-\\usepackage{tcolorbox}
-\\usepackage{hyperref}
-
-% Document styling for visual appeal
-\\definecolor{primary}{RGB}{25,103,210}
-\\definecolor{secondary}{RGB}{234,67,53}
-\\definecolor{accent}{RGB}{251,188,4}
-\\definecolor{light}{RGB}{242,242,242}
-
-\\hypersetup{colorlinks=true,linkcolor=primary}
-\\setlength{\\parindent}{0pt}
-\\setlength{\\parskip}{6pt}
-
-\\title{\\textcolor{primary}{\\Large TITLE_OF_WORKSHEET}}
-\\author{\\textcolor{secondary}{Course: COURSE_NAME}}
-\\date{\\today}
-
-\\begin{document}
-
-\\maketitle
-
-\\begin{tcolorbox}[colback=light,colframe=primary]
-\\textbf{Instructions:} Clear instructions here...
-\\end{tcolorbox}
-
-% ... complete worksheet content organized in sections ...
-
-\\end{document}
-</Output>
-
-<meta-data>
-Subject: The subject of the worksheet
-Level: Educational level
-EstimatedTime: Estimated completion time
-Keywords: comma,separated,keywords
-Notes: Teacher notes
-</meta-data>
-
-STRICT REQUIREMENTS:
-1. Focus EXCLUSIVELY on content quality and LaTeX correctness
-2. Always use the provided LaTeX packages - DO NOT add custom package imports
-3. Create syntactically perfect, compilable LaTeX with no undefined commands
-4. Replace placeholder text (TITLE_OF_WORKSHEET, COURSE_NAME) appropriately
-5. Organize content with clear section headings (\\section{}, \\subsection{})
-6. Use \\begin{enumerate}[label=\\arabic*.] or \\begin{itemize} for lists
-7. Include white space appropriately for readability
-
-EDUCATIONAL BEST PRACTICES:
-1. Start with easier questions and gradually increase difficulty
-2. Include worked examples before challenging problems
-3. Cover all curriculum topics specified by the user
-4. Design content that targets common misconceptions
-5. Use color strategically to highlight important concepts
-6. Create visually distinct sections for different types of activities
-7. Include "Knowledge Check" questions throughout the document`
+// SheetResponse is the raw answer to a sheet prompt and the chain link that gave it
+type SheetResponse struct {
+	Text     string `json:"text"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
 }
 
-// buildUserPrompt creates the user prompt based on the generation request
-func buildUserPrompt(request *GenerationRequest) string {
-	tagsStr := strings.Join(request.Tags, ", ")
+// BuildSheetPrompt renders the sheet prompt for a request
+func BuildSheetPrompt(ctx context.Context, provider Provider, request *GenerationRequest) (*SheetPrompt, error) {
+	if provider == nil {
+		return nil, fmt.Errorf("AI provider is required")
+	}
 
-	return fmt.Sprintf(`Please create an educational worksheet with the following specifications, make sure you do your research on this material:
+	if request == nil {
+		return nil, fmt.Errorf("generation request cannot be nil")
+	}
 
-Subject: %s
-Course: %s
-Description: %s
-Tags/Keywords: %s
+	structured := useSchema(provider)
+	rendered, err := buildPrompts(ctx, request, structured)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build prompts: %w", err)
+	}
+	return &SheetPrompt{
+		Name:       rendered.Name,
+		Version:    rendered.Version,
+		System:     rendered.System,
+		User:       rendered.User,
+		Structured: structured,
+	}, nil
+}
 
-Curriculum Topics to Cover:
-%s
+// GenerateFromPrompt sends a sheet prompt, onChunk gets partial output while it is written
+func GenerateFromPrompt(ctx context.Context, provider Provider, prompt *SheetPrompt, onChunk StreamFunc) (*SheetResponse, error) {
+	if provider == nil {
+		return nil, fmt.Errorf("AI provider is required")
+	}
+	req := &Request{
+		Purpose:      PurposeSheet,
+		Model:        ResolveModel(provider, PurposeSheet),
+		SystemPrompt: prompt.System,
+		UserPrompt:   prompt.User,
+	}
+	if prompt.Structured {
+		req.Schema = WorksheetSchema
+	}
 
-Special Instructions:
-%s
+	log.Printf("Generating sheet with provider: %s, model: %s, structured: %v, prompt: %s v%d", provider.Name(), req.Model, prompt.Structured, prompt.Name, prompt.Version)
 
-Remember to provide the content in the required format with both the LaTeX code and metadata.`,
-		request.Subject,
-		request.Course,
-		request.Description,
-		tagsStr,
-		request.Curriculum,
-		request.SpecialInstructions)
+	response, err := generateWithRetry(ctx, provider, req, onChunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate content: %w", err)
+	}
+	return &SheetResponse{Text: response.Text, Provider: response.Provider, Model: response.Model}, nil
 }
 
-// geminiRequest represents the request structure for the Gemini API
-type geminiRequest struct {
-	Contents []geminiContent `json:"contents"`
-}
+// ParseSheetResponse reads a sheet answer, structured answers that fail validation
+// go through the tag parser in case the model ignored the schema
+func ParseSheetResponse(text string, structured bool) (*GenerationResult, error) {
+	var structuredErr error
+	if structured {
+		worksheet, err := ParseWorksheet(text)
+		if err == nil {
+			return &GenerationResult{
+				LaTeX:      worksheet.LaTeX,
+				Metadata:   worksheet.Metadata(),
+				Worksheet:  worksheet,
+				OutputMode: OutputModeStructured,
+			}, nil
+		}
+		structuredErr = err
+		log.Printf("[WARNING] Structured sheet output rejected, falling back to tag parser: %v", err)
+	}
 
-type geminiContent struct {
-	Role  string `json:"role"`
-	Parts []part `json:"parts"`
-}
+	// Parse the response to extract LaTeX content and metadata
+	latex, metadata, err := extractContent(text)
+	if err != nil {
+		if structuredErr != nil {
+			return nil, fmt.Errorf("failed to parse generated content: %v; %w", structuredErr, err)
+		}
+		return nil, fmt.Errorf("failed to parse generated content: %w", err)
+	}
 
-type part struct {
-	Text string `json:"text"`
+	return &GenerationResult{
+		LaTeX:      latex,
+		Metadata:   metadata,
+		OutputMode: OutputModeTags,
+	}, nil
 }
 
-// geminiResponse represents the response structure from the Gemini API
-type geminiResponse struct {
-	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-	} `json:"candidates"`
+// sheetPromptData is what the sheet template renders with
+type sheetPromptData struct {
+	*GenerationRequest
+	Structured bool
 }
 
-// generateGeminiResponse calls the Gemini API to generate content
-func generateGeminiResponse(apiKey, model, systemPrompt, userPrompt string, maxRetries int) (string, error) {
-    url := fmt.Sprintf(GeminiEndpoint, model, apiKey)
-
-    // Combine system prompt and user prompt since Gemini doesn't support system role
-    combinedPrompt := systemPrompt + "\n\n" + userPrompt
-
-    // Create request with valid roles only
-    reqBody := geminiRequest{
-        Contents: []geminiContent{
-            {
-                Role: "user", // Use "user" role instead of "system"
-                Parts: []part{
-                    {Text: combinedPrompt},
-                },
-            },
-        },
-    }
+// buildPrompts renders the sheet template, using the version pinned in ctx if there is one
+// In structured mode the template asks for the JSON worksheet instead of the tags
+func buildPrompts(ctx context.Context, request *GenerationRequest, structured bool) (*prompts.Rendered, error) {
+	return prompts.RenderCtx(ctx, prompts.Sheet, sheetPromptData{GenerationRequest: request, Structured: structured})
+}
 
-    reqJSON, err := json.Marshal(reqBody)
+// generateWithRetry sends the request through Run, which retries per error class
+// and falls back down the chain when the provider is one
+// When onChunk is set the response is streamed, chunks carry the attempt they belong to
+func generateWithRetry(ctx context.Context, provider Provider, req *Request, onChunk StreamFunc) (*Response, error) {
+    resp, err := Run(ctx, provider, req, onChunk)
     if err != nil {
-        return "", fmt.Errorf("failed to marshal request: %w", err)
-    }
-
-    // Debug request being sent
-    log.Printf("[DEBUG] Sending request to Gemini API with model: %s", model)
-    log.Printf("[DEBUG] Request length: %d bytes", len(reqJSON))
-
-    var response string
-    var lastErr error
-
-    // Implement retry logic
-    for attempt := 0; attempt < maxRetries; attempt++ {
-        if attempt > 0 {
-            log.Printf("Retrying API request (attempt %d/%d)", attempt+1, maxRetries)
-            time.Sleep(RetryDelay * time.Duration(attempt)) // Increase delay with each retry
-        }
-
-        req, err := http.NewRequest("POST", url, strings.NewReader(string(reqJSON)))
-        if err != nil {
-            lastErr = err
-            log.Printf("[ERROR] Failed to create request: %v", err)
-            continue
-        }
-
-        req.Header.Set("Content-Type", "application/json")
-
-		 // Set a longer timeout for the HTTP client
-		 // Longer timeouts aka timeout
-        client := &http.Client{Timeout: 6000 * time.Second}
-        resp, err := client.Do(req)
-        if err != nil {
-            lastErr = err
-            log.Printf("[ERROR] Failed to execute request: %v", err)
-            continue
-        }
-
-        // Read the response body for debugging if there's an error
-        var respBody []byte
-        if resp.StatusCode != http.StatusOK {
-            respBody, _ = io.ReadAll(resp.Body)
-            resp.Body.Close()
-            
-            log.Printf("[ERROR] API returned status %d: %s", resp.StatusCode, string(respBody))
-            lastErr = fmt.Errorf("API returned non-200 status: %d - %s", resp.StatusCode, string(respBody))
-            
-            // For rate limiting errors, increase the delay significantly
-            if resp.StatusCode == 429 {
-                log.Printf("[WARN] Rate limited by API, increasing delay before retry")
-                time.Sleep(5 * time.Second) // Add extra delay for rate limiting
-            }
-            
-            continue
-        }
-
-        var geminiResp geminiResponse
-        if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
-            lastErr = err
-            log.Printf("[ERROR] Failed to decode API response: %v", err)
-            resp.Body.Close()
-            continue
-        }
-        resp.Body.Close()
-
-        if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
-            lastErr = fmt.Errorf("empty response from API")
-            log.Printf("[ERROR] Empty response from API")
-            continue
-        }
-
-        response = geminiResp.Candidates[0].Content.Parts[0].Text
-        log.Printf("[DEBUG] Successfully received response from Gemini API (length: %d bytes)", len(response))
-        return response, nil
+        return nil, err
     }
 
-    return "", fmt.Errorf("failed after %d attempts: %w", maxRetries, lastErr)
+    log.Printf("[DEBUG] Successfully received response from %s/%s (length: %d bytes)", resp.Provider, resp.Model, len(resp.Text))
+    return resp, nil
 }
 
 // extractContent parses the generated response to extract LaTeX and metadata
@@ -344,7 +251,7 @@ func extractContent(response string) (string, map[string]string, error) {
 }
 
 // GenerateSheetForQueue is used by the sheet queue system to process generation requests
-func GenerateSheetForQueue(ctx context.Context, apiKey string, request *GenerationRequest) (*GenerationResult, error) {
+func GenerateSheetForQueue(ctx context.Context, provider Provider, request *GenerationRequest, onChunk StreamFunc) (*GenerationResult, error) {
 	log.Printf("[DEBUG] Starting GenerateSheetForQueue")
 
 	if request == nil {
@@ -364,13 +271,13 @@ func GenerateSheetForQueue(ctx context.Context, apiKey string, request *Generati
 	// Run generation in a goroutine to respect context cancellation
 	go func() {
 		log.Printf("[DEBUG] Starting generation goroutine")
-		result, err := GenerateSheet(apiKey, request)
+		result, err := GenerateSheetStream(ctx, provider, request, onChunk)
 		if err != nil {
-			log.Printf("[ERROR] GenerateSheet failed: %v", err)
+			log.Printf("[ERROR] GenerateSheetStream failed: %v", err)
 			errChan <- err
 			return
 		}
-		log.Printf("[DEBUG] GenerateSheet succeeded")
+		log.Printf("[DEBUG] GenerateSheetStream succeeded")
 		resultChan <- result
 	}()
 
@@ -418,7 +325,7 @@ func SaveGeneratedContent(outputDir, jobID string, result *GenerationResult) err
 	return nil
 }
 
-func ProcessGeminiGeneration(ctx context.Context, apiKey string, jobID string, req *GenerationRequest) (map[string]interface{}, error) {
+func ProcessGeminiGeneration(ctx context.Context, provider Provider, jobID string, req *GenerationRequest, onChunk StreamFunc) (map[string]interface{}, error) {
     log.Printf("[DEBUG] Starting ProcessGeminiGeneration for job ID: %s", jobID)
 
     // Debug the request content
@@ -437,7 +344,7 @@ func ProcessGeminiGeneration(ctx context.Context, apiKey string, jobID string, r
 
     // Generate sheet content
     log.Printf("[DEBUG] Calling GenerateSheetForQueue for job ID: %s", jobID)
-    result, err := GenerateSheetForQueue(ctx, apiKey, req)
+    result, err := GenerateSheetForQueue(ctx, provider, req, onChunk)
     if err != nil {
         log.Printf("[ERROR] Generation failed for job ID %s: %v", jobID, err)
         return nil, fmt.Errorf("generation failed: %w", err)
@@ -470,6 +377,11 @@ func ProcessGeminiGeneration(ctx context.Context, apiKey string, jobID string, r
         "latexPath":  fmt.Sprintf("%s/%s.tex", outputDir, jobID),
         "metaPath":   fmt.Sprintf("%s/%s.meta.json", outputDir, jobID),
         "metadata":   result.Metadata,
+        "worksheet":  result.Worksheet,
+        "outputMode": result.OutputMode,
+        "promptVersion": result.PromptVersion,
+        "provider":   result.Provider,
+        "model":      result.Model,
         "parseInfo":  parseResult,
         "successful": true,
         "latexContent": result.LaTeX, // Include the raw LaTeX content
@@ -0,0 +1,30 @@
+package ai
+
+import (
+	"context"
+
+	"nadhi.dev/sarvar/fun/latex"
+)
+
+// latexFixer adapts a Provider to the latex.Fixer interface
+type latexFixer struct {
+	provider Provider
+}
+
+// LatexFixer returns a latex.Fixer that sends repair prompts through the provider
+func LatexFixer(provider Provider) latex.Fixer {
+	return &latexFixer{provider: provider}
+}
+
+func (f *latexFixer) FixLatex(ctx context.Context, prompt string) (string, error) {
+	resp, err := Generate(ctx, f.provider, &Request{
+		Purpose:         PurposeFix,
+		UserPrompt:      prompt,
+		Temperature:     Float32(0.2), // Lower temperature for more deterministic results
+		MaxOutputTokens: 2048,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
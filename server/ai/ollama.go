@@ -0,0 +1,262 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// Ollama listens here by default, overridable through AI_BASE_URL
+	OllamaBaseURL = "http://localhost:11434"
+
+	// Models pulled by default in our classroom images
+	OllamaDefaultModel = "llama3.1:8b"
+	OllamaHelperModel  = "llama3.2:3b"
+)
+
+// ollamaOptions are the sampling options Ollama understands
+type ollamaOptions struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+}
+
+// ollamaMessage is a single /api/chat message
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ollamaChatRequest is the body of an /api/chat request
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   interface{}     `json:"format,omitempty"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+}
+
+// ollamaGenerateRequest is the body of an /api/generate request
+type ollamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	System  string         `json:"system,omitempty"`
+	Stream  bool           `json:"stream"`
+	Format  interface{}    `json:"format,omitempty"`
+	Options *ollamaOptions `json:"options,omitempty"`
+}
+
+// ollamaChunk is one NDJSON line of a streamed response
+// /api/chat fills Message, /api/generate fills Response
+type ollamaChunk struct {
+	Model    string        `json:"model"`
+	Message  ollamaMessage `json:"message"`
+	Response string        `json:"response"`
+	Done     bool          `json:"done"`
+	Error    string        `json:"error"`
+
+	// Only set on the final chunk
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// ollamaProvider talks to a local Ollama style server, no network access needed
+type ollamaProvider struct {
+	apiKey   string
+	baseURL  string
+	endpoint string
+	client   *http.Client
+}
+
+func init() {
+	RegisterProvider("ollama", newOllamaProvider)
+}
+
+func newOllamaProvider(cfg ProviderConfig) (Provider, error) {
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = OllamaBaseURL
+	}
+
+	// AI_OLLAMA_API picks between /api/chat (default) and /api/generate
+	endpoint := "chat"
+	if configString("AI_OLLAMA_API") == "generate" {
+		endpoint = "generate"
+	}
+
+	return &ollamaProvider{
+		apiKey:   cfg.APIKey,
+		baseURL:  baseURL,
+		endpoint: endpoint,
+		// Local models on classroom hardware are slow, streaming keeps the connection busy
+		client: &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+func (o *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+func (o *ollamaProvider) DefaultModel(purpose Purpose) string {
+	if purpose == PurposeHelper {
+		return OllamaHelperModel
+	}
+	return OllamaDefaultModel
+}
+
+// SupportsSchema is true, the format field takes a JSON schema
+func (o *ollamaProvider) SupportsSchema() bool {
+	return true
+}
+
+func (o *ollamaProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return o.GenerateStream(ctx, req, nil)
+}
+
+// GenerateStream reads Ollama's NDJSON stream, calling onText for every piece when it is set
+func (o *ollamaProvider) GenerateStream(ctx context.Context, req *Request, onText func(text string)) (*Response, error) {
+	var options *ollamaOptions
+	if req.Temperature != nil || req.MaxOutputTokens > 0 {
+		options = &ollamaOptions{
+			Temperature: req.Temperature,
+			NumPredict:  req.MaxOutputTokens,
+		}
+	}
+
+	// format takes a full JSON schema since Ollama 0.5
+	var format interface{}
+	if req.Schema != nil {
+		format = req.Schema.Definition
+	}
+
+	var body interface{}
+	if o.endpoint == "generate" {
+		body = ollamaGenerateRequest{
+			Model:   req.Model,
+			Prompt:  req.UserPrompt,
+			System:  req.SystemPrompt,
+			Stream:  true,
+			Format:  format,
+			Options: options,
+		}
+	} else {
+		chat := ollamaChatRequest{
+			Model:   req.Model,
+			Stream:  true,
+			Format:  format,
+			Options: options,
+		}
+		if req.SystemPrompt != "" {
+			chat.Messages = append(chat.Messages, ollamaMessage{Role: "system", Content: req.SystemPrompt})
+		}
+		chat.Messages = append(chat.Messages, ollamaMessage{Role: "user", Content: req.UserPrompt})
+		body = chat
+	}
+
+	reqJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/%s", o.baseURL, o.endpoint)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	log.Printf("[DEBUG] Sending request to Ollama (%s) with model: %s (%d bytes)", url, req.Model, len(reqJSON))
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, newAPIError(o.Name(), resp, respBody)
+	}
+
+	var text strings.Builder
+	var usage Usage
+	done := false
+	model := req.Model
+	err = readNDJSON(resp.Body, func(line []byte) error {
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Done {
+			done = true
+			usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+		piece := chunk.Message.Content + chunk.Response
+		if piece != "" {
+			text.WriteString(piece)
+			if onText != nil {
+				onText(piece)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		// Partial text would pass for a whole answer, ask again instead
+		return nil, fmt.Errorf("ollama stream cut after %d bytes: %w", text.Len(), ErrIncompleteStream)
+	}
+
+	if text.Len() == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	return &Response{
+		Text:     text.String(),
+		Model:    model,
+		Provider: o.Name(),
+		Usage:    usage,
+	}, nil
+}
+
+// readNDJSON calls fn for every non-empty line of a newline delimited JSON stream
+func readNDJSON(r io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	// A single chunk can carry a long piece of LaTeX
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ollamaServer answers every request with the given NDJSON lines
+func ollamaServer(t *testing.T, lines string) *ollamaProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(lines))
+	}))
+	t.Cleanup(server.Close)
+	return &ollamaProvider{baseURL: server.URL, endpoint: "chat", client: server.Client()}
+}
+
+func TestOllamaStream(t *testing.T) {
+	o := ollamaServer(t, `{"model":"llama3.1:8b","message":{"role":"assistant","content":"Hello"},"done":false}
+{"model":"llama3.1:8b","message":{"role":"assistant","content":" world"},"done":false}
+{"model":"llama3.1:8b","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":2}
+`)
+
+	var pieces []string
+	resp, err := o.GenerateStream(context.Background(), &Request{Model: "llama3.1:8b", UserPrompt: "Hi"}, func(text string) {
+		pieces = append(pieces, text)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if resp.Text != "Hello world" || len(pieces) != 2 {
+		t.Errorf("got %q in %d pieces, want \"Hello world\" in 2", resp.Text, len(pieces))
+	}
+	if resp.Usage != (Usage{PromptTokens: 7, CompletionTokens: 2, TotalTokens: 9}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestOllamaStreamCut(t *testing.T) {
+	o := ollamaServer(t, `{"model":"llama3.1:8b","message":{"role":"assistant","content":"\\documentclass{article}"},"done":false}
+`)
+
+	_, err := o.GenerateStream(context.Background(), &Request{Model: "llama3.1:8b", UserPrompt: "Hi"}, nil)
+	if !errors.Is(err, ErrIncompleteStream) {
+		t.Fatalf("GenerateStream error = %v, want ErrIncompleteStream", err)
+	}
+	if class := ClassifyError(err); class != ErrorNetwork {
+		t.Errorf("cut stream classified as %s, want %s so it is retried", class, ErrorNetwork)
+	}
+}
@@ -0,0 +1,293 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openAIPreset describes one OpenAI-compatible server
+type openAIPreset struct {
+	baseURL     string
+	model       string
+	helperModel string
+	needsKey    bool
+	noSchema    bool
+}
+
+// openAIPresets are the servers that speak /v1/chat/completions
+// AI_BASE_URL overrides the base URL of any of them
+var openAIPresets = map[string]openAIPreset{
+	"openai":   {baseURL: "https://api.openai.com/v1", model: "gpt-4o", helperModel: "gpt-4o-mini", needsKey: true},
+	"groq":     {baseURL: "https://api.groq.com/openai/v1", model: "llama-3.3-70b-versatile", helperModel: "llama-3.1-8b-instant", needsKey: true, noSchema: true},
+	"xai":      {baseURL: "https://api.x.ai/v1", model: "grok-2-latest", helperModel: "grok-2-latest", needsKey: true},
+	"lmstudio": {baseURL: "http://localhost:1234/v1", model: "local-model", helperModel: "local-model"},
+	"vllm":     {baseURL: "http://localhost:8000/v1", model: "default", helperModel: "default"},
+	"llamacpp": {baseURL: "http://localhost:8080/v1", model: "default", helperModel: "default"},
+}
+
+// openAIMessage is a single chat message
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// openAIChatRequest is the body of a chat completions request
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    *float32              `json:"temperature,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *openAIStreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat constrains the answer to a JSON schema
+type openAIResponseFormat struct {
+	Type       string           `json:"type"`
+	JSONSchema openAIJSONSchema `json:"json_schema"`
+}
+
+// openAIJSONSchema is the json_schema block of response_format
+type openAIJSONSchema struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+	Strict bool                   `json:"strict"`
+}
+
+// openAIStreamOptions asks for a final usage event on streamed completions
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIUsage is the token usage block of a completion
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// openAIChatResponse is the part of the chat completions response we use
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
+}
+
+// openAIStreamEvent is one SSE event of a streamed chat completion
+type openAIStreamEvent struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
+}
+
+// openAIProvider talks to any server implementing the OpenAI chat completions API
+type openAIProvider struct {
+	name    string
+	preset  openAIPreset
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func init() {
+	for name := range openAIPresets {
+		name := name
+		RegisterProvider(name, func(cfg ProviderConfig) (Provider, error) {
+			return newOpenAIProvider(name, cfg)
+		})
+	}
+}
+
+func newOpenAIProvider(name string, cfg ProviderConfig) (Provider, error) {
+	preset := openAIPresets[name]
+	if preset.needsKey && cfg.APIKey == "" {
+		return nil, fmt.Errorf("missing API key for %s, set AI_API in set.json", name)
+	}
+
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = preset.baseURL
+	}
+
+	return &openAIProvider{
+		name:    name,
+		preset:  preset,
+		apiKey:  cfg.APIKey,
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (o *openAIProvider) Name() string {
+	return o.name
+}
+
+func (o *openAIProvider) DefaultModel(purpose Purpose) string {
+	if purpose == PurposeHelper {
+		return o.preset.helperModel
+	}
+	return o.preset.model
+}
+
+func (o *openAIProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := o.post(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var chatResp openAIChatResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message.Content == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	model := chatResp.Model
+	if model == "" {
+		model = req.Model
+	}
+
+	return &Response{
+		Text:     chatResp.Choices[0].Message.Content,
+		Model:    model,
+		Provider: o.name,
+		Usage:    chatResp.Usage.toUsage(),
+	}, nil
+}
+
+// SupportsSchema is true for every preset whose server accepts response_format json_schema
+func (o *openAIProvider) SupportsSchema() bool {
+	return !o.preset.noSchema
+}
+
+// GenerateStream requests a streamed completion and calls onText for every delta
+func (o *openAIProvider) GenerateStream(ctx context.Context, req *Request, onText func(text string)) (*Response, error) {
+	resp, err := o.post(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var usage Usage
+	model := req.Model
+	err = readSSE(resp.Body, func(data []byte) error {
+		var event openAIStreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		if event.Model != "" {
+			model = event.Model
+		}
+		if event.Usage != nil {
+			usage = event.Usage.toUsage()
+		}
+		if len(event.Choices) > 0 && event.Choices[0].Delta.Content != "" {
+			text.WriteString(event.Choices[0].Delta.Content)
+			onText(event.Choices[0].Delta.Content)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if text.Len() == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	return &Response{
+		Text:     text.String(),
+		Model:    model,
+		Provider: o.name,
+		Usage:    usage,
+	}, nil
+}
+
+// post sends a chat completions request and checks the status code
+func (o *openAIProvider) post(ctx context.Context, req *Request, stream bool) (*http.Response, error) {
+	body := openAIChatRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxOutputTokens,
+		Stream:      stream,
+	}
+	if stream {
+		body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	if req.Schema != nil && !o.preset.noSchema {
+		body.ResponseFormat = &openAIResponseFormat{
+			Type: "json_schema",
+			JSONSchema: openAIJSONSchema{
+				Name:   req.Schema.Name,
+				Schema: req.Schema.Definition,
+				Strict: true,
+			},
+		}
+	}
+	if req.SystemPrompt != "" {
+		body.Messages = append(body.Messages, openAIMessage{Role: "system", Content: req.SystemPrompt})
+	}
+	body.Messages = append(body.Messages, openAIMessage{Role: "user", Content: req.UserPrompt})
+
+	reqJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(reqJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	log.Printf("[DEBUG] Sending request to %s (%s) with model: %s (%d bytes)", o.name, o.baseURL, req.Model, len(reqJSON))
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(o.name, resp, respBody)
+	}
+
+	return resp, nil
+}
+
+// toUsage converts the usage block, servers that don't report usage leave it nil
+func (u *openAIUsage) toUsage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
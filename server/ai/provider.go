@@ -0,0 +1,215 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// DefaultProviderName is used when set.json has no AI_PROVIDER entry
+const DefaultProviderName = "gemini"
+
+// Purpose tells a provider what a request is for so it can pick a sensible model
+type Purpose string
+
+const (
+	// PurposeSheet is full worksheet generation
+	PurposeSheet Purpose = "sheet"
+
+	// PurposeHelper covers the tag/subject/course/description helpers
+	PurposeHelper Purpose = "helper"
+
+	// PurposeFix is the LaTeX repair loop
+	PurposeFix Purpose = "fix"
+)
+
+// modelConfigKeys maps a purpose to the set.json key that overrides its model
+var modelConfigKeys = map[Purpose]string{
+	PurposeSheet:  "AI_MODEL",
+	PurposeHelper: "AI_HELPER_MODEL",
+	PurposeFix:    "AI_FIX_MODEL",
+}
+
+// Request is a single prompt sent to a provider
+type Request struct {
+	Purpose         Purpose
+	Model           string
+	SystemPrompt    string
+	UserPrompt      string
+	Temperature     *float32
+	MaxOutputTokens int
+
+	// Schema asks for a JSON answer matching it, only set when the provider supports schemas
+	Schema *Schema
+}
+
+// Usage is the token count a provider reported for one request
+type Usage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+}
+
+// Response is the text a provider produced for a request
+type Response struct {
+	Text     string
+	Model    string
+	Provider string
+	Usage    Usage
+
+	// recorded is set once the usage is stored, a chain returns its link's response
+	recorded bool
+}
+
+// Provider is implemented by every AI vendor backend
+type Provider interface {
+	// Name returns the registry name of the provider
+	Name() string
+
+	// DefaultModel returns the model used when neither the request nor set.json picks one
+	DefaultModel(purpose Purpose) string
+
+	// Generate sends the request and returns the generated text
+	Generate(ctx context.Context, req *Request) (*Response, error)
+}
+
+// ProviderConfig holds the connection settings a provider is built from
+type ProviderConfig struct {
+	APIKey  string
+	BaseURL string
+}
+
+// ProviderFactory builds a provider from its config
+type ProviderFactory func(cfg ProviderConfig) (Provider, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider makes a provider available under the given name
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || factory == nil {
+		panic("ai: RegisterProvider called with empty name or nil factory")
+	}
+	if _, exists := providers[name]; exists {
+		panic(fmt.Sprintf("ai: provider %q registered twice", name))
+	}
+	providers[name] = factory
+}
+
+// NewProvider builds the named provider
+func NewProvider(name string, cfg ProviderConfig) (Provider, error) {
+	providersMu.RLock()
+	factory, ok := providers[strings.ToLower(strings.TrimSpace(name))]
+	providersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+	return factory(cfg)
+}
+
+// Providers lists the registered provider names
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ActiveProvider builds the provider selected in set.json
+// AI_CHAIN, when set, turns it into a fallback chain of provider/model pairs
+// AI_RECORD_MODE wraps it to record exchanges to disk or replay them
+func ActiveProvider() (Provider, error) {
+	name := configString("AI_PROVIDER")
+	if name == "" {
+		name = DefaultProviderName
+	}
+	defaults := ProviderConfig{
+		APIKey:  configString("AI_API"),
+		BaseURL: configString("AI_BASE_URL"),
+	}
+	mode := configString("AI_RECORD_MODE")
+
+	var links []ChainLinkConfig
+	if config.DecodeConfigValue("AI_CHAIN", &links) && len(links) > 0 {
+		return NewChain(links, defaults, name, mode, configString("AI_RECORD_DIR"))
+	}
+
+	provider, err := NewProvider(name, defaults)
+	if mode == "" {
+		return provider, err
+	}
+	if err != nil {
+		// Replaying needs no credentials, so a provider that can't be built is fine
+		if mode != RecordModeReplay {
+			return nil, err
+		}
+		provider = nil
+	}
+	return WithRecording(provider, mode, configString("AI_RECORD_DIR"))
+}
+
+// ResolveModel returns the model a request for the given purpose will use
+func ResolveModel(p Provider, purpose Purpose) string {
+	if key, ok := modelConfigKeys[purpose]; ok {
+		if model := configString(key); model != "" {
+			return model
+		}
+	}
+	return p.DefaultModel(purpose)
+}
+
+// Generate fills in the model for the request and sends it to the provider
+func Generate(ctx context.Context, p Provider, req *Request) (*Response, error) {
+	if p == nil {
+		return nil, fmt.Errorf("no AI provider configured")
+	}
+	if req == nil {
+		return nil, fmt.Errorf("AI request cannot be nil")
+	}
+
+	if req.Model == "" {
+		req.Model = ResolveModel(p, req.Purpose)
+	}
+
+	resp, err := p.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Model == "" {
+		resp.Model = req.Model
+	}
+	if resp.Provider == "" {
+		resp.Provider = p.Name()
+	}
+	recordUsage(ctx, req, resp)
+	return resp, nil
+}
+
+// Float32 is a small helper for setting Request.Temperature
+func Float32(v float32) *float32 {
+	return &v
+}
+
+// configString reads a string value from set.json, returning "" when unset
+func configString(key string) string {
+	if value, ok := config.GetConfigValue(key).(string); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
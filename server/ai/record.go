@@ -0,0 +1,163 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// RecordModeRecord stores every real exchange on disk
+	RecordModeRecord = "record"
+
+	// RecordModeReplay answers only from stored exchanges, never calling a provider
+	RecordModeReplay = "replay"
+
+	// DefaultRecordDir is used when AI_RECORD_DIR is not set
+	DefaultRecordDir = "./storage/ai_recordings"
+)
+
+// Recording is one stored provider exchange
+type Recording struct {
+	Key          string    `json:"key"`
+	Purpose      Purpose   `json:"purpose"`
+	SystemPrompt string    `json:"systemPrompt"`
+	UserPrompt   string    `json:"userPrompt"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Text         string    `json:"text"`
+	Usage        Usage     `json:"usage"`
+	RecordedAt   time.Time `json:"recordedAt"`
+}
+
+// recordingProvider wraps a provider and records or replays its exchanges
+type recordingProvider struct {
+	inner Provider
+	mode  string
+	dir   string
+}
+
+// WithRecording wraps a provider in record or replay mode
+// In replay mode inner may be nil, nothing is sent over the network
+func WithRecording(inner Provider, mode, dir string) (Provider, error) {
+	if mode != RecordModeRecord && mode != RecordModeReplay {
+		return nil, fmt.Errorf("unknown AI_RECORD_MODE %q (use %q or %q)", mode, RecordModeRecord, RecordModeReplay)
+	}
+	if mode == RecordModeRecord && inner == nil {
+		return nil, fmt.Errorf("record mode needs a real provider")
+	}
+	if dir == "" {
+		dir = DefaultRecordDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %w", err)
+	}
+
+	return &recordingProvider{inner: inner, mode: mode, dir: dir}, nil
+}
+
+// PromptHash is the key recordings are stored under
+// The model is left out so recordings replay no matter which model is configured
+func PromptHash(req *Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.Purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(req.SystemPrompt))
+	h.Write([]byte{0})
+	h.Write([]byte(req.UserPrompt))
+	if req.Schema != nil {
+		// A structured answer to the same prompt is a different recording
+		h.Write([]byte{0})
+		h.Write([]byte(req.Schema.Name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *recordingProvider) Name() string {
+	if r.inner == nil {
+		return "replay"
+	}
+	return r.inner.Name()
+}
+
+func (r *recordingProvider) DefaultModel(purpose Purpose) string {
+	if r.inner == nil {
+		return "replay"
+	}
+	return r.inner.DefaultModel(purpose)
+}
+
+// SupportsSchema follows the inner provider
+// A replay without one uses tags unless AI_OUTPUT_MODE is "structured"
+func (r *recordingProvider) SupportsSchema() bool {
+	return r.inner != nil && SupportsSchema(r.inner)
+}
+
+func (r *recordingProvider) Generate(ctx context.Context, req *Request) (*Response, error) {
+	return r.GenerateStream(ctx, req, nil)
+}
+
+// GenerateStream streams from the inner provider while recording
+// A replayed answer arrives as a single chunk
+func (r *recordingProvider) GenerateStream(ctx context.Context, req *Request, onText func(text string)) (*Response, error) {
+	key := PromptHash(req)
+	path := filepath.Join(r.dir, key+".json")
+
+	if r.mode == RecordModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("no recording for %s prompt %s: %w", req.Purpose, key, err)
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse recording %s: %w", key, err)
+		}
+		log.Printf("[DEBUG] Replaying recorded %s response %s", req.Purpose, key)
+		if onText != nil {
+			onText(rec.Text)
+		}
+		return &Response{Text: rec.Text, Model: rec.Model, Provider: rec.Provider, Usage: rec.Usage}, nil
+	}
+
+	var resp *Response
+	var err error
+	if streamer, ok := r.inner.(Streamer); ok && onText != nil {
+		resp, err = streamer.GenerateStream(ctx, req, onText)
+	} else {
+		resp, err = r.inner.Generate(ctx, req)
+		if err == nil && onText != nil {
+			onText(resp.Text)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := Recording{
+		Key:          key,
+		Purpose:      req.Purpose,
+		SystemPrompt: req.SystemPrompt,
+		UserPrompt:   req.UserPrompt,
+		Provider:     r.inner.Name(),
+		Model:        resp.Model,
+		Text:         resp.Text,
+		Usage:        resp.Usage,
+		RecordedAt:   time.Now(),
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recording: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		// A failed write should not fail the generation itself
+		log.Printf("[WARNING] Could not save recording %s: %v", key, err)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,63 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	req := &Request{Purpose: PurposeHelper, Model: "fake-helper", SystemPrompt: "Suggest a subject", UserPrompt: "Course: Algebra"}
+
+	recorder, err := WithRecording(&FakeProvider{}, RecordModeRecord, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := recorder.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, PromptHash(req)+".json")); err != nil {
+		t.Fatalf("no recording saved: %v", err)
+	}
+
+	// Replay needs no provider and must not depend on the configured model
+	replayer, err := WithRecording(nil, RecordModeReplay, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayReq := *req
+	replayReq.Model = "another-model"
+	var chunks []string
+	replayed, err := replayer.(Streamer).GenerateStream(context.Background(), &replayReq, func(text string) {
+		chunks = append(chunks, text)
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if replayed.Text != recorded.Text || replayed.Model != recorded.Model || replayed.Provider != "fake" || replayed.Usage != recorded.Usage {
+		t.Errorf("replayed %+v, recorded %+v", replayed, recorded)
+	}
+	if strings.Join(chunks, "") != recorded.Text {
+		t.Errorf("replay streamed %q, want %q", chunks, recorded.Text)
+	}
+
+	// A prompt that was never recorded fails instead of reaching a provider
+	missing := *req
+	missing.UserPrompt = "Course: Geometry"
+	if _, err := replayer.Generate(context.Background(), &missing); err == nil {
+		t.Error("replay answered a prompt that was never recorded")
+	}
+}
+
+func TestRecordingKeyedBySchema(t *testing.T) {
+	plain := &Request{Purpose: PurposeSheet, UserPrompt: "Subject: Maths"}
+	structured := *plain
+	structured.Schema = WorksheetSchema
+	if PromptHash(plain) == PromptHash(&structured) {
+		t.Error("a structured request shares the recording of the plain one")
+	}
+}
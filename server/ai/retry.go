@@ -0,0 +1,86 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// RetryPolicy says how often and how patiently one model is retried for a class of error
+// When the attempts run out the chain moves on to its next model
+type RetryPolicy struct {
+	MaxAttempts int           `json:"maxAttempts"`
+	BaseDelay   time.Duration `json:"-"`
+	MaxDelay    time.Duration `json:"-"`
+
+	// Milliseconds, only used when reading AI_RETRY from set.json
+	BaseDelayMs int `json:"baseDelayMs"`
+	MaxDelayMs  int `json:"maxDelayMs"`
+}
+
+// defaultRetryPolicies are tuned so quota errors move on quickly and flaky servers get another go
+// Auth and bad request errors will not fix themselves, so they are never retried
+var defaultRetryPolicies = map[ErrorClass]RetryPolicy{
+	ErrorRateLimit:  {MaxAttempts: 2, BaseDelay: 5 * time.Second, MaxDelay: 30 * time.Second},
+	ErrorServer:     {MaxAttempts: 3, BaseDelay: 2 * time.Second, MaxDelay: 20 * time.Second},
+	ErrorTimeout:    {MaxAttempts: 2, BaseDelay: 2 * time.Second, MaxDelay: 10 * time.Second},
+	ErrorNetwork:    {MaxAttempts: 3, BaseDelay: 1 * time.Second, MaxDelay: 10 * time.Second},
+	ErrorEmpty:      {MaxAttempts: 2, BaseDelay: 1 * time.Second, MaxDelay: 5 * time.Second},
+	ErrorUnknown:    {MaxAttempts: 2, BaseDelay: 2 * time.Second, MaxDelay: 10 * time.Second},
+	ErrorAuth:       {MaxAttempts: 1},
+	ErrorBadRequest: {MaxAttempts: 1},
+}
+
+// retryPolicyFor returns the policy of an error class, AI_RETRY entries win over the defaults
+func retryPolicyFor(class ErrorClass) RetryPolicy {
+	var overrides map[ErrorClass]RetryPolicy
+	if config.DecodeConfigValue("AI_RETRY", &overrides) {
+		if policy, ok := overrides[class]; ok {
+			policy.BaseDelay = time.Duration(policy.BaseDelayMs) * time.Millisecond
+			policy.MaxDelay = time.Duration(policy.MaxDelayMs) * time.Millisecond
+			if policy.MaxAttempts < 1 {
+				policy.MaxAttempts = 1
+			}
+			return policy
+		}
+	}
+	return defaultRetryPolicies[class]
+}
+
+// Backoff is the wait before retry number `retry` (1 for the first retry)
+// It doubles every time up to MaxDelay, with "equal jitter" so workers hitting the
+// same quota don't all come back at once. A Retry-After from the provider is respected
+func (p RetryPolicy) Backoff(retry int, err error) time.Duration {
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(retry-1)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+	return delay
+}
+
+// sleepCtx waits for d or until ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,109 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// Streamer is implemented by providers that can hand back text while it is generated
+type Streamer interface {
+	GenerateStream(ctx context.Context, req *Request, onText func(text string)) (*Response, error)
+}
+
+// StreamChunk is a piece of a streamed response
+type StreamChunk struct {
+	Text string `json:"text"`
+
+	// Attempt starts at 1, a higher attempt means earlier chunks were thrown away by a retry
+	Attempt int `json:"attempt"`
+}
+
+// StreamFunc receives chunks while a sheet is being generated
+type StreamFunc func(chunk StreamChunk)
+
+// GenerateStream is Generate for streaming callers
+// Providers without streaming support hand the whole answer over as a single chunk
+func GenerateStream(ctx context.Context, p Provider, req *Request, onText func(text string)) (*Response, error) {
+	streamer, ok := p.(Streamer)
+	if !ok || onText == nil || !streamingEnabled() {
+		resp, err := Generate(ctx, p, req)
+		if err == nil && onText != nil {
+			onText(resp.Text)
+		}
+		return resp, err
+	}
+
+	if req.Model == "" {
+		req.Model = ResolveModel(p, req.Purpose)
+	}
+
+	resp, err := streamer.GenerateStream(ctx, req, onText)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Model == "" {
+		resp.Model = req.Model
+	}
+	if resp.Provider == "" {
+		resp.Provider = p.Name()
+	}
+	recordUsage(ctx, req, resp)
+	return resp, nil
+}
+
+// streamingEnabled lets set.json turn streaming off with "AI_STREAM": false
+func streamingEnabled() bool {
+	enabled, ok := config.GetConfigValue("AI_STREAM").(bool)
+	return !ok || enabled
+}
+
+// readSSE calls fn with the data of every server-sent event until the stream ends or sends [DONE]
+func readSSE(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var data bytes.Buffer
+	flush := func() error {
+		if data.Len() == 0 {
+			return nil
+		}
+		defer data.Reset()
+		if bytes.Equal(data.Bytes(), []byte("[DONE]")) {
+			return io.EOF
+		}
+		return fn(data.Bytes())
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			// A blank line ends an event
+			if err := flush(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+		if payload, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(bytes.TrimSpace(payload))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	if err := flush(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoSchema is returned by GenerateJSON when the provider is not in structured mode
+var ErrNoSchema = errors.New("provider does not support structured output")
+
+const (
+	// OutputModeAuto asks for JSON from providers that support schemas and tags from the rest
+	OutputModeAuto = "auto"
+
+	// OutputModeStructured always asks for JSON, providers without schema support just get the prompt
+	OutputModeStructured = "structured"
+
+	// OutputModeTags always uses the <Output>/<meta-data> format
+	OutputModeTags = "tags"
+)
+
+// Schema is a JSON schema a provider should constrain its answer to
+// Definition is plain JSON Schema, every object lists all its properties as required
+// and sets additionalProperties to false so OpenAI's strict mode accepts it
+type Schema struct {
+	Name       string
+	Definition map[string]interface{}
+}
+
+// SchemaProvider is implemented by providers that can constrain output to a JSON schema
+type SchemaProvider interface {
+	SupportsSchema() bool
+}
+
+// SupportsSchema reports whether a provider honours Request.Schema
+func SupportsSchema(p Provider) bool {
+	sp, ok := p.(SchemaProvider)
+	return ok && sp.SupportsSchema()
+}
+
+// OutputMode returns AI_OUTPUT_MODE, defaulting to auto
+func OutputMode() string {
+	switch mode := configString("AI_OUTPUT_MODE"); mode {
+	case OutputModeStructured, OutputModeTags:
+		return mode
+	default:
+		return OutputModeAuto
+	}
+}
+
+// useSchema decides whether a request to p should carry a schema
+func useSchema(p Provider) bool {
+	switch OutputMode() {
+	case OutputModeStructured:
+		return true
+	case OutputModeTags:
+		return false
+	default:
+		return SupportsSchema(p)
+	}
+}
+
+// WorksheetQuestion is one question of a structured worksheet
+type WorksheetQuestion struct {
+	Number     int    `json:"number"`
+	Section    string `json:"section"`
+	Type       string `json:"type"`
+	Difficulty string `json:"difficulty"`
+	Prompt     string `json:"prompt"`
+	Answer     string `json:"answer"`
+}
+
+// WorksheetOutput is the structured answer to a sheet request
+type WorksheetOutput struct {
+	LaTeX         string              `json:"latex"`
+	Title         string              `json:"title"`
+	Subject       string              `json:"subject"`
+	Level         string              `json:"level"`
+	EstimatedTime string              `json:"estimatedTime"`
+	Keywords      []string            `json:"keywords"`
+	Notes         string              `json:"notes"`
+	Questions     []WorksheetQuestion `json:"questions"`
+}
+
+// Metadata flattens the worksheet into the same keys the <meta-data> block uses
+func (w *WorksheetOutput) Metadata() map[string]string {
+	return map[string]string{
+		"Title":         w.Title,
+		"Subject":       w.Subject,
+		"Level":         w.Level,
+		"EstimatedTime": w.EstimatedTime,
+		"Keywords":      strings.Join(w.Keywords, ","),
+		"Notes":         w.Notes,
+		"Questions":     fmt.Sprintf("%d", len(w.Questions)),
+	}
+}
+
+var questionTypes = []interface{}{"multiple-choice", "short-answer", "long-answer", "true-false", "worked-example", "fill-in-the-blank"}
+var questionDifficulties = []interface{}{"easy", "medium", "hard"}
+
+// WorksheetSchema is the schema sheet generation asks for in structured mode
+var WorksheetSchema = &Schema{
+	Name: "worksheet",
+	Definition: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"latex":         map[string]interface{}{"type": "string", "description": "The complete, compilable LaTeX document from \\documentclass to \\end{document}"},
+			"title":         map[string]interface{}{"type": "string"},
+			"subject":       map[string]interface{}{"type": "string"},
+			"level":         map[string]interface{}{"type": "string", "description": "Educational level"},
+			"estimatedTime": map[string]interface{}{"type": "string", "description": "Estimated completion time, e.g. 45 minutes"},
+			"keywords":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"notes":         map[string]interface{}{"type": "string", "description": "Notes for the teacher"},
+			"questions": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"number":     map[string]interface{}{"type": "integer"},
+						"section":    map[string]interface{}{"type": "string"},
+						"type":       map[string]interface{}{"type": "string", "enum": questionTypes},
+						"difficulty": map[string]interface{}{"type": "string", "enum": questionDifficulties},
+						"prompt":     map[string]interface{}{"type": "string", "description": "The question as plain text"},
+						"answer":     map[string]interface{}{"type": "string", "description": "Expected answer or marking notes"},
+					},
+					"required":             []interface{}{"number", "section", "type", "difficulty", "prompt", "answer"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []interface{}{"latex", "title", "subject", "level", "estimatedTime", "keywords", "notes", "questions"},
+		"additionalProperties": false,
+	},
+}
+
+// TagsSchema is used by the tag helper, OpenAI only accepts an object at the top level
+var TagsSchema = &Schema{
+	Name: "tags",
+	Definition: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+		"required":             []interface{}{"tags"},
+		"additionalProperties": false,
+	},
+}
+
+// ParseWorksheet decodes a structured answer and validates it against WorksheetSchema
+func ParseWorksheet(text string) (*WorksheetOutput, error) {
+	var worksheet WorksheetOutput
+	if err := DecodeStructured(text, WorksheetSchema, &worksheet); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	if !strings.Contains(worksheet.LaTeX, "\\documentclass") || !strings.Contains(worksheet.LaTeX, "\\end{document}") {
+		problems = append(problems, "latex: not a complete document")
+	}
+	if strings.TrimSpace(worksheet.Title) == "" {
+		problems = append(problems, "title: empty")
+	}
+	if len(worksheet.Questions) == 0 {
+		problems = append(problems, "questions: no questions")
+	}
+	for i, q := range worksheet.Questions {
+		if strings.TrimSpace(q.Prompt) == "" {
+			problems = append(problems, fmt.Sprintf("questions[%d].prompt: empty", i))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("worksheet failed validation: %s", strings.Join(problems, "; "))
+	}
+
+	worksheet.LaTeX = strings.TrimSpace(worksheet.LaTeX)
+	return &worksheet, nil
+}
+
+// DecodeStructured validates a JSON answer against a schema and decodes it into out
+// Code fences and chatter around the JSON are tolerated, models add them even when told not to
+func DecodeStructured(text string, schema *Schema, out interface{}) error {
+	raw := strings.TrimSpace(text)
+	start := strings.IndexAny(raw, "{[")
+	end := strings.LastIndexAny(raw, "}]")
+	if start < 0 || end < start {
+		return fmt.Errorf("no JSON found in %s response", schema.Name)
+	}
+	raw = raw[start : end+1]
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return fmt.Errorf("invalid JSON in %s response: %w", schema.Name, err)
+	}
+	if problems := validateSchema(schema.Definition, value, "$"); len(problems) > 0 {
+		return fmt.Errorf("%s response does not match schema: %s", schema.Name, strings.Join(problems, "; "))
+	}
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", schema.Name, err)
+	}
+	return nil
+}
+
+// validateSchema checks value against the subset of JSON Schema our schemas use
+// (type, properties, required, additionalProperties, items and enum)
+func validateSchema(schema map[string]interface{}, value interface{}, path string) []string {
+	var problems []string
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{path + ": expected object"}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := obj[name.(string)]; !present {
+					problems = append(problems, fmt.Sprintf("%s.%s: missing", path, name))
+				}
+			}
+		}
+		for name, child := range obj {
+			sub, known := properties[name].(map[string]interface{})
+			if !known {
+				if schema["additionalProperties"] == false {
+					problems = append(problems, fmt.Sprintf("%s.%s: unexpected property", path, name))
+				}
+				continue
+			}
+			problems = append(problems, validateSchema(sub, child, path+"."+name)...)
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return []string{path + ": expected array"}
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range arr {
+				problems = append(problems, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return []string{path + ": expected string"}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return []string{path + ": expected integer"}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{path + ": expected number"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{path + ": expected boolean"}
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if allowed == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, value, enum))
+		}
+	}
+
+	return problems
+}
+
+// GenerateJSON asks a helper question with a schema and decodes the answer into out
+// The raw answer is returned even when it fails validation so callers can fall back to scraping it
+func GenerateJSON(ctx context.Context, provider Provider, systemPrompt, userPrompt string, schema *Schema, out interface{}) (string, error) {
+	if !useSchema(provider) {
+		return "", ErrNoSchema
+	}
+
+	resp, err := Generate(ctx, provider, &Request{
+		Purpose:      PurposeHelper,
+		SystemPrompt: systemPrompt,
+		UserPrompt:   userPrompt,
+		Schema:       schema,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, DecodeStructured(resp.Text, schema, out)
+}
@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// worksheetAnswer is a valid structured answer, change lets a case break it
+func worksheetAnswer(t *testing.T, change func(answer map[string]interface{}, question map[string]interface{})) string {
+	question := map[string]interface{}{
+		"number": 1, "section": "Warm up", "type": "short-answer", "difficulty": "easy",
+		"prompt": "Compute 2 + 3.", "answer": "5",
+	}
+	answer := map[string]interface{}{
+		"latex":         "\\documentclass{article}\n\\begin{document}\nCompute $2 + 3$.\n\\end{document}",
+		"title":         "Addition",
+		"subject":       "Maths",
+		"level":         "Year 3",
+		"estimatedTime": "10 minutes",
+		"keywords":      []string{"addition"},
+		"notes":         "",
+		"questions":     []interface{}{question},
+	}
+	if change != nil {
+		change(answer, question)
+	}
+	data, err := json.Marshal(answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseWorksheet(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			text: worksheetAnswer(t, nil),
+		},
+		{
+			name: "code fence",
+			text: "Here is the worksheet:\n```json\n" + worksheetAnswer(t, nil) + "\n```",
+		},
+		{
+			name:    "missing required field",
+			text:    worksheetAnswer(t, func(a, q map[string]interface{}) { delete(a, "title") }),
+			wantErr: "$.title: missing",
+		},
+		{
+			name:    "unexpected property",
+			text:    worksheetAnswer(t, func(a, q map[string]interface{}) { q["hint"] = "Count on" }),
+			wantErr: "$.questions[0].hint: unexpected property",
+		},
+		{
+			name:    "enum",
+			text:    worksheetAnswer(t, func(a, q map[string]interface{}) { q["difficulty"] = "trivial" }),
+			wantErr: "$.questions[0].difficulty: trivial is not one of",
+		},
+		{
+			name:    "non-integer number",
+			text:    worksheetAnswer(t, func(a, q map[string]interface{}) { q["number"] = 1.5 }),
+			wantErr: "$.questions[0].number: expected integer",
+		},
+		{
+			name:    "incomplete document",
+			text:    worksheetAnswer(t, func(a, q map[string]interface{}) { a["latex"] = "Compute $2 + 3$." }),
+			wantErr: "latex: not a complete document",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worksheet, err := ParseWorksheet(tt.text)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseWorksheet: %v", err)
+				}
+				if worksheet.Title != "Addition" || len(worksheet.Questions) != 1 || worksheet.Questions[0].Number != 1 {
+					t.Errorf("decoded %+v", worksheet)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParseWorksheet error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeStructuredNoJSON(t *testing.T) {
+	var out map[string][]string
+	err := DecodeStructured("I can't answer that.", TagsSchema, &out)
+	if err == nil || !strings.Contains(err.Error(), "no JSON found") {
+		t.Fatalf("DecodeStructured error = %v, want no JSON found", err)
+	}
+
+	if err := DecodeStructured("```\n{\"tags\": [\"algebra\"]}\n```", TagsSchema, &out); err != nil {
+		t.Fatalf("DecodeStructured: %v", err)
+	}
+	if len(out["tags"]) != 1 || out["tags"][0] != "algebra" {
+		t.Errorf("decoded %v", out)
+	}
+}
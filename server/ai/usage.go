@@ -0,0 +1,104 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+)
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultPricing covers the default models of the hosted providers
+// Local models are free, AI_PRICING in set.json adds or overrides entries
+var defaultPricing = map[string]Price{
+	"gemini-2.5-pro":          {Input: 1.25, Output: 10},
+	"gemini-2.5-flash":        {Input: 0.30, Output: 2.50},
+	"gemini-2.5-flash-lite":   {Input: 0.10, Output: 0.40},
+	"gpt-4o":                  {Input: 2.50, Output: 10},
+	"gpt-4o-mini":             {Input: 0.15, Output: 0.60},
+	"llama-3.3-70b-versatile": {Input: 0.59, Output: 0.79},
+	"llama-3.1-8b-instant":    {Input: 0.05, Output: 0.08},
+	"grok-2-latest":           {Input: 2, Output: 10},
+}
+
+// UsageScope says who an AI call is billed to
+type UsageScope struct {
+	UserID string
+	JobID  string
+}
+
+type usageScopeKey struct{}
+
+// usageSeq keeps record IDs unique when calls finish in the same nanosecond
+var usageSeq uint64
+
+// WithUsageScope attaches the user and job to bill to the context
+func WithUsageScope(ctx context.Context, scope UsageScope) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, scope)
+}
+
+// usageScopeFrom returns the scope attached to the context, if any
+func usageScopeFrom(ctx context.Context) (UsageScope, bool) {
+	scope, ok := ctx.Value(usageScopeKey{}).(UsageScope)
+	return scope, ok
+}
+
+// PriceFor returns the price of a model, AI_PRICING entries win over the defaults
+func PriceFor(model string) Price {
+	var overrides map[string]Price
+	if config.DecodeConfigValue("AI_PRICING", &overrides) {
+		if price, ok := overrides[model]; ok {
+			return price
+		}
+	}
+	return defaultPricing[model]
+}
+
+// Cost returns the USD cost of a response's usage for the given model
+func Cost(model string, usage Usage) float64 {
+	price := PriceFor(model)
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
+}
+
+// recordUsage stores the usage of a response against the scope in ctx
+// Calls without a scope (like the CLI) are not billed to anyone
+func recordUsage(ctx context.Context, req *Request, resp *Response) {
+	scope, ok := usageScopeFrom(ctx)
+	if !ok || db.UsageDB == nil || resp.recorded {
+		return
+	}
+	resp.recorded = true
+
+	usage := resp.Usage
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	record := store.UsageRecord{
+		ID:               fmt.Sprintf("usage-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&usageSeq, 1)),
+		UserID:           scope.UserID,
+		JobID:            scope.JobID,
+		Purpose:          string(req.Purpose),
+		Provider:         resp.Provider,
+		Model:            resp.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             Cost(resp.Model, usage),
+		CreatedAt:        time.Now(),
+	}
+	if err := store.AddUsageRecord(db.UsageDB, record); err != nil {
+		// Accounting must never fail the generation itself
+		log.Printf("[WARNING] Could not record AI usage for %s: %v", scope.UserID, err)
+	}
+}
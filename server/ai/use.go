@@ -1,5 +1,11 @@
 package ai
 
+import "context"
+
 func Talk(){
-	GenerateResponse("apiKey", "model", "systemPrompt", "userPrompt", 0)
-}
\ No newline at end of file
+	provider, err := ActiveProvider()
+	if err != nil {
+		return
+	}
+	GenerateResponse(context.Background(), provider, "systemPrompt", "userPrompt", 0)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nadhi.dev/sarvar/fun/latex"
+	"nadhi.dev/sarvar/fun/server"
+)
+
+// CompileCacheIndex registers the compiled PDF cache routes
+func CompileCacheIndex() {
+	// GET /api/v1/sheets/compile-cache (admin), size and hit counters
+	server.Route.Get("/api/v1/sheets/compile-cache", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can manage the compile cache"})
+		}
+		return c.JSON(latex.GetCompileCacheStats())
+	})
+
+	// DELETE /api/v1/sheets/compile-cache (admin), removes every cached PDF
+	server.Route.Delete("/api/v1/sheets/compile-cache", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can manage the compile cache"})
+		}
+		removed, err := latex.ClearCompileCache()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to flush compile cache"})
+		}
+		return c.JSON(fiber.Map{"status": "flushed", "removed": removed})
+	})
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"nadhi.dev/sarvar/fun/ai"
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	"nadhi.dev/sarvar/fun/prompts"
+	"nadhi.dev/sarvar/fun/server"
+)
+
+// helperCacheSettings is HELPER_CACHE in set.json
+type helperCacheSettings struct {
+	Disabled   bool `json:"disabled"`
+	TTLSec     int  `json:"ttlSec"`
+	MaxEntries int  `json:"maxEntries"`
+	MaxBytes   int  `json:"maxBytes"`
+}
+
+// Helper answers barely change for the same input, a day is a safe default
+var defaultHelperCache = helperCacheSettings{
+	TTLSec:     24 * 60 * 60,
+	MaxEntries: 1000,
+	MaxBytes:   5 * 1024 * 1024,
+}
+
+// helperCacheConfig returns HELPER_CACHE with defaults for anything not set
+func helperCacheConfig() helperCacheSettings {
+	settings := defaultHelperCache
+	var overrides helperCacheSettings
+	if config.DecodeConfigValue("HELPER_CACHE", &overrides) {
+		settings.Disabled = overrides.Disabled
+		if overrides.TTLSec > 0 {
+			settings.TTLSec = overrides.TTLSec
+		}
+		if overrides.MaxEntries > 0 {
+			settings.MaxEntries = overrides.MaxEntries
+		}
+		if overrides.MaxBytes > 0 {
+			settings.MaxBytes = overrides.MaxBytes
+		}
+	}
+	return settings
+}
+
+// helperCacheKey hashes everything that decides a helper answer
+// Inputs are normalized so "Algebra " and "algebra" share an entry
+func helperCacheKey(endpoint string, provider ai.Provider, rendered *prompts.Rendered, tagsTemplate string, inputs ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d", endpoint, provider.Name(), ai.ResolveModel(provider, ai.PurposeHelper), rendered.Version)
+	if tagsTemplate != "" {
+		// Follow-up tags come from a second template, a new version of it is a new answer
+		version, _ := prompts.ActiveVersion(tagsTemplate)
+		fmt.Fprintf(h, "\x00%s:%d", tagsTemplate, version)
+	}
+	for _, input := range inputs {
+		h.Write([]byte{0})
+		h.Write([]byte(normalizeHelperInput(input)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeHelperInput lower-cases and collapses whitespace
+func normalizeHelperInput(input string) string {
+	return strings.ToLower(strings.Join(strings.Fields(input), " "))
+}
+
+// cachedHelperResponse returns the cached answer for key, or nil on a miss
+func cachedHelperResponse(key string) fiber.Map {
+	if db.HelperCacheDB == nil || helperCacheConfig().Disabled {
+		return nil
+	}
+	entry, err := store.GetHelperCacheEntry(db.HelperCacheDB, key)
+	if err != nil {
+		log.Printf("[WARNING] Helper cache lookup failed: %v", err)
+		return nil
+	}
+	if entry == nil {
+		return nil
+	}
+	return fiber.Map(entry.Response)
+}
+
+// cacheHelperResponse stores a helper answer, a failed write only costs a future miss
+func cacheHelperResponse(key, endpoint string, provider ai.Provider, rendered *prompts.Rendered, response fiber.Map) {
+	settings := helperCacheConfig()
+	if db.HelperCacheDB == nil || settings.Disabled {
+		return
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	entry := store.HelperCacheEntry{
+		Key:           key,
+		Endpoint:      endpoint,
+		Model:         ai.ResolveModel(provider, ai.PurposeHelper),
+		PromptVersion: rendered.Version,
+		Response:      response,
+		Size:          len(data),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(time.Duration(settings.TTLSec) * time.Second),
+	}
+	limits := store.HelperCacheLimits{MaxEntries: settings.MaxEntries, MaxBytes: settings.MaxBytes}
+	if err := store.PutHelperCacheEntry(db.HelperCacheDB, entry, limits); err != nil {
+		log.Printf("[WARNING] Could not cache %s response: %v", endpoint, err)
+	}
+}
+
+// HelperCacheIndex registers the admin routes of the helper cache
+func HelperCacheIndex() {
+	// GET /api/v1/sheets/cache?endpoint=<tags|subject|course|description> (admin)
+	server.Route.Get("/api/v1/sheets/cache", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can manage the helper cache"})
+		}
+		if db.HelperCacheDB == nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Helper cache DB not initialized"})
+		}
+
+		entries, err := store.GetHelperCacheEntries(db.HelperCacheDB)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read helper cache"})
+		}
+
+		endpoint := c.Query("endpoint")
+		now := time.Now()
+		filtered := make([]store.HelperCacheEntry, 0, len(entries))
+		size, hits, expired := 0, 0, 0
+		for _, e := range entries {
+			if endpoint != "" && e.Endpoint != endpoint {
+				continue
+			}
+			if now.After(e.ExpiresAt) {
+				expired++
+			}
+			size += e.Size
+			hits += e.Hits
+			filtered = append(filtered, e)
+		}
+		sort.Slice(filtered, func(i, j int) bool { return filtered[i].CreatedAt.After(filtered[j].CreatedAt) })
+
+		return c.JSON(fiber.Map{
+			"settings": helperCacheConfig(),
+			"entries":  len(filtered),
+			"expired":  expired,
+			"bytes":    size,
+			"hits":     hits,
+			"items":    filtered,
+		})
+	})
+
+	// DELETE /api/v1/sheets/cache?endpoint=<name> (admin), no endpoint flushes everything
+	server.Route.Delete("/api/v1/sheets/cache", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can manage the helper cache"})
+		}
+		if db.HelperCacheDB == nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Helper cache DB not initialized"})
+		}
+
+		removed, err := store.ClearHelperCache(db.HelperCacheDB, c.Query("endpoint"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to flush helper cache"})
+		}
+		return c.JSON(fiber.Map{"status": "flushed", "removed": removed})
+	})
+}
@@ -1,13 +1,19 @@
 package api
 
 import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"nadhi.dev/sarvar/fun/auth"
 	store "nadhi.dev/sarvar/fun/database"
 	"nadhi.dev/sarvar/fun/db"
+	"nadhi.dev/sarvar/fun/latex"
 	notebook "nadhi.dev/sarvar/fun/notebooks"
+	"nadhi.dev/sarvar/fun/prompts"
 	"nadhi.dev/sarvar/fun/server"
 )
 
@@ -46,6 +52,19 @@ func getUsernameFromAuth(c *fiber.Ctx) (string, error) {
     return user.Username, nil
 }
 
+// Helper to get the full user (rank included) from session
+func getUserFromAuth(c *fiber.Ctx) (*store.User, error) {
+    authHeader := c.Get("Authorization")
+    if len(authHeader) < 8 || !strings.HasPrefix(authHeader, "Bearer ") {
+        return nil, fiber.ErrUnauthorized
+    }
+    user, err := auth.GetUserBySession(authHeader[7:])
+    if err != nil || user == nil {
+        return nil, fiber.ErrUnauthorized
+    }
+    return user, nil
+}
+
 func Notebooks() error {
     server.Route.Get("/api/v1/notebooks", func(c *fiber.Ctx) error {
         username, err := getUsernameFromAuth(c)
@@ -162,6 +181,83 @@ func Notebooks() error {
     return c.JSON(updatedNb)
 })
 
+	// Pin prompt template versions for sheets generated in this notebook
+	// Body: {"promptVersions": {"sheet": 3, "fix": 2}}, a version of 0 removes the pin
+	server.Route.Put("/api/v1/notebooks/:id/prompts", func(c *fiber.Ctx) error {
+		username, err := getUsernameFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid notebook id"})
+		}
+		var body struct {
+			PromptVersions map[string]int `json:"promptVersions"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+
+		nb, err := notebook.GetNotebook(username, id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "notebook not found"})
+		}
+		if nb.PromptVersions == nil {
+			nb.PromptVersions = make(map[string]int)
+		}
+		for name, version := range body.PromptVersions {
+			if version == 0 {
+				delete(nb.PromptVersions, name)
+				continue
+			}
+			if _, err := prompts.Get(name, version); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			nb.PromptVersions[name] = version
+		}
+
+		if err := store.UpdateNotebook(db.NotebooksDB, username, *nb); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update notebook"})
+		}
+		return c.JSON(nb)
+	})
+
+	// Pick the LaTeX engine for sheets generated in this notebook
+	// Body: {"engine": "xelatex"}, an empty engine goes back to the server default
+	server.Route.Put("/api/v1/notebooks/:id/engine", func(c *fiber.Ctx) error {
+		username, err := getUsernameFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid notebook id"})
+		}
+		var body struct {
+			Engine string `json:"engine"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+		if body.Engine != "" {
+			if _, err := latex.ResolveEngine(body.Engine); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		nb, err := notebook.GetNotebook(username, id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "notebook not found"})
+		}
+		nb.Engine = body.Engine
+
+		if err := store.UpdateNotebook(db.NotebooksDB, username, *nb); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update notebook"})
+		}
+		return c.JSON(nb)
+	})
+
 	server.Route.Delete("/api/v1/notebooks/:id/items/:itemName", func(c *fiber.Ctx) error {
 		username, err := getUsernameFromAuth(c)
 		if err != nil {
@@ -201,8 +297,61 @@ func Notebooks() error {
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "failed to add sheet"})
 		}
-		return c.JSON(fiber.Map{"status": "added"})
+
+		// Keep the sheet's thumbnail with the item so listings have something to show
+		preview := itemPreview(c.Context(), body.Url)
+		if preview != nil {
+			if nb, err := notebook.GetNotebook(username, id); err == nil {
+				if nb.Previews == nil {
+					nb.Previews = make(map[string]store.ItemPreview)
+				}
+				nb.Previews[body.SheetName] = *preview
+				if err := store.UpdateNotebook(db.NotebooksDB, username, *nb); err != nil {
+					log.Printf("Failed to save preview of %s: %v", body.SheetName, err)
+				}
+			}
+		}
+		return c.JSON(fiber.Map{"status": "added", "preview": preview})
 	})
 
     return nil
-}
\ No newline at end of file
+}
+
+// itemPreview finds the previews of a bucket PDF by its URL, rendering them if the PDF has none yet
+// Nil for URLs outside the bucket or PDFs that can't be rendered
+func itemPreview(ctx context.Context, url string) *store.ItemPreview {
+	const bucketPrefix = "/vela/bucket/"
+	if !strings.HasPrefix(url, bucketPrefix) || !strings.HasSuffix(strings.ToLower(url), ".pdf") {
+		return nil
+	}
+	rel := filepath.Clean(strings.TrimPrefix(url, bucketPrefix))
+	if strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	pdfPath := filepath.Join("./storage", rel)
+
+	previews := latex.FindPreviews(pdfPath)
+	if previews == nil {
+		if _, err := os.Stat(pdfPath); err != nil {
+			return nil
+		}
+		rendered, err := latex.RenderPreviews(ctx, pdfPath)
+		if err != nil {
+			log.Printf("Failed to render previews of %s: %v", pdfPath, err)
+			return nil
+		}
+		previews = rendered
+	}
+
+	toURL := func(path string) string {
+		return bucketPrefix + filepath.ToSlash(filepath.Join(filepath.Dir(rel), filepath.Base(path)))
+	}
+	preview := &store.ItemPreview{}
+	if previews.Thumbnail != "" {
+		preview.Thumbnail = toURL(previews.Thumbnail)
+	}
+	for _, page := range previews.Pages {
+		preview.Pages = append(preview.Pages, toURL(page))
+	}
+	return preview
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nadhi.dev/sarvar/fun/prompts"
+	"nadhi.dev/sarvar/fun/server"
+)
+
+// PromptsIndex registers the prompt template routes
+// Anyone signed in can read templates (to pick a version for a notebook), only admins can change them
+func PromptsIndex() {
+	server.Route.Get("/api/v1/prompts", func(c *fiber.Ctx) error {
+		if _, err := getUserFromAuth(c); err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		var list []fiber.Map
+		for _, name := range prompts.Names() {
+			versions, err := prompts.Versions(name)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			active, err := prompts.ActiveVersion(name)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			numbers := make([]int, 0, len(versions))
+			for _, v := range versions {
+				numbers = append(numbers, v.Version)
+			}
+			list = append(list, fiber.Map{"name": name, "active": active, "versions": numbers})
+		}
+		return c.JSON(list)
+	})
+
+	server.Route.Get("/api/v1/prompts/:name", func(c *fiber.Ctx) error {
+		if _, err := getUserFromAuth(c); err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		name := c.Params("name")
+		versions, err := prompts.Versions(name)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		active, _ := prompts.ActiveVersion(name)
+		return c.JSON(fiber.Map{"name": name, "active": active, "versions": versions})
+	})
+
+	server.Route.Get("/api/v1/prompts/:name/:version", func(c *fiber.Ctx) error {
+		if _, err := getUserFromAuth(c); err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		version, err := c.ParamsInt("version")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid version"})
+		}
+		t, err := prompts.Get(c.Params("name"), version)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(t)
+	})
+
+	// Body: {"body": "<text/template source>", "description": "...", "activate": true}
+	// The new version number is returned, versions are never edited in place
+	server.Route.Post("/api/v1/prompts/:name", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can edit prompts"})
+		}
+		var body struct {
+			Body        string `json:"body"`
+			Description string `json:"description"`
+			Activate    bool   `json:"activate"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Body == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request: body is required"})
+		}
+
+		t, err := prompts.Save(c.Params("name"), body.Body, body.Description, user.Username, body.Activate)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(t)
+	})
+
+	// Body: {"version": 2}
+	server.Route.Put("/api/v1/prompts/:name/active", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can edit prompts"})
+		}
+		var body struct {
+			Version int `json:"version"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+
+		name := c.Params("name")
+		if err := prompts.Activate(name, body.Version); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"name": name, "active": body.Version})
+	})
+}
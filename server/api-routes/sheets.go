@@ -1,19 +1,21 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/thrasher-corp/gocryptotrader/config"
 	"nadhi.dev/sarvar/fun/ai"
 	"nadhi.dev/sarvar/fun/auth"
 	vela "nadhi.dev/sarvar/fun/bucket"
-	"nadhi.dev/sarvar/fun/config"
+	"nadhi.dev/sarvar/fun/latex"
+	notebook "nadhi.dev/sarvar/fun/notebooks"
+	"nadhi.dev/sarvar/fun/prompts"
 	"nadhi.dev/sarvar/fun/server"
 	sheet "nadhi.dev/sarvar/fun/sheets"
 )
@@ -27,9 +29,6 @@ type Sheet struct {
 	Visibility  string   `json:"visibility"`
 }
 
-// Last request timestamps for cooldown
-var lastRequestTimes = make(map[string]time.Time)
-
 // sheetGen is the global sheet generator instance
 var sheetGen *sheet.SheetGenerator
 
@@ -50,6 +49,11 @@ func SheetsIndex() error {
 	server.Route.Post("/api/v1/sheets/generate-subject", generateSubject)
 	server.Route.Post("/api/v1/sheets/generate-course", generateCourse)
 	server.Route.Post("/api/v1/sheets/generate-description", generateDescription)
+
+	// LaTeX engines found at startup, pass one as "engine" to /api/v1/sheets/create
+	server.Route.Get("/api/v1/sheets/engines", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"engines": latex.Engines()})
+	})
 	server.Route.Post("/api/v1/sheets/queue/:id", func(c *fiber.Ctx) error {
     id := c.Params("id")
     if id == "" {
@@ -65,6 +69,89 @@ func SheetsIndex() error {
     return c.JSON(fiber.Map{"status": "deleted"})
 })
 
+	// Stops a waiting or running job, only its owner or an admin can
+	server.Route.Post("/api/v1/sheets/queue/:id/cancel", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if sheet.GlobalSheetGenerator == nil || sheet.GlobalSheetGenerator.Queue == nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Sheet queue not initialized"})
+		}
+		id := c.Params("id")
+		job, exists := sheet.GlobalSheetGenerator.Queue.GetJobStatus(id)
+		if !exists {
+			return c.Status(404).JSON(fiber.Map{"error": "job not found"})
+		}
+		if job.UserID != user.Username && user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only the job's owner or an admin can cancel it"})
+		}
+
+		err = sheet.GlobalSheetGenerator.Queue.CancelJob(id)
+		switch {
+		case errors.Is(err, sheet.ErrJobNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, sheet.ErrJobFinished):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"jobId": id, "status": sheet.StatusCancelled})
+	})
+
+	// The draft of a job awaiting review, only its owner or an admin can see it
+	server.Route.Get("/api/v1/sheets/queue/:id/review", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if sheet.GlobalSheetGenerator == nil || sheet.GlobalSheetGenerator.Queue == nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Sheet queue not initialized"})
+		}
+		id := c.Params("id")
+		job, exists := sheet.GlobalSheetGenerator.Queue.GetJobStatus(id)
+		if !exists {
+			return c.Status(404).JSON(fiber.Map{"error": "job not found"})
+		}
+		if job.UserID != user.Username && user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only the job's owner or an admin can review it"})
+		}
+		msg, ok := sheet.GlobalSheetGenerator.Queue.ReviewMessage(job)
+		if !ok {
+			return c.Status(409).JSON(fiber.Map{"error": sheet.ErrNotAwaitingReview.Error(), "status": job.Status})
+		}
+		return c.JSON(fiber.Map{"jobId": id, "status": job.Status, "review": msg["data"]})
+	})
+
+	// Approves, edits or regenerates the draft of a job awaiting review
+	// Body: {"action": "approve"|"edit"|"regenerate", "latex": "...", "instructions": "..."}
+	server.Route.Post("/api/v1/sheets/queue/:id/review", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if sheet.GlobalSheetGenerator == nil || sheet.GlobalSheetGenerator.Queue == nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Sheet queue not initialized"})
+		}
+		var decision sheet.ReviewDecision
+		if err := c.BodyParser(&decision); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+		id := c.Params("id")
+		job, exists := sheet.GlobalSheetGenerator.Queue.GetJobStatus(id)
+		if !exists {
+			return c.Status(404).JSON(fiber.Map{"error": "job not found"})
+		}
+		if job.UserID != user.Username && user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "only the job's owner or an admin can review it"})
+		}
+
+		if err := reviewStatusError(sheet.GlobalSheetGenerator.Queue.ReviewJob(id, decision)); err != nil {
+			return c.Status(err.Code).JSON(fiber.Map{"error": err.Message})
+		}
+		return c.JSON(fiber.Map{"jobId": id, "action": decision.Action, "status": "queued"})
+	})
+
 
 	server.Route.Get("/api/v1/sheets/get", func(c *fiber.Ctx) error {
     // Query params
@@ -94,6 +181,10 @@ server.Route.Post("/api/v1/sheets/create", func(c *fiber.Ctx) error {
         Curriculum          string `json:"curriculum"`
         SpecialInstructions string `json:"specialInstructions"`
         Visibility          string `json:"visibility"`
+        NotebookID          int    `json:"notebookId"`
+        Engine              string `json:"engine"`
+        Batch               bool   `json:"batch"`
+        Review              bool   `json:"review"`
     }
     if err := c.BodyParser(&req); err != nil {
         return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
@@ -130,6 +221,27 @@ server.Route.Post("/api/v1/sheets/create", func(c *fiber.Ctx) error {
         Tags:                strings.Split(req.Tags, ","), // Convert comma-separated string to slice
         Curriculum:          req.Curriculum,
         SpecialInstructions: req.SpecialInstructions,
+        NotebookID:          req.NotebookID,
+        Engine:              req.Engine,
+        Batch:               req.Batch,
+        Review:              req.Review,
+    }
+
+    // Sheets made for a notebook use the prompt versions it pins, and its engine unless the request names one
+    if req.NotebookID != 0 {
+        nb, err := notebook.GetNotebook(userID, req.NotebookID)
+        if err != nil {
+            return c.Status(404).JSON(fiber.Map{"error": "notebook not found"})
+        }
+        genRequest.PromptVersions = nb.PromptVersions
+        if genRequest.Engine == "" {
+            genRequest.Engine = nb.Engine
+        }
+    }
+    if req.Engine != "" {
+        if _, err := latex.ResolveEngine(req.Engine); err != nil {
+            return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+        }
     }
 
     // Double-check GlobalSheetGenerator is not nil
@@ -138,6 +250,9 @@ server.Route.Post("/api/v1/sheets/create", func(c *fiber.Ctx) error {
     }
 
     jobID, err := sheet.GlobalSheetGenerator.CreateSheet(userID, genRequest)
+    if errors.Is(err, sheet.ErrBudgetExceeded) {
+        return c.Status(429).JSON(fiber.Map{"error": err.Error()})
+    }
     if err != nil {
         return c.Status(500).JSON(fiber.Map{"error": "Failed to enqueue sheet"})
     }
@@ -161,42 +276,56 @@ server.Route.Post("/api/v1/sheets/create", func(c *fiber.Ctx) error {
 	return nil
 }
 
-// getGeminiKey retrieves the Gemini API key
-func getGeminiKey() string {
-	value3 := config.GetConfigValue("AI_API")
-	if value3 != nil {
-		if key, ok := value3.(string); ok && key != "" {
-			return key
-		}
+// helperContext bills helper calls to the signed in user, refusing users over their budget
+func helperContext(c *fiber.Ctx) (context.Context, error) {
+	username, err := getUsernameFromAuth(c)
+	if err != nil {
+		username = "anonymous"
 	}
-	return ""
+	if err := sheet.CheckBudget(username); err != nil {
+		return nil, err
+	}
+	return ai.WithUsageScope(c.Context(), ai.UsageScope{UserID: username}), nil
 }
 
-// getGeminiModel returns the model to use
-func getGeminiModel() string {
-	return "gemini-2.5-flash-lite"
+// helperPromptData is what the helper templates render with
+type helperPromptData struct {
+	Subject     string
+	Course      string
+	Description string
 }
 
-// getCooldown returns the cooldown time in seconds
-func getCooldown() int {
-	return 2
+// generateFollowUpTags asks for tags after a subject, course or description was generated
+func generateFollowUpTags(ctx context.Context, provider ai.Provider, template string, data helperPromptData) ([]string, error) {
+	rendered, err := prompts.RenderCtx(ctx, template, data)
+	if err != nil {
+		return nil, err
+	}
+	return generateTagList(ctx, provider, rendered.System, rendered.User)
 }
 
-// checkCooldown checks if the cooldown period has passed for a given endpoint
-func checkCooldown(endpoint string) bool {
-	cooldown := getCooldown()
-	lastTime, exists := lastRequestTimes[endpoint]
-	if !exists {
-		lastRequestTimes[endpoint] = time.Now()
-		return true
+// generateTagList asks for tags as a schema-checked JSON object when the provider supports it
+// Anything else, or an answer that fails validation, goes through extractTags
+func generateTagList(ctx context.Context, provider ai.Provider, systemPrompt, userPrompt string) ([]string, error) {
+	var structured struct {
+		Tags []string `json:"tags"`
 	}
-
-	if time.Since(lastTime).Seconds() < float64(cooldown) {
-		return false
+	response, err := ai.GenerateJSON(ctx, provider, systemPrompt, userPrompt, ai.TagsSchema, &structured)
+	switch {
+	case err == nil:
+		return structured.Tags, nil
+	case errors.Is(err, ai.ErrNoSchema):
+		response, err = ai.GenerateResponse(ctx, provider, systemPrompt, userPrompt, 0)
+		if err != nil {
+			return nil, err
+		}
+	case response == "":
+		return nil, err
+	default:
+		log.Printf("[WARNING] Structured tags rejected, scraping the answer instead: %v", err)
 	}
 
-	lastRequestTimes[endpoint] = time.Now()
-	return true
+	return extractTags(response)
 }
 
 // extractTags extracts tags from a response
@@ -227,11 +356,6 @@ func extractTags(response string) ([]string, error) {
 
 // generateTags handles requests to generate tags using AI
 func generateTags(c *fiber.Ctx) error {
-	// Check cooldown
-	if !checkCooldown("tags") {
-		return c.Status(429).JSON(fiber.Map{"error": "Too many requests, please wait"})
-	}
-
 	var sheet Sheet
 	if err := c.BodyParser(&sheet); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
@@ -241,38 +365,41 @@ func generateTags(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "At least one of subject, course, or description is required"})
 	}
 
-	systemPrompt := `You are a tag generator for educational content. 
-Your task is to generate 3-7 relevant tags based on the subject, course title, and description provided.
-Return ONLY a JSON array of strings with the tags, nothing else.
-Example response: ["mathematics", "algebra", "equations", "polynomials"]`
+	provider, err := ai.ActiveProvider()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("AI provider not available: %v", err)})
+	}
 
-	userPrompt := fmt.Sprintf(`Generate tags for the following educational content:
-Subject: %s
-Course: %s
-Description: %s`,
-		sheet.Subject,
-		sheet.Course,
-		sheet.Description)
+	rendered, err := prompts.RenderCtx(c.Context(), prompts.Tags, helperPromptData{Subject: sheet.Subject, Course: sheet.Course, Description: sheet.Description})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to build prompt: %v", err)})
+	}
 
-	apiKey := getGeminiKey()
-	model := getGeminiModel()
+	// Cache hits cost nothing, so they skip the budget check and the rate limiter refunds them
+	cacheKey := helperCacheKey("tags", provider, rendered, "", sheet.Subject, sheet.Course, sheet.Description)
+	if cached := cachedHelperResponse(cacheKey); cached != nil {
+		c.Set("X-Cache", "HIT")
+		return c.Status(200).JSON(cached)
+	}
+
+	ctx, err := helperContext(c)
+	if err != nil {
+		return c.Status(429).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	response, err := ai.GenerateResponse(apiKey, model, systemPrompt, userPrompt, getCooldown())
+	tags, err := generateTagList(ctx, provider, rendered.System, rendered.User)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate tags: %v", err)})
 	}
 
-	tags, _ := extractTags(response)
-	return c.Status(200).JSON(fiber.Map{"tags": tags})
+	result := fiber.Map{"tags": tags}
+	cacheHelperResponse(cacheKey, "tags", provider, rendered, result)
+	c.Set("X-Cache", "MISS")
+	return c.Status(200).JSON(result)
 }
 
 // generateSubject generates a subject based on course and/or description
 func generateSubject(c *fiber.Ctx) error {
-	// Check cooldown
-	if !checkCooldown("subject") {
-		return c.Status(429).JSON(fiber.Map{"error": "Too many requests, please wait"})
-	}
-
 	var request struct {
 		Course       string `json:"course"`
 		Description  string `json:"description"`
@@ -287,20 +414,34 @@ func generateSubject(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "At least course or description is required"})
 	}
 
-	systemPrompt := `You are an educational content creator. 
-Based on the course title and description provided, generate an appropriate subject field.
-Return ONLY the subject name, nothing else. Keep it concise (1-3 words).`
+	provider, err := ai.ActiveProvider()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("AI provider not available: %v", err)})
+	}
+
+	rendered, err := prompts.RenderCtx(c.Context(), prompts.Subject, helperPromptData{Course: request.Course, Description: request.Description})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to build prompt: %v", err)})
+	}
 
-	userPrompt := fmt.Sprintf(`Generate a subject name for the following course:
-Course: %s
-Description: %s`,
-		request.Course,
-		request.Description)
+	// Cache hits cost nothing, so they skip the budget check and the rate limiter refunds them
+	wantTags := request.GenerateTags && c.Query("tags") == "true"
+	tagsTemplate := ""
+	if wantTags {
+		tagsTemplate = prompts.SubjectTags
+	}
+	cacheKey := helperCacheKey("subject", provider, rendered, tagsTemplate, request.Course, request.Description)
+	if cached := cachedHelperResponse(cacheKey); cached != nil {
+		c.Set("X-Cache", "HIT")
+		return c.Status(200).JSON(cached)
+	}
 
-	apiKey := getGeminiKey()
-	model := getGeminiModel()
+	ctx, err := helperContext(c)
+	if err != nil {
+		return c.Status(429).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	response, err := ai.GenerateResponse(apiKey, model, systemPrompt, userPrompt, getCooldown())
+	response, err := ai.GenerateResponse(ctx, provider, rendered.System, rendered.User, 0)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate subject: %v", err)})
 	}
@@ -311,32 +452,23 @@ Description: %s`,
 	result := fiber.Map{"subject": subject}
 
 	// Generate tags only if requested AND the tags query param is set to true
-	if request.GenerateTags && c.Query("tags") == "true" {
-		if checkCooldown("subject_tags") {
-			tagSystemPrompt := `Generate 3-5 tags for this academic subject. Return only a JSON array of strings.
-Example: ["physics", "mechanics", "motion"]`
-
-			tagUserPrompt := fmt.Sprintf("Subject: %s\nCourse: %s\nDescription: %s",
-				subject, request.Course, request.Description)
-
-			tagResponse, err := ai.GenerateResponse(apiKey, model, tagSystemPrompt, tagUserPrompt, 0)
-			if err == nil {
-				tags, _ := extractTags(tagResponse)
-				result["tags"] = tags
-			}
+	if wantTags {
+		tags, err := generateFollowUpTags(ctx, provider, prompts.SubjectTags, helperPromptData{Subject: subject, Course: request.Course, Description: request.Description})
+		if err == nil {
+			result["tags"] = tags
 		}
 	}
 
+	// An answer missing the tags it was asked for is not cached
+	if _, hasTags := result["tags"]; hasTags || !wantTags {
+		cacheHelperResponse(cacheKey, "subject", provider, rendered, result)
+	}
+	c.Set("X-Cache", "MISS")
 	return c.Status(200).JSON(result)
 }
 
 // generateCourse generates a course title based on subject and/or description
 func generateCourse(c *fiber.Ctx) error {
-	// Check cooldown
-	if !checkCooldown("course") {
-		return c.Status(429).JSON(fiber.Map{"error": "Too many requests, please wait"})
-	}
-
 	var request struct {
 		Subject      string `json:"subject"`
 		Description  string `json:"description"`
@@ -351,20 +483,34 @@ func generateCourse(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "At least subject or description is required"})
 	}
 
-	systemPrompt := `You are an educational content creator. 
-Based on the subject and description provided, generate an appropriate course title.
-Return ONLY the course title, nothing else. Make it sound like an actual academic course.`
+	provider, err := ai.ActiveProvider()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("AI provider not available: %v", err)})
+	}
 
-	userPrompt := fmt.Sprintf(`Generate a course title for the following:
-Subject: %s
-Description: %s`,
-		request.Subject,
-		request.Description)
+	rendered, err := prompts.RenderCtx(c.Context(), prompts.Course, helperPromptData{Subject: request.Subject, Description: request.Description})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to build prompt: %v", err)})
+	}
 
-	apiKey := getGeminiKey()
-	model := getGeminiModel()
+	// Cache hits cost nothing, so they skip the budget check and the rate limiter refunds them
+	wantTags := request.GenerateTags && c.Query("tags") == "true"
+	tagsTemplate := ""
+	if wantTags {
+		tagsTemplate = prompts.CourseTags
+	}
+	cacheKey := helperCacheKey("course", provider, rendered, tagsTemplate, request.Subject, request.Description)
+	if cached := cachedHelperResponse(cacheKey); cached != nil {
+		c.Set("X-Cache", "HIT")
+		return c.Status(200).JSON(cached)
+	}
+
+	ctx, err := helperContext(c)
+	if err != nil {
+		return c.Status(429).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	response, err := ai.GenerateResponse(apiKey, model, systemPrompt, userPrompt, getCooldown())
+	response, err := ai.GenerateResponse(ctx, provider, rendered.System, rendered.User, 0)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate course: %v", err)})
 	}
@@ -375,32 +521,23 @@ Description: %s`,
 	result := fiber.Map{"course": course}
 
 	// Generate tags only if requested AND the tags query param is set to true
-	if request.GenerateTags && c.Query("tags") == "true" {
-		if checkCooldown("course_tags") {
-			tagSystemPrompt := `Generate 3-5 tags for this academic course. Return only a JSON array of strings.
-Example: ["calculus", "mathematics", "derivatives"]`
-
-			tagUserPrompt := fmt.Sprintf("Subject: %s\nCourse: %s\nDescription: %s",
-				request.Subject, course, request.Description)
-
-			tagResponse, err := ai.GenerateResponse(apiKey, model, tagSystemPrompt, tagUserPrompt, 0)
-			if err == nil {
-				tags, _ := extractTags(tagResponse)
-				result["tags"] = tags
-			}
+	if wantTags {
+		tags, err := generateFollowUpTags(ctx, provider, prompts.CourseTags, helperPromptData{Subject: request.Subject, Course: course, Description: request.Description})
+		if err == nil {
+			result["tags"] = tags
 		}
 	}
 
+	// An answer missing the tags it was asked for is not cached
+	if _, hasTags := result["tags"]; hasTags || !wantTags {
+		cacheHelperResponse(cacheKey, "course", provider, rendered, result)
+	}
+	c.Set("X-Cache", "MISS")
 	return c.Status(200).JSON(result)
 }
 
 // generateDescription generates a description based on subject and/or course
 func generateDescription(c *fiber.Ctx) error {
-	// Check cooldown
-	if !checkCooldown("description") {
-		return c.Status(429).JSON(fiber.Map{"error": "Too many requests, please wait"})
-	}
-
 	var request struct {
 		Subject      string `json:"subject"`
 		Course       string `json:"course"`
@@ -415,21 +552,34 @@ func generateDescription(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "At least subject or course is required"})
 	}
 
-	systemPrompt := `You are an educational content creator. 
-Based on the subject and course title provided, generate an appropriate description.
-The description should be 2-3 sentences that explain what the course covers.`
+	provider, err := ai.ActiveProvider()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("AI provider not available: %v", err)})
+	}
 
-	userPrompt := fmt.Sprintf(`Generate a description for the following course:
-Subject: %s
-Course: %s
-Make an apporiate description with the instructions on how to prepare for the course and create an exam course.`,
-		request.Subject,
-		request.Course)
+	rendered, err := prompts.RenderCtx(c.Context(), prompts.Description, helperPromptData{Subject: request.Subject, Course: request.Course})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to build prompt: %v", err)})
+	}
+
+	// Cache hits cost nothing, so they skip the budget check and the rate limiter refunds them
+	wantTags := request.GenerateTags && c.Query("tags") == "true"
+	tagsTemplate := ""
+	if wantTags {
+		tagsTemplate = prompts.DescriptionTags
+	}
+	cacheKey := helperCacheKey("description", provider, rendered, tagsTemplate, request.Subject, request.Course)
+	if cached := cachedHelperResponse(cacheKey); cached != nil {
+		c.Set("X-Cache", "HIT")
+		return c.Status(200).JSON(cached)
+	}
 
-	apiKey := getGeminiKey()
-	model := getGeminiModel()
+	ctx, err := helperContext(c)
+	if err != nil {
+		return c.Status(429).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	response, err := ai.GenerateResponse(apiKey, model, systemPrompt, userPrompt, getCooldown())
+	response, err := ai.GenerateResponse(ctx, provider, rendered.System, rendered.User, 0)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Failed to generate description: %v", err)})
 	}
@@ -440,21 +590,32 @@ Make an apporiate description with the instructions on how to prepare for the co
 	result := fiber.Map{"description": description}
 
 	// Generate tags only if requested AND the tags query param is set to true
-	if request.GenerateTags && c.Query("tags") == "true" {
-		if checkCooldown("description_tags") {
-			tagSystemPrompt := `Generate 3-5 tags for this course description. Return only a JSON array of strings.
-Example: ["chemistry", "organic", "synthesis"]`
-
-			tagUserPrompt := fmt.Sprintf("Subject: %s\nCourse: %s\nDescription: %s",
-				request.Subject, request.Course, description)
-
-			tagResponse, err := ai.GenerateResponse(apiKey, model, tagSystemPrompt, tagUserPrompt, 0)
-			if err == nil {
-				tags, _ := extractTags(tagResponse)
-				result["tags"] = tags
-			}
+	if wantTags {
+		tags, err := generateFollowUpTags(ctx, provider, prompts.DescriptionTags, helperPromptData{Subject: request.Subject, Course: request.Course, Description: description})
+		if err == nil {
+			result["tags"] = tags
 		}
 	}
 
+	// An answer missing the tags it was asked for is not cached
+	if _, hasTags := result["tags"]; hasTags || !wantTags {
+		cacheHelperResponse(cacheKey, "description", provider, rendered, result)
+	}
+	c.Set("X-Cache", "MISS")
 	return c.Status(200).JSON(result)
 }
+
+// reviewStatusError maps a ReviewJob error to the HTTP status it is answered with, nil stays nil
+func reviewStatusError(err error) *fiber.Error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sheet.ErrJobNotFound):
+		return fiber.NewError(404, err.Error())
+	case errors.Is(err, sheet.ErrNotAwaitingReview):
+		return fiber.NewError(409, err.Error())
+	case errors.Is(err, sheet.ErrInvalidReview):
+		return fiber.NewError(400, err.Error())
+	}
+	return fiber.NewError(500, err.Error())
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"nadhi.dev/sarvar/fun/ai"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	"nadhi.dev/sarvar/fun/server"
+	sheet "nadhi.dev/sarvar/fun/sheets"
+)
+
+// UsageIndex registers the AI usage routes
+func UsageIndex() {
+	// GET /api/v1/usage?period=day|month|all&user=<name>&jobId=<id>
+	// Admins can look at any user (or everyone), everyone else only sees themselves
+	server.Route.Get("/api/v1/usage", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if db.UsageDB == nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Usage DB not initialized"})
+		}
+
+		filter := store.UsageFilter{
+			UserID: user.Username,
+			JobID:  c.Query("jobId"),
+		}
+		if user.Rank == "admin" {
+			filter.UserID = c.Query("user")
+		} else if requested := c.Query("user"); requested != "" && requested != user.Username {
+			return c.Status(403).JSON(fiber.Map{"error": "only admins can view other users' usage"})
+		}
+
+		period := c.Query("period", "month")
+		now := time.Now()
+		switch period {
+		case "day":
+			filter.Since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		case "month":
+			filter.Since = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		case "all":
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "period must be day, month or all"})
+		}
+
+		records, err := store.GetUsageRecords(db.UsageDB, filter)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to read usage"})
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].CreatedAt.After(records[j].CreatedAt)
+		})
+
+		byUser := make(map[string][]store.UsageRecord)
+		byModel := make(map[string][]store.UsageRecord)
+		for _, record := range records {
+			byUser[record.UserID] = append(byUser[record.UserID], record)
+			byModel[record.Model] = append(byModel[record.Model], record)
+		}
+
+		response := fiber.Map{
+			"period":  period,
+			"totals":  store.TotalUsage(records),
+			"byModel": totalsOf(byModel),
+			"records": records,
+		}
+		if filter.UserID == "" {
+			response["byUser"] = totalsOf(byUser)
+		} else {
+			rank := user.Rank
+			if filter.UserID != user.Username {
+				if other, err := store.GetUser(db.UsersDB, filter.UserID); err == nil && other != nil {
+					rank = other.Rank
+				}
+			}
+			response["budget"] = sheet.BudgetForRank(rank)
+			response["budgetError"] = nil
+			if err := sheet.CheckBudget(filter.UserID); err != nil {
+				response["budgetError"] = err.Error()
+			}
+		}
+
+		return c.JSON(response)
+	})
+
+	// GET /api/v1/usage/breakers (admin)
+	// State of the circuit breaker of every provider/model pair that has seen traffic
+	server.Route.Get("/api/v1/usage/breakers", func(c *fiber.Ctx) error {
+		user, err := getUserFromAuth(c)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Rank != "admin" {
+			return c.Status(403).JSON(fiber.Map{"error": "admin only"})
+		}
+		return c.JSON(fiber.Map{"breakers": ai.Breakers()})
+	})
+}
+
+// totalsOf sums each group of usage records
+func totalsOf(groups map[string][]store.UsageRecord) map[string]store.UsageTotals {
+	totals := make(map[string]store.UsageTotals, len(groups))
+	for key, records := range groups {
+		totals[key] = store.TotalUsage(records)
+	}
+	return totals
+}
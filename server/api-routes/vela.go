@@ -1,7 +1,10 @@
 package api
 
 import (
+    "crypto/rand"
+    "encoding/base64"
     "github.com/gofiber/fiber/v2"
+    "nadhi.dev/sarvar/fun/latex"
     "nadhi.dev/sarvar/fun/server"
     "os"
     "path/filepath"
@@ -36,6 +39,24 @@ func ServeStorageFileFiber(c *fiber.Ctx) error {
     relPath := c.Params("*")
     filePath := filepath.Join("./storage", relPath)
 
+    // ?format=html or ?format=md on a sheet's PDF serves its HTML or Markdown export instead
+    if strings.ToLower(filepath.Ext(filePath)) == ".pdf" {
+        format, ok := latex.ExportFormat(c.Query("format"))
+        if !ok {
+            return c.Status(400).JSON(fiber.Map{"error": "format must be pdf, html or md"})
+        }
+        if format != latex.FormatPDF {
+            if _, err := os.Stat(filePath); err != nil {
+                return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+            }
+            exported, err := latex.ExportedPath(filePath, format)
+            if err != nil {
+                return c.Status(404).JSON(fiber.Map{"error": "No " + format + " version of this file"})
+            }
+            filePath = exported
+        }
+    }
+
     data, err := ioutil.ReadFile(filePath)
     if err != nil {
         return c.Status(404).JSON(fiber.Map{"error": "File not found"})
@@ -50,6 +71,17 @@ func ServeStorageFileFiber(c *fiber.Ctx) error {
         c.Type("jpeg")
     case ".pdf":
         c.Type("pdf")
+    case ".html":
+        c.Set("Content-Type", "text/html; charset=utf-8")
+        // Exported sheets only load MathJax and their own script and style, nothing else may run on our origin
+        nonce := make([]byte, 16)
+        if _, err := rand.Read(nonce); err != nil {
+            return c.Status(500).JSON(fiber.Map{"error": "Failed to serve file"})
+        }
+        encoded := base64.StdEncoding.EncodeToString(nonce)
+        c.Set("Content-Security-Policy", latex.ExportPolicy(encoded))
+        c.Set("X-Content-Type-Options", "nosniff")
+        data = latex.WithNonce(data, encoded)
     case ".txt", ".md", ".json", ".csv":
         // Force text display in browser like GitHub Raw
         c.Set("Content-Type", "text/plain; charset=utf-8")
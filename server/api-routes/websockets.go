@@ -2,7 +2,9 @@ package api
 
 import (
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"sync"
 	_ "time"
 
 	"github.com/gofiber/fiber/v2"
@@ -61,6 +63,8 @@ func RegisterWebsocketRoutes() {
 
 		// Create a map to track last sent status per job
 		lastSent := make(map[string]string)
+		// The listener and the review replies below write from different goroutines
+		var writeMu sync.Mutex
 
 		// Register a listener for this job
 		sheet.GlobalSheetGenerator.Queue.RegisterJobListener(jobID, func(update sheet.StatusUpdate) {
@@ -87,7 +91,9 @@ func RegisterWebsocketRoutes() {
 			}
 
 			// Send to the client
+			writeMu.Lock()
 			_ = c.WriteJSON(msg)
+			writeMu.Unlock()
 		})
 
 		// Optionally send initial status
@@ -99,14 +105,62 @@ func RegisterWebsocketRoutes() {
 			msg["jobId"] = jobID
 			c.WriteJSON(msg)
 			c.WriteJSON(msg2)
+
+			// A job still waiting for a worker also gets its place in the queue
+			if job.Queue != nil {
+				queueMsg := sheet.QueueMessage(*job.Queue)
+				queueMsg["jobId"] = jobID
+				c.WriteJSON(queueMsg)
+			}
+			// A job parked for review gets its draft again
+			if reviewMsg, ok := sheet.GlobalSheetGenerator.Queue.ReviewMessage(job); ok {
+				reviewMsg["jobId"] = jobID
+				c.WriteJSON(reviewMsg)
+			}
 		}
 
 		// Keep connection open until it's closed by the client
+		// The client can answer a review here: {"type": "review", "action": "approve"|"edit"|"regenerate", ...}
 		for {
-			if _, _, err := c.ReadMessage(); err != nil {
+			_, data, err := c.ReadMessage()
+			if err != nil {
 				// Connection is closed
 				break
 			}
+			var incoming struct {
+				Type string `json:"type"`
+				sheet.ReviewDecision
+			}
+			if json.Unmarshal(data, &incoming) != nil || incoming.Type != "review" {
+				continue
+			}
+			reply := reviewOverSocket(sessionID, jobID, incoming.ReviewDecision)
+			reply["jobId"] = jobID
+			writeMu.Lock()
+			_ = c.WriteJSON(reply)
+			writeMu.Unlock()
 		}
 	}))
 }
+
+// reviewOverSocket applies a review sent on the job websocket, with the checks of the REST route
+func reviewOverSocket(sessionID, jobID string, decision sheet.ReviewDecision) map[string]interface{} {
+	user, err := auth.GetUserBySession(sessionID)
+	if err != nil || user == nil {
+		return ws.Error("Review failed", "unauthorized", map[string]interface{}{})
+	}
+	job, exists := sheet.GlobalSheetGenerator.Queue.GetJobStatus(jobID)
+	if !exists {
+		return ws.Error("Review failed", "job not found", map[string]interface{}{})
+	}
+	if job.UserID != user.Username && user.Rank != "admin" {
+		return ws.Error("Review failed", "only the job's owner or an admin can review it", map[string]interface{}{})
+	}
+	if err := reviewStatusError(sheet.GlobalSheetGenerator.Queue.ReviewJob(jobID, decision)); err != nil {
+		return ws.Error("Review failed", err.Message, map[string]interface{}{"code": err.Code})
+	}
+	return ws.Push(fmt.Sprintf("Review %s accepted for job %s", decision.Action, jobID), map[string]interface{}{
+		"review": "accepted",
+		"action": decision.Action,
+	})
+}
@@ -22,6 +22,7 @@ func InitConfigs() {
         
         // Create a default config file
         defaultConfig := map[string]interface{}{
+            "AI_PROVIDER":     "gemini",
             "AI_API":          "",
             "MAX_SESSIONS":    2,
             "SHEET_QUEUE_DIR": "./storage/queue_data",
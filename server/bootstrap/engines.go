@@ -0,0 +1,21 @@
+package bootstrap
+
+import (
+    "strings"
+
+    "nadhi.dev/sarvar/fun/latex"
+    logg "nadhi.dev/sarvar/fun/logs"
+)
+
+// InitEngines looks for the installed LaTeX engines so the first job doesn't have to
+func InitEngines() {
+    available := latex.DetectEngines()
+    if len(available) == 0 {
+        logg.Warning("No LaTeX engine found, install tectonic or TeX Live (latexmk) to compile sheets")
+        return
+    }
+    logg.Info("LaTeX engines found: " + strings.Join(available, ", "))
+    if engine := latex.DefaultEngine(); engine != nil {
+        logg.Info("Default LaTeX engine: " + engine.Name())
+    }
+}
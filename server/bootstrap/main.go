@@ -13,6 +13,9 @@ func Initialize() {
     
     // Initialize database structure
    // InitDatabase()
+
+    // Find the LaTeX engines sheets can compile with
+    InitEngines()
     
     logg.Success("Application initialization complete")
 }
\ No newline at end of file
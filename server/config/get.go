@@ -36,4 +36,17 @@ func GetConfig() map[string]interface{} {
         return nil
     }
     return config
-}
\ No newline at end of file
+}
+// DecodeConfigValue decodes an object or array value of set.json into out
+// It returns false when the key is missing or does not fit out
+func DecodeConfigValue(key string, out interface{}) bool {
+    value := GetConfigValue(key)
+    if value == nil {
+        return false
+    }
+    data, err := json.Marshal(value)
+    if err != nil {
+        return false
+    }
+    return json.Unmarshal(data, out) == nil
+}
@@ -9,6 +9,8 @@ import (
 func MakeJsonConfig(api_key string, source string, model string) error {
     config := map[string]interface{}{
         "AI_API": api_key,
+        "AI_PROVIDER":      source,
+        "AI_MODEL":         model,
         "MAX_SESSIONS":     2,
         "SHEET_QUEUE_DIR":  "./storage/queue_data",
     }
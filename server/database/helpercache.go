@@ -0,0 +1,203 @@
+package store
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+// HelperCacheEntry is one cached answer of a helper endpoint (tags, subject, course, description)
+type HelperCacheEntry struct {
+    Key           string                 `json:"key"`
+    Endpoint      string                 `json:"endpoint"`
+    Model         string                 `json:"model"`
+    PromptVersion int                    `json:"promptVersion"`
+    Response      map[string]interface{} `json:"response"`
+    Size          int                    `json:"size"`
+    Hits          int                    `json:"hits"`
+    CreatedAt     time.Time              `json:"createdAt"`
+    LastHitAt     time.Time              `json:"lastHitAt"`
+    ExpiresAt     time.Time              `json:"expiresAt"`
+}
+
+// HelperCacheLimits bounds the cache, a zero limit means unlimited
+type HelperCacheLimits struct {
+    MaxEntries int
+    MaxBytes   int
+}
+
+// Helper endpoints are hit concurrently, every read-modify-write goes through this lock
+var helperCacheMu sync.Mutex
+
+// helperHits are the hits of one entry that are not in the store yet
+type helperHits struct {
+    hits int
+    last time.Time
+}
+
+// Hits are only counted in memory, by DB path and key, so a hit doesn't rewrite the store file.
+// They are saved with the next insert, eviction or clear, a restart loses the ones since
+var pendingHelperHits = make(map[string]map[string]helperHits)
+
+// applyHelperHits adds the unsaved hits of db to entries
+func applyHelperHits(db *DB, entries map[string]HelperCacheEntry) {
+    for key, hit := range pendingHelperHits[db.Path] {
+        if e, ok := entries[key]; ok {
+            e.Hits += hit.hits
+            e.LastHitAt = hit.last
+            entries[key] = e
+        }
+    }
+}
+
+// saveHelperCache writes entries, with the unsaved hits applied already, and forgets those hits
+func saveHelperCache(db *DB, store *Store, entries map[string]HelperCacheEntry) error {
+    if err := store.SetData(entries); err != nil {
+        return err
+    }
+    delete(pendingHelperHits, db.Path)
+    return nil
+}
+
+// GetHelperCacheEntry returns a live entry and counts the hit, expired entries are a miss
+func GetHelperCacheEntry(db *DB, key string) (*HelperCacheEntry, error) {
+    helperCacheMu.Lock()
+    defer helperCacheMu.Unlock()
+
+    store, err := db.GetStore("entries")
+    if err != nil {
+        return nil, err
+    }
+
+    var entries map[string]HelperCacheEntry
+    if err := store.GetData(&entries); err != nil {
+        return nil, nil
+    }
+
+    entry, ok := entries[key]
+    if !ok {
+        return nil, nil
+    }
+    if time.Now().After(entry.ExpiresAt) {
+        applyHelperHits(db, entries)
+        delete(entries, key)
+        return nil, saveHelperCache(db, store, entries)
+    }
+
+    hits := pendingHelperHits[db.Path]
+    if hits == nil {
+        hits = make(map[string]helperHits)
+        pendingHelperHits[db.Path] = hits
+    }
+    hit := hits[key]
+    hit.hits++
+    hit.last = time.Now()
+    hits[key] = hit
+
+    entry.Hits += hit.hits
+    entry.LastHitAt = hit.last
+    return &entry, nil
+}
+
+// PutHelperCacheEntry stores an entry, then drops expired entries and
+// the least recently used ones until the cache fits its limits
+func PutHelperCacheEntry(db *DB, entry HelperCacheEntry, limits HelperCacheLimits) error {
+    helperCacheMu.Lock()
+    defer helperCacheMu.Unlock()
+
+    store, err := db.GetStore("entries")
+    if err != nil {
+        return err
+    }
+
+    var entries map[string]HelperCacheEntry
+    if err := store.GetData(&entries); err != nil {
+        entries = make(map[string]HelperCacheEntry)
+    }
+    // The last hits decide what is least recently used
+    applyHelperHits(db, entries)
+    entries[entry.Key] = entry
+
+    now := time.Now()
+    total := 0
+    list := make([]HelperCacheEntry, 0, len(entries))
+    for key, e := range entries {
+        if now.After(e.ExpiresAt) {
+            delete(entries, key)
+            continue
+        }
+        total += e.Size
+        list = append(list, e)
+    }
+
+    sort.Slice(list, func(i, j int) bool { return lastUsed(list[i]).Before(lastUsed(list[j])) })
+    for _, e := range list {
+        overCount := limits.MaxEntries > 0 && len(entries) > limits.MaxEntries
+        overSize := limits.MaxBytes > 0 && total > limits.MaxBytes
+        if !overCount && !overSize {
+            break
+        }
+        delete(entries, e.Key)
+        total -= e.Size
+    }
+
+    return saveHelperCache(db, store, entries)
+}
+
+// GetHelperCacheEntries returns every entry, expired ones included
+func GetHelperCacheEntries(db *DB) ([]HelperCacheEntry, error) {
+    helperCacheMu.Lock()
+    defer helperCacheMu.Unlock()
+
+    store, err := db.GetStore("entries")
+    if err != nil {
+        return nil, err
+    }
+
+    var entries map[string]HelperCacheEntry
+    if err := store.GetData(&entries); err != nil {
+        return []HelperCacheEntry{}, nil
+    }
+    applyHelperHits(db, entries)
+
+    list := make([]HelperCacheEntry, 0, len(entries))
+    for _, e := range entries {
+        list = append(list, e)
+    }
+    return list, nil
+}
+
+// ClearHelperCache removes the entries of one endpoint, or all of them when endpoint is empty
+// It returns how many entries were removed
+func ClearHelperCache(db *DB, endpoint string) (int, error) {
+    helperCacheMu.Lock()
+    defer helperCacheMu.Unlock()
+
+    store, err := db.GetStore("entries")
+    if err != nil {
+        return 0, err
+    }
+
+    var entries map[string]HelperCacheEntry
+    if err := store.GetData(&entries); err != nil {
+        return 0, nil
+    }
+
+    applyHelperHits(db, entries)
+    removed := 0
+    for key, e := range entries {
+        if endpoint == "" || e.Endpoint == endpoint {
+            delete(entries, key)
+            removed++
+        }
+    }
+    return removed, saveHelperCache(db, store, entries)
+}
+
+// lastUsed is the last hit, or the creation time of an entry that was never hit
+func lastUsed(e HelperCacheEntry) time.Time {
+    if e.LastHitAt.IsZero() {
+        return e.CreatedAt
+    }
+    return e.LastHitAt
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestHelperCacheHitsDontRewriteTheStore(t *testing.T) {
+    db := &DB{Path: t.TempDir()}
+    now := time.Now()
+    put := func(key string, created time.Time) {
+        entry := HelperCacheEntry{Key: key, Endpoint: "tags", Size: 10, CreatedAt: created, ExpiresAt: now.Add(time.Hour)}
+        if err := PutHelperCacheEntry(db, entry, HelperCacheLimits{MaxEntries: 2}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    put("old", now.Add(-2*time.Minute))
+    put("new", now.Add(-time.Minute))
+
+    path := filepath.Join(db.Path, "entries.json")
+    before, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 1; i <= 3; i++ {
+        entry, err := GetHelperCacheEntry(db, "old")
+        if err != nil || entry == nil {
+            t.Fatalf("hit %d: %v, %v", i, entry, err)
+        }
+        if entry.Hits != i {
+            t.Errorf("hit %d: Hits = %d", i, entry.Hits)
+        }
+    }
+    after, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(before) != string(after) {
+        t.Error("a cache hit rewrote the store")
+    }
+
+    entries, err := GetHelperCacheEntries(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, e := range entries {
+        if e.Key == "old" && e.Hits != 3 {
+            t.Errorf("listed entry has %d hits, want 3", e.Hits)
+        }
+    }
+
+    // The hits make "old" the most recently used, so the insert evicts "new" and saves the hits
+    put("third", now)
+    var stored map[string]HelperCacheEntry
+    if err := (&Store{Path: path}).GetData(&stored); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := stored["new"]; ok {
+        t.Error("the least recently used entry was kept")
+    }
+    if stored["old"].Hits != 3 {
+        t.Errorf("saved entry has %d hits, want 3", stored["old"].Hits)
+    }
+    if len(pendingHelperHits[db.Path]) != 0 {
+        t.Error("saved hits are still pending")
+    }
+
+    if entry, _ := GetHelperCacheEntry(db, "old"); entry == nil || entry.Hits != 4 {
+        t.Errorf("hit after the save: %+v, want 4 hits", entry)
+    }
+}
@@ -196,6 +196,7 @@ func DeleteItemFromNotebook(db *DB, username string, id int, itemName string) er
     }
     
     delete(notebook.Items, itemName)
+    delete(notebook.Previews, itemName)
     notebook.UpdatedAt = time.Now()
     userNotebooks[idStr] = notebook
     notebooks[username] = userNotebooks
@@ -0,0 +1,154 @@
+package store
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+// PromptTemplate is one version of a prompt template
+type PromptTemplate struct {
+    Name        string    `json:"name"`
+    Version     int       `json:"version"`
+    Body        string    `json:"body"`
+    Description string    `json:"description,omitempty"`
+    Source      string    `json:"source"`
+    CreatedBy   string    `json:"createdBy,omitempty"`
+    CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Admins can save and switch prompts at the same time, every read and write goes through this lock
+var promptsMu sync.Mutex
+
+// AddPromptTemplate stores a new template version, versions are never overwritten
+func AddPromptTemplate(db *DB, template PromptTemplate) error {
+    promptsMu.Lock()
+    defer promptsMu.Unlock()
+    return addPromptTemplate(db, template)
+}
+
+// AddNextPromptTemplate stores a template as the next version of its name and returns it
+// The version is at least template.Version (the next one after the built in versions) and
+// above every stored version, picked under the lock so two saves never get the same one
+func AddNextPromptTemplate(db *DB, template PromptTemplate, activate bool) (PromptTemplate, error) {
+    promptsMu.Lock()
+    defer promptsMu.Unlock()
+
+    templates, err := readPromptTemplates(db)
+    if err != nil {
+        return template, err
+    }
+    for _, stored := range templates[template.Name] {
+        if stored.Version >= template.Version {
+            template.Version = stored.Version + 1
+        }
+    }
+
+    if err := addPromptTemplate(db, template); err != nil {
+        return template, err
+    }
+    if activate {
+        if err := setActivePromptVersion(db, template.Name, template.Version); err != nil {
+            return template, err
+        }
+    }
+    return template, nil
+}
+
+func addPromptTemplate(db *DB, template PromptTemplate) error {
+    store, err := db.GetStore("templates")
+    if err != nil {
+        return err
+    }
+
+    templates, err := readPromptTemplates(db)
+    if err != nil {
+        return err
+    }
+
+    if _, exists := templates[template.Name]; !exists {
+        templates[template.Name] = make(map[string]PromptTemplate)
+    }
+    versionStr := fmt.Sprintf("%d", template.Version)
+    if _, exists := templates[template.Name][versionStr]; exists {
+        return fmt.Errorf("prompt %s version %d already exists", template.Name, template.Version)
+    }
+
+    templates[template.Name][versionStr] = template
+    return store.SetData(templates)
+}
+
+// readPromptTemplates reads every template, an unreadable store counts as empty
+func readPromptTemplates(db *DB) (map[string]map[string]PromptTemplate, error) {
+    store, err := db.GetStore("templates")
+    if err != nil {
+        return nil, err
+    }
+
+    var templates map[string]map[string]PromptTemplate // name -> version -> template
+    if err := store.GetData(&templates); err != nil || templates == nil {
+        templates = make(map[string]map[string]PromptTemplate)
+    }
+    return templates, nil
+}
+
+// GetPromptTemplates gets every stored version of a template
+func GetPromptTemplates(db *DB, name string) ([]PromptTemplate, error) {
+    promptsMu.Lock()
+    defer promptsMu.Unlock()
+
+    store, err := db.GetStore("templates")
+    if err != nil {
+        return nil, err
+    }
+
+    var templates map[string]map[string]PromptTemplate
+    if err := store.GetData(&templates); err != nil {
+        return nil, err
+    }
+
+    var versions []PromptTemplate
+    for _, template := range templates[name] {
+        versions = append(versions, template)
+    }
+    return versions, nil
+}
+
+// GetActivePromptVersions gets the version each template is switched to
+func GetActivePromptVersions(db *DB) (map[string]int, error) {
+    promptsMu.Lock()
+    defer promptsMu.Unlock()
+
+    store, err := db.GetStore("active")
+    if err != nil {
+        return nil, err
+    }
+
+    var active map[string]int
+    if err := store.GetData(&active); err != nil {
+        return nil, err
+    }
+    return active, nil
+}
+
+// SetActivePromptVersion switches a template to a version
+func SetActivePromptVersion(db *DB, name string, version int) error {
+    promptsMu.Lock()
+    defer promptsMu.Unlock()
+    return setActivePromptVersion(db, name, version)
+}
+
+func setActivePromptVersion(db *DB, name string, version int) error {
+    store, err := db.GetStore("active")
+    if err != nil {
+        return err
+    }
+
+    var active map[string]int
+    if err := store.GetData(&active); err != nil || active == nil {
+        active = make(map[string]int)
+    }
+
+    active[name] = version
+    return store.SetData(active)
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+    "sync"
+    "testing"
+)
+
+func TestAddNextPromptTemplateConcurrent(t *testing.T) {
+    db := &DB{Path: t.TempDir()}
+    const saves = 20
+
+    var wg sync.WaitGroup
+    versions := make(chan int, saves)
+    for i := 0; i < saves; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            // Every save saw the same built in version 1
+            saved, err := AddNextPromptTemplate(db, PromptTemplate{Name: "sheet", Version: 2, Body: "x"}, true)
+            if err != nil {
+                t.Error(err)
+                return
+            }
+            versions <- saved.Version
+        }()
+    }
+    wg.Wait()
+    close(versions)
+
+    seen := make(map[int]bool)
+    for version := range versions {
+        if seen[version] {
+            t.Errorf("version %d was handed out twice", version)
+        }
+        seen[version] = true
+    }
+    for version := 2; version < 2+saves; version++ {
+        if !seen[version] {
+            t.Errorf("version %d is missing", version)
+        }
+    }
+
+    stored, err := GetPromptTemplates(db, "sheet")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(stored) != saves {
+        t.Errorf("%d versions stored, want %d", len(stored), saves)
+    }
+    if active, err := GetActivePromptVersions(db); err != nil || active["sheet"] < 2 {
+        t.Errorf("active version = %v (%v)", active["sheet"], err)
+    }
+}
+
+func TestAddNextPromptTemplateKeepsFloor(t *testing.T) {
+    db := &DB{Path: t.TempDir()}
+    // Disk versions go up to 4, nothing is stored yet
+    saved, err := AddNextPromptTemplate(db, PromptTemplate{Name: "fix", Version: 5}, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved.Version != 5 {
+        t.Errorf("first stored version is %d, want 5", saved.Version)
+    }
+    if active, _ := GetActivePromptVersions(db); active["fix"] != 0 {
+        t.Errorf("saving without activate switched to version %d", active["fix"])
+    }
+}
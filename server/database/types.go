@@ -27,6 +27,18 @@ type Notebook struct {
     UpdatedAt   time.Time         `json:"updatedAt"`
     Optional    Optional          `json:"optional,omitempty"`
     Items       map[string]string `json:"items"`
+    // PromptVersions pins prompt templates (name -> version) for sheets generated in this notebook
+    PromptVersions map[string]int `json:"promptVersions,omitempty"`
+    // Engine is the LaTeX engine sheets in this notebook compile with, empty for the server default
+    Engine string `json:"engine,omitempty"`
+    // Previews holds the thumbnail and page PNG URLs of each item, keyed like Items
+    Previews map[string]ItemPreview `json:"previews,omitempty"`
+}
+
+// ItemPreview are the preview image URLs of a notebook item
+type ItemPreview struct {
+    Thumbnail string   `json:"thumbnail,omitempty"`
+    Pages     []string `json:"pages,omitempty"`
 }
 
 
@@ -0,0 +1,239 @@
+package store
+
+import (
+    "sync"
+    "time"
+)
+
+// UsageRecord is the token usage and cost of one AI call
+type UsageRecord struct {
+    ID               string    `json:"id"`
+    UserID           string    `json:"userId"`
+    JobID            string    `json:"jobId,omitempty"`
+    Purpose          string    `json:"purpose"`
+    Provider         string    `json:"provider"`
+    Model            string    `json:"model"`
+    PromptTokens     int       `json:"promptTokens"`
+    CompletionTokens int       `json:"completionTokens"`
+    TotalTokens      int       `json:"totalTokens"`
+    Cost             float64   `json:"cost"`
+    CreatedAt        time.Time `json:"createdAt"`
+}
+
+// UsageTotals sums a set of usage records
+type UsageTotals struct {
+    Requests         int     `json:"requests"`
+    PromptTokens     int     `json:"promptTokens"`
+    CompletionTokens int     `json:"completionTokens"`
+    TotalTokens      int     `json:"totalTokens"`
+    Cost             float64 `json:"cost"`
+}
+
+// UsageFilter selects usage records, empty fields match everything
+type UsageFilter struct {
+    UserID string
+    JobID  string
+    Since  time.Time
+}
+
+// UsageRetention is how long single records are kept, older ones only live on in the monthly totals
+const UsageRetention = 90 * 24 * time.Hour
+
+// UserUsage is the running usage of one user, so a budget check doesn't read every record
+type UserUsage struct {
+    Day    string                 `json:"day"`
+    Daily  UsageTotals            `json:"daily"`
+    Months map[string]UsageTotals `json:"months"`
+}
+
+// usageSummary is the "totals" store, Users is nil until it was built from the records
+type usageSummary struct {
+    Users     map[string]*UserUsage `json:"users"`
+    LastPrune time.Time             `json:"lastPrune"`
+}
+
+// Sheets generate and fix in parallel, every write goes through this lock
+var usageMu sync.Mutex
+
+// add counts a record in its user's totals, records from an earlier day only count for their month
+func (s *usageSummary) add(record UsageRecord) {
+    usage, ok := s.Users[record.UserID]
+    if !ok {
+        usage = &UserUsage{Months: make(map[string]UsageTotals)}
+        s.Users[record.UserID] = usage
+    }
+    month := record.CreatedAt.Format("2006-01")
+    usage.Months[month] = addTotals(usage.Months[month], record)
+
+    day := record.CreatedAt.Format("2006-01-02")
+    switch {
+    case day == usage.Day:
+        usage.Daily = addTotals(usage.Daily, record)
+    case day > usage.Day:
+        usage.Day = day
+        usage.Daily = addTotals(UsageTotals{}, record)
+    }
+}
+
+func addTotals(totals UsageTotals, record UsageRecord) UsageTotals {
+    totals.Requests++
+    totals.PromptTokens += record.PromptTokens
+    totals.CompletionTokens += record.CompletionTokens
+    totals.TotalTokens += record.TotalTokens
+    totals.Cost += record.Cost
+    return totals
+}
+
+// loadUsage reads the records and the totals, building the totals from the records the first time
+func loadUsage(db *DB) (map[string]UsageRecord, *usageSummary, error) {
+    records, err := readUsageRecords(db)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    store, err := db.GetStore("totals")
+    if err != nil {
+        return nil, nil, err
+    }
+    summary := &usageSummary{}
+    if err := store.GetData(summary); err != nil || summary.Users == nil {
+        summary = &usageSummary{Users: make(map[string]*UserUsage), LastPrune: time.Now()}
+        for _, record := range records {
+            summary.add(record)
+        }
+    }
+    return records, summary, nil
+}
+
+func readUsageRecords(db *DB) (map[string]UsageRecord, error) {
+    store, err := db.GetStore("usage")
+    if err != nil {
+        return nil, err
+    }
+    var records map[string]UsageRecord
+    if err := store.GetData(&records); err != nil || records == nil {
+        records = make(map[string]UsageRecord)
+    }
+    return records, nil
+}
+
+// AddUsageRecord appends a usage record and counts it in its user's totals
+// Once a day, records older than UsageRetention are dropped, the totals already hold them
+func AddUsageRecord(db *DB, record UsageRecord) error {
+    usageMu.Lock()
+    defer usageMu.Unlock()
+
+    records, summary, err := loadUsage(db)
+    if err != nil {
+        return err
+    }
+
+    records[record.ID] = record
+    summary.add(record)
+
+    if time.Since(summary.LastPrune) > 24*time.Hour {
+        cutoff := time.Now().Add(-UsageRetention)
+        for id, old := range records {
+            if old.CreatedAt.Before(cutoff) {
+                delete(records, id)
+            }
+        }
+        summary.LastPrune = time.Now()
+    }
+
+    store, err := db.GetStore("usage")
+    if err != nil {
+        return err
+    }
+    if err := store.SetData(records); err != nil {
+        return err
+    }
+    return saveUsageSummary(db, summary)
+}
+
+func saveUsageSummary(db *DB, summary *usageSummary) error {
+    store, err := db.GetStore("totals")
+    if err != nil {
+        return err
+    }
+    return store.SetData(summary)
+}
+
+// GetUserUsage returns a user's totals for the day and the month of now
+func GetUserUsage(db *DB, userID string, now time.Time) (daily UsageTotals, monthly UsageTotals, err error) {
+    usageMu.Lock()
+    defer usageMu.Unlock()
+
+    store, err := db.GetStore("totals")
+    if err != nil {
+        return daily, monthly, err
+    }
+    summary := &usageSummary{}
+    if err := store.GetData(summary); err != nil || summary.Users == nil {
+        // Usage recorded before the totals existed, build them once
+        if _, summary, err = loadUsage(db); err != nil {
+            return daily, monthly, err
+        }
+        if err := saveUsageSummary(db, summary); err != nil {
+            return daily, monthly, err
+        }
+    }
+
+    usage, ok := summary.Users[userID]
+    if !ok {
+        return daily, monthly, nil
+    }
+    if usage.Day == now.Format("2006-01-02") {
+        daily = usage.Daily
+    }
+    return daily, usage.Months[now.Format("2006-01")], nil
+}
+
+// GetUsageRecords returns the usage records matching the filter
+func GetUsageRecords(db *DB, filter UsageFilter) ([]UsageRecord, error) {
+    usageMu.Lock()
+    defer usageMu.Unlock()
+
+    records, err := readUsageRecords(db)
+    if err != nil {
+        return nil, err
+    }
+
+    var matched []UsageRecord
+    for _, record := range records {
+        if filter.UserID != "" && record.UserID != filter.UserID {
+            continue
+        }
+        if filter.JobID != "" && record.JobID != filter.JobID {
+            continue
+        }
+        if !filter.Since.IsZero() && record.CreatedAt.Before(filter.Since) {
+            continue
+        }
+        matched = append(matched, record)
+    }
+
+    return matched, nil
+}
+
+// SumUsage adds up the usage records matching the filter
+func SumUsage(db *DB, filter UsageFilter) (UsageTotals, error) {
+    records, err := GetUsageRecords(db, filter)
+    if err != nil {
+        return UsageTotals{}, err
+    }
+    return TotalUsage(records), nil
+}
+
+// TotalUsage adds up a list of usage records
+func TotalUsage(records []UsageRecord) UsageTotals {
+    var totals UsageTotals
+    for _, record := range records {
+        totals.Requests++
+        totals.PromptTokens += record.PromptTokens
+        totals.CompletionTokens += record.CompletionTokens
+        totals.TotalTokens += record.TotalTokens
+        totals.Cost += record.Cost
+    }
+    return totals
+}
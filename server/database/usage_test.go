@@ -0,0 +1,114 @@
+package store
+
+import (
+    "testing"
+    "time"
+)
+
+func usageRecord(id, user string, tokens int, created time.Time) UsageRecord {
+    return UsageRecord{ID: id, UserID: user, TotalTokens: tokens, Cost: float64(tokens) / 1000, CreatedAt: created}
+}
+
+func TestUserUsageTotals(t *testing.T) {
+    db := &DB{Path: t.TempDir()}
+    now := time.Now()
+    yesterday := now.AddDate(0, 0, -1)
+    lastMonth := now.AddDate(0, -1, 0)
+    for _, record := range []UsageRecord{
+        usageRecord("a", "ann", 100, now),
+        usageRecord("b", "ann", 50, now),
+        usageRecord("c", "ann", 10, yesterday),
+        usageRecord("d", "ann", 1000, lastMonth),
+        usageRecord("e", "bob", 7, now),
+    } {
+        if err := AddUsageRecord(db, record); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    daily, monthly, err := GetUserUsage(db, "ann", now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantMonthly := 150
+    if yesterday.Month() == now.Month() {
+        wantMonthly += 10
+    }
+    if daily.TotalTokens != 150 || daily.Requests != 2 || monthly.TotalTokens != wantMonthly {
+        t.Errorf("ann used %d tokens today and %d this month, want 150 and %d", daily.TotalTokens, monthly.TotalTokens, wantMonthly)
+    }
+
+    // A new day starts from zero without any write
+    daily, _, err = GetUserUsage(db, "ann", now.AddDate(0, 0, 1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if daily.TotalTokens != 0 {
+        t.Errorf("tomorrow starts with %d tokens, want 0", daily.TotalTokens)
+    }
+
+    if daily, _, _ := GetUserUsage(db, "nobody", now); daily.Requests != 0 {
+        t.Errorf("unknown user has %d requests", daily.Requests)
+    }
+}
+
+func TestUserUsageBuiltFromOldRecords(t *testing.T) {
+    db := &DB{Path: t.TempDir()}
+    now := time.Now()
+    store, err := db.GetStore("usage")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Recorded before the totals store existed
+    if err := store.SetData(map[string]UsageRecord{
+        "a": usageRecord("a", "ann", 40, now),
+        "b": usageRecord("b", "ann", 2, now),
+    }); err != nil {
+        t.Fatal(err)
+    }
+
+    daily, monthly, err := GetUserUsage(db, "ann", now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if daily.TotalTokens != 42 || monthly.TotalTokens != 42 {
+        t.Errorf("built totals are %d today and %d this month, want 42", daily.TotalTokens, monthly.TotalTokens)
+    }
+}
+
+func TestUsagePruneKeepsTotals(t *testing.T) {
+    db := &DB{Path: t.TempDir()}
+    now := time.Now()
+    old := now.Add(-UsageRetention - 24*time.Hour)
+    if err := AddUsageRecord(db, usageRecord("old", "ann", 500, old)); err != nil {
+        t.Fatal(err)
+    }
+
+    // Pretend the last prune was two days ago
+    store, err := db.GetStore("totals")
+    if err != nil {
+        t.Fatal(err)
+    }
+    summary := &usageSummary{}
+    if err := store.GetData(summary); err != nil {
+        t.Fatal(err)
+    }
+    summary.LastPrune = now.Add(-48 * time.Hour)
+    if err := store.SetData(summary); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := AddUsageRecord(db, usageRecord("new", "ann", 5, now)); err != nil {
+        t.Fatal(err)
+    }
+    records, err := GetUsageRecords(db, UsageFilter{UserID: "ann"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) != 1 || records[0].ID != "new" {
+        t.Errorf("records after pruning = %+v, want only the new one", records)
+    }
+    if _, monthly, _ := GetUserUsage(db, "ann", old); monthly.TotalTokens != 500 {
+        t.Errorf("pruned month has %d tokens, want 500", monthly.TotalTokens)
+    }
+}
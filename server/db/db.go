@@ -8,6 +8,9 @@ var SessionsDB *store.DB
 var UsersDB *store.DB
 var QueueDB *store.DB
 var NotebooksDB *store.DB
+var UsageDB *store.DB
+var PromptsDB *store.DB
+var HelperCacheDB *store.DB
 
 func InitSessionsDB() error {
     var err error
@@ -33,4 +36,20 @@ func InitNotebooksDB() error {
     return err
 }
 
+func InitUsageDB() error {
+    var err error
+    UsageDB, err = store.InitDB("usage")
+    return err
+}
+
+func InitPromptsDB() error {
+    var err error
+    PromptsDB, err = store.InitDB("prompts")
+    return err
+}
 
+func InitHelperCacheDB() error {
+    var err error
+    HelperCacheDB, err = store.InitDB("helper_cache")
+    return err
+}
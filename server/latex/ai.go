@@ -9,70 +9,139 @@ import (
     _"strings"
     "time"
 
-    "google.golang.org/api/option"
-    "github.com/google/generative-ai-go/genai"
+    "nadhi.dev/sarvar/fun/config"
+    "nadhi.dev/sarvar/fun/prompts"
 )
 
 const (
-    maxGeminiAttempts = 4
-    geminiTimeout     = 30 * time.Second
+    maxFixAttempts = 4
+    fixTimeout     = 30 * time.Second
 )
 
-// FixLatexWithGemini attempts to fix LaTeX content using Gemini AI
-func FixLatexWithGemini(apiKey, texContent, errorMsg string) (string, error) {
-    if apiKey == "" {
-        return "", fmt.Errorf("missing API key for Gemini")
+// fixTimeoutFromConfig lets slow local models take longer than the default
+// AI_FIX_TIMEOUT is in seconds
+func fixTimeoutFromConfig() time.Duration {
+    if seconds, ok := config.GetConfigValue("AI_FIX_TIMEOUT").(float64); ok && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    return fixTimeout
+}
+
+// Fixer is the AI backend used to repair LaTeX
+// The ai package adapts its providers to this with ai.LatexFixer
+type Fixer interface {
+    FixLatex(ctx context.Context, prompt string) (string, error)
+}
+
+// Repair modes, set with LATEX_FIX_MODE
+const (
+    FixModePatch = "patch"
+    FixModeFull  = "full"
+)
+
+// defaultMaxDeleteShare is the largest part of a document a patch may remove
+const defaultMaxDeleteShare = 0.2
+
+// fixModeFromConfig returns the repair mode, patch unless LATEX_FIX_MODE says full
+func fixModeFromConfig() string {
+    if mode, ok := config.GetConfigValue("LATEX_FIX_MODE").(string); ok && mode == FixModeFull {
+        return FixModeFull
+    }
+    return FixModePatch
+}
+
+// maxDeleteShareFromConfig reads LATEX_FIX_MAX_DELETE_SHARE, a fraction between 0 and 1
+func maxDeleteShareFromConfig() float64 {
+    if share, ok := config.GetConfigValue("LATEX_FIX_MAX_DELETE_SHARE").(float64); ok && share > 0 && share <= 1 {
+        return share
+    }
+    return defaultMaxDeleteShare
+}
+
+// FixResult is one repair attempt
+// Patch is the diff the model answered with, returned even when it was rejected so it can be audited
+type FixResult struct {
+    Mode    string
+    Content string
+    Patch   string
+    Stats   PatchStats
+}
+
+// FixLatex attempts to fix LaTeX content using the configured AI provider
+// diagnostics are the errors parsed from the TeX log, listed in the prompt with their source lines
+// In patch mode the model answers with a unified diff that is applied here, a diff that doesn't
+// apply or removes too much of the document is an error
+func FixLatex(ctx context.Context, fixer Fixer, texContent, errorMsg string, diagnostics []CompileDiagnostic) (*FixResult, error) {
+    if fixer == nil {
+        return nil, fmt.Errorf("no AI fixer configured")
     }
 
-    ctx, cancel := context.WithTimeout(context.Background(), geminiTimeout)
+    ctx, cancel := context.WithTimeout(ctx, fixTimeoutFromConfig())
     defer cancel()
 
-    // Create Gemini client
-    client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+    mode := fixModeFromConfig()
+    maxDeleteShare := maxDeleteShareFromConfig()
+
+    // Create prompt for the fixer from the fix template (pinned version if the job has one)
+    name, document := prompts.Fix, texContent
+    if mode == FixModePatch {
+        name, document = prompts.FixPatch, NumberLines(texContent)
+    }
+    engineTitle := "Tectonic"
+    if engine, err := engineFrom(ctx); err == nil {
+        engineTitle = engine.Title()
+    }
+    rendered, err := prompts.RenderCtx(ctx, name, struct {
+        Engine         string
+        Error          string
+        Diagnostics    string
+        Document       string
+        MaxDeleteShare int
+    }{
+        Engine:         engineTitle,
+        Error:          errorMsg,
+        Diagnostics:    FormatCompileDiagnostics(diagnostics),
+        Document:       document,
+        MaxDeleteShare: int(maxDeleteShare * 100),
+    })
     if err != nil {
-        return "", fmt.Errorf("failed to create Gemini client: %w", err)
+        return nil, fmt.Errorf("failed to build fix prompt: %w", err)
     }
-    defer client.Close()
-
-    // Use Gemini 2.5 Flash model
-    model := client.GenerativeModel("models/gemini-2.5-pro")
-    model.SetTemperature(0.2) // Lower temperature for more deterministic results
-    model.SetMaxOutputTokens(2048)
-
-    // Create prompt for Gemini
-	prompt := fmt.Sprintf(`You are an expert LaTeX engineer whose sole job is to fix LaTeX sources so they compile with Tectonic. Using the ERROR MESSAGE and the LATEX DOCUMENT below, produce a corrected LaTeX source that will compile with Tectonic. Follow these rules strictly:
-1) Diagnose the error from the provided message and make minimal, targeted fixes (syntax, missing braces, unclosed environments, incorrect environment names, missing math delimiters, mismatched \begin/\end, and missing common packages that are needed by the document).
-2) Preserve the original document structure, macros, comments and intent; change only what is necessary to make it compile.
-3) If adding packages is required, add only widely-available packages in the preamble (no external files). Prefer safety and compatibility with Tectonic.
-4) Do not add explanations, diagnostics, or any text outside the LaTeX source. Do not use markdown or code fences.
-5) If a best-effort fix still may have issues, return the best corrected LaTeX source you can produce (still with no explanations).
-6) 
-
-ERROR MESSAGE FOR THE TECTONIC LATEX ENGINE:
-%s
-
-LATEX DOCUMENT:
-%s`, errorMsg, texContent)
-
-    // Call Gemini API
-    resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+    prompt := rendered.User
+
+    responseText, err := fixer.FixLatex(ctx, prompt)
     if err != nil {
-        return "", fmt.Errorf("Gemini API error: %w", err)
+        return nil, fmt.Errorf("AI fixer error: %w", err)
     }
 
-    if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-        return "", fmt.Errorf("empty response from Gemini")
+    if responseText == "" {
+        return nil, fmt.Errorf("empty response from AI fixer")
     }
 
-    // Extract the response text
-    responseText, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
-    if !ok {
-        return "", fmt.Errorf("unexpected response format from Gemini")
+    if mode == FixModeFull {
+        // Clean up the response - remove any markdown code block markers
+        fixedLatex := RemoveCodeBlockMarkers(responseText)
+
+        log.Printf("Successfully received fixed LaTeX from AI fixer")
+        return &FixResult{Mode: mode, Content: fixedLatex}, nil
+    }
+
+    result := &FixResult{Mode: mode, Patch: responseText}
+    patch, err := ParsePatch(responseText)
+    if err != nil {
+        return result, fmt.Errorf("invalid patch from AI fixer: %w", err)
+    }
+    fixedLatex, stats, err := patch.Apply(texContent)
+    result.Stats = stats
+    if err != nil {
+        return result, fmt.Errorf("patch from AI fixer does not apply: %w", err)
+    }
+    if stats.RemovedShare() > maxDeleteShare {
+        return result, fmt.Errorf("patch from AI fixer removes or replaces %d of %d lines, more than the allowed %.0f%%",
+            stats.Removed, stats.DocumentLines, maxDeleteShare*100)
     }
+    result.Content = fixedLatex
 
-    // Clean up the response - remove any markdown code block markers
-    fixedLatex := RemoveCodeBlockMarkers(string(responseText))
-    
-    log.Printf("Successfully received fixed LaTeX from Gemini")
-    return fixedLatex, nil
+    log.Printf("Applied AI fix patch: %d hunks, %d lines removed, %d added", stats.Hunks, stats.Removed, stats.Added)
+    return result, nil
 }
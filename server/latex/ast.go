@@ -0,0 +1,495 @@
+package latex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// NodeKind is the kind of an AST node
+type NodeKind string
+
+const (
+	NodeDocument    NodeKind = "document"
+	NodeText        NodeKind = "text"
+	NodeCommand     NodeKind = "command"
+	NodeGroup       NodeKind = "group"
+	NodeOptional    NodeKind = "optional"
+	NodeEnvironment NodeKind = "environment"
+	NodeMath        NodeKind = "math"
+	NodeComment     NodeKind = "comment"
+	NodeVerbatim    NodeKind = "verbatim"
+)
+
+// Node is one element of a parsed LaTeX source
+// Commands and environments keep their arguments in Args ({...} as groups, [...] as optionals)
+// Environments, groups, optionals and math keep their body in Children
+type Node struct {
+	Kind     NodeKind `json:"kind"`
+	Name     string   `json:"name,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Delim    string   `json:"delim,omitempty"`
+	Display  bool     `json:"display,omitempty"`
+	Args     []*Node  `json:"args,omitempty"`
+	Children []*Node  `json:"children,omitempty"`
+	Pos      Position `json:"pos"`
+	End      Position `json:"end"`
+
+	// Unclosed is set on groups, optionals, math and environments whose closer is missing
+	// End is then where the parser gave up, the place a closer belongs
+	Unclosed bool `json:"unclosed,omitempty"`
+}
+
+// Severity of a diagnostic
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a problem found in a LaTeX source, pointing at where it starts
+// Code names the kind of problem so lint rules can pick up the ones they fix
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Offset   int    `json:"-"`
+	Source   string `json:"source,omitempty"`
+}
+
+func newDiagnostic(severity, code string, pos Position, message string) Diagnostic {
+	return Diagnostic{Severity: severity, Code: code, Message: message, Line: pos.Line, Column: pos.Column, Offset: pos.Offset, Source: "parser"}
+}
+
+// String formats a diagnostic as "line:column: severity: message"
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// mathEnvironments are environments whose body is typeset in math mode
+var mathEnvironments = map[string]bool{
+	"equation": true, "equation*": true,
+	"align": true, "align*": true,
+	"alignat": true, "alignat*": true,
+	"gather": true, "gather*": true,
+	"multline": true, "multline*": true,
+	"flalign": true, "flalign*": true,
+	"eqnarray": true, "eqnarray*": true,
+	"math": true, "displaymath": true,
+}
+
+// IsMathEnvironment reports whether an environment is typeset in math mode
+func IsMathEnvironment(name string) bool {
+	return mathEnvironments[name]
+}
+
+var blankLine = regexp.MustCompile(`\n[ \t]*\n`)
+
+// frame is something the parser is inside of, used to decide who a closing token belongs to
+type frame struct {
+	kind  NodeKind
+	name  string // environment name
+	delim string // math opening delimiter
+}
+
+// parser builds an AST from tokens with recursive descent
+type parser struct {
+	tokens []Token
+	i      int
+	stack  []frame
+	diags  []Diagnostic
+}
+
+// Parse tokenizes and parses a LaTeX source
+// It always returns a tree, problems are reported as diagnostics and parsing carries on
+func Parse(src string) (*Node, []Diagnostic) {
+	p := &parser{tokens: Tokenize(src)}
+	root := &Node{Kind: NodeDocument, Pos: Position{Line: 1, Column: 1}}
+	// Nothing is open at the top level, so this runs to the end reporting stray closers on the way
+	root.Children = p.parseNodes()
+	if len(p.tokens) > 0 {
+		root.End = p.tokens[len(p.tokens)-1].End
+	}
+	return root, p.diags
+}
+
+func (p *parser) peek() *Token {
+	if p.i >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.i]
+}
+
+func (p *parser) errorAt(code string, pos Position, format string, args ...interface{}) {
+	p.diags = append(p.diags, newDiagnostic(SeverityError, code, pos, fmt.Sprintf(format, args...)))
+}
+
+// inside reports whether a frame of this kind (and name or delimiter, if given) is open
+func (p *parser) inside(kind NodeKind, name string) bool {
+	for j := len(p.stack) - 1; j >= 0; j-- {
+		f := p.stack[j]
+		if f.kind == kind && (name == "" || f.name == name || f.delim == name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) top() frame {
+	if len(p.stack) == 0 {
+		return frame{kind: NodeDocument}
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+// parseNodes parses siblings until a token that closes something open (or EOF)
+func (p *parser) parseNodes() []*Node {
+	var nodes []*Node
+	for {
+		tok := p.peek()
+		if tok == nil || p.closes(tok) {
+			return nodes
+		}
+
+		var node *Node
+		switch tok.Kind {
+		case TokenText:
+			if loc := blankLine.FindStringIndex(tok.Value); loc != nil && p.inInlineMath() {
+				// TeX ends the paragraph here and complains about a missing $, the math ends
+				// with the text before the blank line and the rest is parsed outside it
+				p.splitToken(loc[0])
+				tok = p.peek()
+			}
+			p.i++
+			if n := len(nodes); n > 0 && nodes[n-1].Kind == NodeText {
+				nodes[n-1].Text += tok.Value
+				nodes[n-1].End = tok.End
+				continue
+			}
+			node = &Node{Kind: NodeText, Text: tok.Value, Pos: tok.Pos, End: tok.End}
+		case TokenComment:
+			p.i++
+			node = &Node{Kind: NodeComment, Text: tok.Value, Pos: tok.Pos, End: tok.End}
+		case TokenVerbatim:
+			p.i++
+			node = &Node{Kind: NodeVerbatim, Text: tok.Value, Pos: tok.Pos, End: tok.End}
+		case TokenOpenBrace:
+			node = p.parseGroup()
+		case TokenMathShift, TokenMathOpen:
+			node = p.parseMath()
+		case TokenCommand:
+			if tok.Value == "end" {
+				p.stray()
+				continue
+			}
+			if tok.Value == "begin" {
+				node = p.parseEnvironment()
+			} else {
+				node = p.parseCommand()
+			}
+		default:
+			// A closer nothing is waiting for
+			p.stray()
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// closes reports whether tok ends the innermost open frame, or one further out
+// A closer that matches nothing open is left for parseNodes to report as stray
+func (p *parser) closes(tok *Token) bool {
+	top := p.top()
+	switch tok.Kind {
+	case TokenCloseBrace:
+		return p.inside(NodeGroup, "")
+	case TokenText:
+		if loc := blankLine.FindStringIndex(tok.Value); loc != nil && loc[0] == 0 && p.inInlineMath() {
+			return true
+		}
+		return tok.Value == "]" && top.kind == NodeOptional
+	case TokenMathShift:
+		return p.inside(NodeMath, tok.Value)
+	case TokenMathClose:
+		return p.inside(NodeMath, matchingMathOpen(tok.Value))
+	case TokenCommand:
+		if tok.Value != "end" {
+			return false
+		}
+		name := p.groupName(p.i + 1)
+		return p.inside(NodeEnvironment, name) && name != ""
+	}
+	return false
+}
+
+// stray reports and skips a closing token that matches nothing open
+func (p *parser) stray() {
+	tok := p.tokens[p.i]
+	p.i++
+	switch tok.Kind {
+	case TokenCloseBrace:
+		p.errorAt("unmatched-brace", tok.Pos, "unmatched }")
+	case TokenMathClose:
+		p.errorAt("stray-math-close", tok.Pos, "%s without matching %s", tok.Value, matchingMathOpen(tok.Value))
+	case TokenMathShift:
+		p.errorAt("stray-math-shift", tok.Pos, "%s closes math opened with %s", tok.Value, p.top().delim)
+	case TokenCommand:
+		name := p.groupName(p.i)
+		p.skipGroup()
+		p.errorAt("stray-end", tok.Pos, "\\end{%s} without matching \\begin{%s}", name, name)
+	case TokenText:
+		// "]" outside an optional argument is ordinary text
+	}
+}
+
+// parseGroup parses {...}
+func (p *parser) parseGroup() *Node {
+	open := p.tokens[p.i]
+	p.i++
+	node := &Node{Kind: NodeGroup, Pos: open.Pos}
+	p.stack = append(p.stack, frame{kind: NodeGroup})
+	node.Children = p.parseNodes()
+	p.stack = p.stack[:len(p.stack)-1]
+
+	if tok := p.peek(); tok != nil && tok.Kind == TokenCloseBrace {
+		p.i++
+		node.End = tok.End
+	} else {
+		p.errorAt("unclosed-brace", open.Pos, "unclosed {")
+		node.End = p.lastEnd()
+		node.Unclosed = true
+	}
+	return node
+}
+
+// parseOptional parses [...] right after a command
+func (p *parser) parseOptional() *Node {
+	open := p.tokens[p.i]
+	p.i++
+	node := &Node{Kind: NodeOptional, Pos: open.Pos}
+	p.stack = append(p.stack, frame{kind: NodeOptional})
+	node.Children = p.parseNodes()
+	p.stack = p.stack[:len(p.stack)-1]
+
+	if tok := p.peek(); tok != nil && tok.Kind == TokenText && tok.Value == "]" {
+		p.i++
+		node.End = tok.End
+	} else {
+		p.errorAt("unclosed-optional", open.Pos, "unclosed [ in optional argument")
+		node.End = p.lastEnd()
+		node.Unclosed = true
+	}
+	return node
+}
+
+// parseMath parses $...$, $$...$$, \(...\) and \[...\]
+func (p *parser) parseMath() *Node {
+	open := p.tokens[p.i]
+	p.i++
+	if open.Kind == TokenMathShift && p.inside(NodeMath, "") {
+		// A $ inside \[...\] or the wrong kind of $ closer
+		p.errorAt("math-in-math", open.Pos, "%s inside math opened with %s", open.Value, p.top().delim)
+	}
+
+	node := &Node{Kind: NodeMath, Delim: open.Value, Display: open.Value == "$$" || open.Value == "\\[", Pos: open.Pos}
+	p.stack = append(p.stack, frame{kind: NodeMath, delim: open.Value})
+	node.Children = p.parseNodes()
+	p.stack = p.stack[:len(p.stack)-1]
+
+	closer := open.Value
+	if open.Kind == TokenMathOpen {
+		closer = matchingMathClose(open.Value)
+	}
+	if tok := p.peek(); tok != nil && tok.Value == closer && (tok.Kind == TokenMathShift || tok.Kind == TokenMathClose) {
+		p.i++
+		node.End = tok.End
+	} else {
+		if tok != nil && tok.Kind == TokenText && !node.Display && blankLine.MatchString(tok.Value) {
+			p.errorAt("blank-line-in-math", tok.Pos, "blank line inside inline math, missing closing %s", closer)
+		} else {
+			p.errorAt("unclosed-math", open.Pos, "math opened with %s is never closed with %s", open.Value, closer)
+		}
+		node.End = p.lastEnd()
+		node.Unclosed = true
+	}
+	return node
+}
+
+// inInlineMath reports whether the innermost frame is $...$ or \(...\), which a blank line ends
+func (p *parser) inInlineMath() bool {
+	top := p.top()
+	return top.kind == NodeMath && top.delim != "$$" && top.delim != "\\["
+}
+
+// splitToken splits the text token at p.i in two at offset n of its value, n > 0
+func (p *parser) splitToken(n int) {
+	tok := p.tokens[p.i]
+	first, second := tok, tok
+	first.Value = tok.Value[:n]
+	second.Value = tok.Value[n:]
+	first.End = positionAfter(tok.Pos, first.Value)
+	second.Pos = first.End
+	p.tokens = append(p.tokens[:p.i], append([]Token{first, second}, p.tokens[p.i+1:]...)...)
+}
+
+// positionAfter returns the position after s, written at pos
+func positionAfter(pos Position, s string) Position {
+	for _, r := range s {
+		pos.Offset += utf8.RuneLen(r)
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+// parseCommand parses a command and the arguments written right after it
+func (p *parser) parseCommand() *Node {
+	tok := p.tokens[p.i]
+	p.i++
+	node := &Node{Kind: NodeCommand, Name: tok.Value, Pos: tok.Pos, End: tok.End}
+	if tok.Value == "verb" || tok.Value == "verb*" {
+		if next := p.peek(); next != nil && next.Kind == TokenVerbatim {
+			p.i++
+			node.Args = []*Node{{Kind: NodeVerbatim, Text: next.Value, Pos: next.Pos, End: next.End}}
+			node.End = next.End
+		}
+		return node
+	}
+	node.Args = p.parseArgs()
+	if len(node.Args) > 0 {
+		node.End = node.Args[len(node.Args)-1].End
+	}
+	return node
+}
+
+// parseArgs picks up {...} and [...] directly following a command
+// Whitespace ends the arguments, "\item [x]" is text but "\item[x]" is an argument
+func (p *parser) parseArgs() []*Node {
+	var args []*Node
+	for {
+		tok := p.peek()
+		if tok == nil {
+			return args
+		}
+		switch {
+		case tok.Kind == TokenOpenBrace:
+			args = append(args, p.parseGroup())
+		case tok.Kind == TokenText && tok.Value == "[":
+			args = append(args, p.parseOptional())
+		default:
+			return args
+		}
+	}
+}
+
+// parseEnvironment parses \begin{name}...\end{name}
+func (p *parser) parseEnvironment() *Node {
+	begin := p.tokens[p.i]
+	p.i++
+	name := p.groupName(p.i)
+	if name == "" {
+		p.errorAt("missing-environment-name", begin.Pos, "\\begin without an environment name")
+		node := &Node{Kind: NodeCommand, Name: "begin", Pos: begin.Pos, End: begin.End}
+		node.Args = p.parseArgs()
+		return node
+	}
+	p.skipGroup()
+
+	node := &Node{Kind: NodeEnvironment, Name: name, Pos: begin.Pos}
+	node.Args = p.parseArgs()
+	if IsMathEnvironment(name) {
+		node.Display = true
+	}
+
+	p.stack = append(p.stack, frame{kind: NodeEnvironment, name: name})
+	node.Children = p.parseNodes()
+	p.stack = p.stack[:len(p.stack)-1]
+
+	tok := p.peek()
+	if tok != nil && tok.Kind == TokenCommand && tok.Value == "end" && p.groupName(p.i+1) == name {
+		p.i++
+		p.skipGroup()
+		node.End = p.tokens[p.i-1].End
+		return node
+	}
+
+	if tok != nil && tok.Kind == TokenCommand && tok.Value == "end" {
+		p.errorAt("unclosed-environment", begin.Pos, "\\begin{%s} is never closed, \\end{%s} on line %d comes first", name, p.groupName(p.i+1), tok.Pos.Line)
+	} else {
+		p.errorAt("unclosed-environment", begin.Pos, "\\begin{%s} is never closed", name)
+	}
+	node.End = p.lastEnd()
+	node.Unclosed = true
+	return node
+}
+
+// groupName returns the text of a simple {name} group starting at token j
+func (p *parser) groupName(j int) string {
+	if j+2 < len(p.tokens) && p.tokens[j].Kind == TokenOpenBrace && p.tokens[j+1].Kind == TokenText && p.tokens[j+2].Kind == TokenCloseBrace {
+		return strings.TrimSpace(p.tokens[j+1].Value)
+	}
+	return ""
+}
+
+// skipGroup moves past a simple {name} group
+func (p *parser) skipGroup() {
+	if p.groupName(p.i) != "" {
+		p.i += 3
+	}
+}
+
+func (p *parser) lastEnd() Position {
+	if p.i > 0 && p.i <= len(p.tokens) {
+		return p.tokens[p.i-1].End
+	}
+	return Position{}
+}
+
+func matchingMathOpen(close string) string {
+	if close == "\\]" {
+		return "\\["
+	}
+	return "\\("
+}
+
+func matchingMathClose(open string) string {
+	if open == "\\[" {
+		return "\\]"
+	}
+	return "\\)"
+}
+
+// Walk calls fn for every node depth first, arguments before children
+// Returning false skips the node's arguments and children
+func Walk(node *Node, fn func(*Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	for _, arg := range node.Args {
+		Walk(arg, fn)
+	}
+	for _, child := range node.Children {
+		Walk(child, fn)
+	}
+}
+
+// TextOf returns the plain text of a node, commands and comments dropped
+func TextOf(node *Node) string {
+	var b strings.Builder
+	Walk(node, func(n *Node) bool {
+		switch n.Kind {
+		case NodeText, NodeVerbatim:
+			b.WriteString(n.Text)
+		case NodeComment:
+			return false
+		}
+		return true
+	})
+	return b.String()
+}
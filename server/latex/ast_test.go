@@ -0,0 +1,117 @@
+package latex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// nodeString writes a tree as nested (kind name args children), text quoted
+func nodeString(n *Node) string {
+	switch n.Kind {
+	case NodeText, NodeComment, NodeVerbatim:
+		return fmt.Sprintf("%s:%q", n.Kind, n.Text)
+	}
+	var parts []string
+	label := string(n.Kind)
+	if n.Name != "" {
+		label += " " + n.Name
+	}
+	if n.Delim != "" {
+		label += " " + n.Delim
+	}
+	if n.Unclosed {
+		label += " unclosed"
+	}
+	parts = append(parts, label)
+	for _, arg := range n.Args {
+		parts = append(parts, "arg "+nodeString(arg))
+	}
+	for _, child := range n.Children {
+		parts = append(parts, nodeString(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestParseTree(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "command arguments",
+			src:  `\includegraphics[width=3cm]{a.png}`,
+			want: `(document (command includegraphics arg (optional text:"width=3cm") arg (group text:"a.png")))`,
+		},
+		{
+			name: "environment",
+			src:  `\begin{itemize}\item A\end{itemize}`,
+			want: `(document (environment itemize (command item) text:" A"))`,
+		},
+		{
+			name: "math",
+			src:  `$a$ and \[b\]`,
+			want: `(document (math $ text:"a") text:" and " (math \[ text:"b"))`,
+		},
+		{
+			name: "brackets without a command are text",
+			src:  `[x] {y}`,
+			want: `(document text:"[x] " (group text:"y"))`,
+		},
+		{
+			name: "unclosed group",
+			src:  `\textbf{a`,
+			want: `(document (command textbf arg (group unclosed text:"a")))`,
+		},
+		{
+			name: "inline math ends at a blank line",
+			src:  "$a\n\nb",
+			want: `(document (math $ unclosed text:"a") text:"\n\nb")`,
+		},
+		{
+			name: "display math goes on over a blank line",
+			src:  "$$a\n\nb$$",
+			want: `(document (math $$ text:"a\n\nb"))`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, _ := Parse(tt.src)
+			if got := nodeString(root); got != tt.want {
+				t.Errorf("Parse(%q) =\n%s\nwant\n%s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"clean", "\\begin{center}\n$a$ {b}\n\\end{center}", nil},
+		{"unmatched brace", "a}\nb", []string{"unmatched-brace 1:2"}},
+		{"unclosed brace", "x\n\\textbf{a", []string{"unclosed-brace 2:8"}},
+		{"stray end", "a\n\\end{itemize}", []string{"stray-end 2:1"}},
+		{"mismatched end", "\\begin{itemize}\n\\end{enumerate}", []string{"stray-end 2:1", "unclosed-environment 1:1"}},
+		{"unclosed math", "a $b", []string{"unclosed-math 1:3"}},
+		{"blank line in math", "$a\n\nb$", []string{"blank-line-in-math 1:3", "unclosed-math 3:2"}},
+		{"stray math close", `a \)`, []string{"stray-math-close 1:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := Parse(tt.src)
+			var got []string
+			for _, d := range diags {
+				got = append(got, fmt.Sprintf("%s %d:%d", d.Code, d.Line, d.Column))
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("Parse(%q) diagnostics = %v, want %v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
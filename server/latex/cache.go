@@ -0,0 +1,255 @@
+package latex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// CompileCacheConfig is read from LATEX_CACHE
+type CompileCacheConfig struct {
+	Disabled bool   `json:"disabled"`
+	Dir      string `json:"dir"`
+	MaxBytes int64  `json:"maxBytes"`
+}
+
+var defaultCompileCache = CompileCacheConfig{
+	Dir:      "./generated/pdf_cache",
+	MaxBytes: 500 * 1024 * 1024,
+}
+
+func compileCacheFromConfig() CompileCacheConfig {
+	cfg := defaultCompileCache
+	config.DecodeConfigValue("LATEX_CACHE", &cfg)
+	if cfg.Dir == "" {
+		cfg.Dir = defaultCompileCache.Dir
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultCompileCache.MaxBytes
+	}
+	return cfg
+}
+
+// CompileCacheStats is what the cache stats endpoint returns
+// Hits, misses, stores and evictions count since the server started
+type CompileCacheStats struct {
+	Settings  CompileCacheConfig `json:"settings"`
+	Entries   int                `json:"entries"`
+	Bytes     int64              `json:"bytes"`
+	Hits      int64              `json:"hits"`
+	Misses    int64              `json:"misses"`
+	Stores    int64              `json:"stores"`
+	Evictions int64              `json:"evictions"`
+	InFlight  int                `json:"inFlight"`
+}
+
+// compileCall is a compile other callers with the same key wait on
+type compileCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var (
+	compileCacheMu sync.Mutex
+	compileStats   CompileCacheStats
+	inFlight       = make(map[string]*compileCall)
+)
+
+// compileCacheKey hashes the document with the engine and its version, so upgrading an engine
+// doesn't serve PDFs it would compile differently
+func compileCacheKey(content string, engine Engine) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", engine.Name(), EngineVersion(engine.Name()))
+	h.Write([]byte(strings.TrimSpace(content)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// compileCached is convertToPDF behind the compile cache
+// Identical documents compile once: a cached PDF is copied to outputPath, and a caller asking for
+// a document that is already compiling waits for that compile instead of starting its own.
+// The pre-scan runs first, a PDF cached before a primitive was blocked must not be served
+func compileCached(ctx context.Context, latexContent, texFilename, outputPath string) (string, error) {
+	cfg := compileCacheFromConfig()
+	engine, err := engineFrom(ctx)
+	if cfg.Disabled || err != nil {
+		return convertToPDF(ctx, latexContent, texFilename, outputPath)
+	}
+	if err := prescanDocument(latexContent, filepath.Base(texFilename)); err != nil {
+		return "", err
+	}
+
+	key := compileCacheKey(latexContent, engine)
+	cachePath := filepath.Join(cfg.Dir, key+".pdf")
+
+	// Cached PDFs are renamed into place, so they are read without holding up other compiles
+	if data, err := ioutil.ReadFile(cachePath); err == nil {
+		compileCacheMu.Lock()
+		compileStats.Hits++
+		compileCacheMu.Unlock()
+		// Touch it, eviction goes by modification time
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		log.Printf("[DEBUG] Compile cache hit %s for %s", key[:12], texFilename)
+		reportProgress(ctx, "LaTeX", "Same document was compiled before, using the cached PDF", map[string]interface{}{"cache": "hit"})
+		return writePDF(outputPath, data)
+	}
+
+	compileCacheMu.Lock()
+	if call, ok := inFlight[key]; ok {
+		compileCacheMu.Unlock()
+		log.Printf("[DEBUG] Waiting for identical compile %s", key[:12])
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return "", fmt.Errorf("stopped waiting for compile: %w", ctx.Err())
+		}
+		// The job that was compiling it was cancelled, that says nothing about this one
+		if call.err != nil && errors.Is(call.err, context.Canceled) {
+			return convertToPDF(ctx, latexContent, texFilename, outputPath)
+		}
+		if call.err != nil {
+			return "", call.err
+		}
+		if call.data == nil {
+			return convertToPDF(ctx, latexContent, texFilename, outputPath)
+		}
+		compileCacheMu.Lock()
+		compileStats.Hits++
+		compileCacheMu.Unlock()
+		return writePDF(outputPath, call.data)
+	}
+	compileStats.Misses++
+	call := &compileCall{done: make(chan struct{})}
+	inFlight[key] = call
+	compileCacheMu.Unlock()
+
+	defer func() {
+		compileCacheMu.Lock()
+		delete(inFlight, key)
+		compileCacheMu.Unlock()
+		close(call.done)
+	}()
+
+	pdfPath, err := convertToPDF(ctx, latexContent, texFilename, outputPath)
+	if err != nil {
+		call.err = err
+		return "", err
+	}
+	data, err := ioutil.ReadFile(pdfPath)
+	if err != nil {
+		log.Printf("[WARNING] Could not read compiled PDF for the compile cache: %v", err)
+		return pdfPath, nil
+	}
+	call.data = data
+	storeCompiled(cfg, cachePath, data)
+	return pdfPath, nil
+}
+
+// writePDF copies a cached PDF to where the job wants it
+func writePDF(outputPath string, data []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := ioutil.WriteFile(outputPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to copy PDF to final location: %w", err)
+	}
+	return outputPath, nil
+}
+
+// storeCompiled adds a PDF to the cache, then evicts the least recently used ones over MaxBytes
+func storeCompiled(cfg CompileCacheConfig, cachePath string, data []byte) {
+	compileCacheMu.Lock()
+	defer compileCacheMu.Unlock()
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		log.Printf("[WARNING] Could not create compile cache directory: %v", err)
+		return
+	}
+	// Write then rename so a reader never sees half a PDF
+	tmp := cachePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("[WARNING] Could not write to compile cache: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, cachePath); err != nil {
+		os.Remove(tmp)
+		log.Printf("[WARNING] Could not write to compile cache: %v", err)
+		return
+	}
+	compileStats.Stores++
+
+	files, total := cachedFiles(cfg.Dir)
+	for _, f := range files {
+		if total <= cfg.MaxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(cfg.Dir, f.Name())); err == nil {
+			total -= f.Size()
+			compileStats.Evictions++
+		}
+	}
+}
+
+// cachedFiles lists the cached PDFs, least recently used first, and their total size
+func cachedFiles(dir string) ([]os.FileInfo, int64) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, 0
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, f := range entries {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".pdf" {
+			continue
+		}
+		files = append(files, f)
+		total += f.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	return files, total
+}
+
+// GetCompileCacheStats returns the cache settings, size and counters
+func GetCompileCacheStats() CompileCacheStats {
+	cfg := compileCacheFromConfig()
+
+	compileCacheMu.Lock()
+	defer compileCacheMu.Unlock()
+	stats := compileStats
+	stats.Settings = cfg
+	files, total := cachedFiles(cfg.Dir)
+	stats.Entries = len(files)
+	stats.Bytes = total
+	stats.InFlight = len(inFlight)
+	return stats
+}
+
+// ClearCompileCache removes every cached PDF and returns how many there were
+func ClearCompileCache() (int, error) {
+	cfg := compileCacheFromConfig()
+
+	compileCacheMu.Lock()
+	defer compileCacheMu.Unlock()
+	files, _ := cachedFiles(cfg.Dir)
+	removed := 0
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(cfg.Dir, f.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", f.Name(), err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,67 @@
+package latex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cacheTestEngine is "installed" since its only binary is true, it never gets to compile anything
+const cacheTestEngine = "cache-test"
+
+// seedCompileCache runs the test in a fresh directory, so the default cache lives there, and caches pdf for content
+func seedCompileCache(t *testing.T, content string, pdf []byte) context.Context {
+	chdirTemp(t)
+	RegisterEngine(&commandEngine{name: cacheTestEngine, title: "Cache test", binaries: []string{"true"},
+		args: func(texPath, outDir string) []string { return []string{"true"} }})
+	t.Cleanup(func() {
+		enginesMu.Lock()
+		delete(engines, cacheTestEngine)
+		detected = nil
+		enginesMu.Unlock()
+	})
+
+	engine, ok := engineAvailable(cacheTestEngine)
+	if !ok {
+		t.Skip("no true binary on PATH")
+	}
+	cfg := compileCacheFromConfig()
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cachePath := filepath.Join(cfg.Dir, compileCacheKey(content, engine)+".pdf")
+	if err := os.WriteFile(cachePath, pdf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return WithEngine(context.Background(), cacheTestEngine)
+}
+
+func TestCompileCacheHit(t *testing.T) {
+	content := "\\documentclass{article}\n\\begin{document}\nCached\n\\end{document}\n"
+	ctx := seedCompileCache(t, content, []byte("%PDF-cached"))
+
+	out, err := compileCached(ctx, content, "job.tex", filepath.Join("bucket", "job.pdf"))
+	if err != nil {
+		t.Fatalf("compileCached: %v", err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "%PDF-cached" {
+		t.Errorf("output is %q (%v), want the cached PDF", data, err)
+	}
+}
+
+func TestCompileCacheBlockedDocument(t *testing.T) {
+	// Cached before \input of absolute paths was blocked
+	content := "\\documentclass{article}\n\\begin{document}\n\\input{/etc/passwd}\n\\end{document}\n"
+	ctx := seedCompileCache(t, content, []byte("%PDF-leaked"))
+
+	out := filepath.Join("bucket", "job.pdf")
+	_, err := compileCached(ctx, content, "job.tex", out)
+	if !errors.Is(err, ErrBlocked) {
+		t.Fatalf("compileCached error = %v, want ErrBlocked", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("the cached PDF of a blocked document was served")
+	}
+}
@@ -1,17 +1,18 @@
 package latex
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
 // ConvertLatexToPDFWithRetry tries to convert LaTeX to PDF with AI-powered fixes
-func ConvertLatexToPDFWithRetry(latexContent, texFilename, outputPath, apiKey string) (string, error) {
+func ConvertLatexToPDFWithRetry(ctx context.Context, latexContent, texFilename, outputPath string, fixer Fixer) (string, error) {
 	const maxAttempts = 3
 	var conversionErr error
 
@@ -31,9 +32,11 @@ func ConvertLatexToPDFWithRetry(latexContent, texFilename, outputPath, apiKey st
 		return "", fmt.Errorf("failed to create fixes directory: %w", err)
 	}
 
-	// Initial attempt with original content
-	pdfPath, conversionErr := convertToPDF(latexContent, texFilename, outputPath)
+	// Initial attempt with original content, after the cheap deterministic fixes
+	latexContent = lintForCompile(ctx, latexContent)
+	pdfPath, conversionErr := compileCached(ctx, latexContent, texFilename, outputPath)
 	if conversionErr == nil {
+		keepSource(pdfPath, latexContent)
 		return pdfPath, nil
 	}
 
@@ -50,30 +53,79 @@ func ConvertLatexToPDFWithRetry(latexContent, texFilename, outputPath, apiKey st
 	// Try with AI fixes
 	currentContent := latexContent
 	var errorMsg string
+	var rejected string
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		log.Printf("Gemini fix attempt %d/%d", attempt, maxAttempts)
+		// A cancelled job stops here instead of asking for fixes nobody will see
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("LaTeX conversion stopped: %w", err)
+		}
+		log.Printf("AI fix attempt %d/%d", attempt, maxAttempts)
 
 		// Get error message from last attempt
 		errorMsg = extractErrorMessage(conversionErr)
+		diagnostics := CompileDiagnostics(conversionErr)
+		for _, d := range diagnostics {
+			stage := "LaTeX"
+			if d.Class == ClassBlocked {
+				stage = "Sandbox"
+			}
+			reportProgress(ctx, stage, d.String(), map[string]interface{}{"diagnostic": d})
+		}
 
-		// Request fix from Gemini
-		fixedContent, err := FixLatexWithGemini(apiKey, currentContent, errorMsg)
+		// Point the fixer at the exact lines the parser is unhappy with
+		if parsed, err := ParseLaTeX(currentContent); err == nil && len(parsed.Diagnostics) > 0 {
+			errorMsg += "\n\nSTATIC ANALYSIS (line:column):\n" + FormatDiagnostics(parsed.Diagnostics)
+		}
+
+		// Tell the model why its last patch was thrown away
+		if rejected != "" {
+			errorMsg += "\n\nYOUR PREVIOUS PATCH WAS REJECTED: " + rejected
+		}
+
+		// Request fix from the AI provider
+		fix, err := FixLatex(ctx, fixer, currentContent, errorMsg, diagnostics)
+
+		// Keep every patch, applied or not, for auditing
+		attemptBase := filepath.Join(fixesDir, strings.TrimSuffix(texFilename, ".tex")+fmt.Sprintf(".attempt%d", attempt))
+		if fix != nil && fix.Patch != "" {
+			patchFile := attemptBase + ".patch"
+			if err != nil {
+				patchFile = attemptBase + ".rejected.patch"
+			}
+			if writeErr := ioutil.WriteFile(patchFile, []byte(fix.Patch), 0644); writeErr != nil {
+				log.Printf("Warning: Could not save patch for attempt %d: %v", attempt, writeErr)
+			}
+		}
 		if err != nil {
-			log.Printf("Failed to get Gemini fix: %v", err)
+			log.Printf("Failed to get AI fix: %v", err)
+			rejected = ""
+			if fix != nil {
+				rejected = err.Error()
+				reportProgress(ctx, "Fix", "Patch rejected: "+err.Error(), map[string]interface{}{"attempt": attempt})
+			}
 			continue
 		}
+		rejected = ""
+		fixedContent := fix.Content
+		if fix.Mode == FixModePatch {
+			reportProgress(ctx, "Fix", fmt.Sprintf("Applied patch: %d lines removed, %d added", fix.Stats.Removed, fix.Stats.Added), map[string]interface{}{
+				"attempt": attempt,
+				"stats":   fix.Stats,
+			})
+		}
 
 		// Save this attempt for debugging
-		attemptFile := filepath.Join(fixesDir, strings.TrimSuffix(texFilename, ".tex")+fmt.Sprintf(".attempt%d.tex", attempt))
-		if err := ioutil.WriteFile(attemptFile, []byte(fixedContent), 0644); err != nil {
+		if err := ioutil.WriteFile(attemptBase+".tex", []byte(fixedContent), 0644); err != nil {
 			log.Printf("Warning: Could not save attempt %d: %v", attempt, err)
 		}
 
-		// Try conversion with fixed content
-		pdfPath, conversionErr = convertToPDF(fixedContent, texFilename, outputPath)
+		// Try conversion with fixed content, linted first so the next AI call only sees what lint can't fix
+		fixedContent = lintForCompile(ctx, fixedContent)
+		pdfPath, conversionErr = compileCached(ctx, fixedContent, texFilename, outputPath)
 		if conversionErr == nil {
 			log.Printf("Successfully fixed and converted LaTeX on attempt %d", attempt)
+			keepSource(pdfPath, fixedContent)
 			return pdfPath, nil
 		}
 
@@ -84,7 +136,43 @@ func ConvertLatexToPDFWithRetry(latexContent, texFilename, outputPath, apiKey st
 	}
 
 	// If we get here, all attempts failed
-	return "", fmt.Errorf("failed to convert LaTeX to PDF after %d Gemini fix attempts: %v", maxAttempts, conversionErr)
+	return "", fmt.Errorf("failed to convert LaTeX to PDF after %d AI fix attempts: %w", maxAttempts, conversionErr)
+}
+
+// SourcePath is where the source a PDF compiled from is kept, with the job's other generated files
+// The bucket is listed and served publicly, so the source stays out of it
+func SourcePath(pdfPath string) string {
+	id := strings.TrimSuffix(filepath.Base(pdfPath), filepath.Ext(pdfPath))
+	return filepath.Join("./generated", id, id+".compiled.tex")
+}
+
+// keepSource saves the source that compiled, the HTML and Markdown exports are made from it
+func keepSource(pdfPath, content string) {
+	texPath := SourcePath(pdfPath)
+	if err := os.MkdirAll(filepath.Dir(texPath), 0755); err != nil {
+		log.Printf("[WARNING] Could not create directory for the LaTeX source: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(texPath, []byte(content), 0644); err != nil {
+		log.Printf("[WARNING] Could not save LaTeX source: %v", err)
+		return
+	}
+	// Older versions kept it beside the PDF, where anyone could download it
+	os.Remove(strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath)) + ".tex")
+}
+
+// lintForCompile applies the lint fixes and reports each one as a stage update
+func lintForCompile(ctx context.Context, content string) string {
+	linted := Lint(content)
+	for _, fix := range linted.Fixes {
+		log.Printf("[DEBUG] Lint %s at %d:%d: %s", fix.Rule, fix.Line, fix.Column, fix.Message)
+		reportProgress(ctx, "Lint", fmt.Sprintf("Line %d: %s", fix.Line, fix.Message), map[string]interface{}{
+			"rule":   fix.Rule,
+			"line":   fix.Line,
+			"column": fix.Column,
+		})
+	}
+	return linted.Content
 }
 
 // extractErrorMessage gets a clean error message from the conversion error
@@ -93,11 +181,17 @@ func extractErrorMessage(err error) string {
 		return ""
 	}
 
+	// Parsed diagnostics go to the fixer on their own, only the summary is needed here
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) && len(compileErr.Diagnostics) > 0 {
+		return compileErr.Err.Error()
+	}
+
 	// Extract relevant part from error message
 	errorMsg := err.Error()
 
-	// Look for Tectonic output in the error
-	const outputMarker = "Tectonic output:"
+	// Look for the engine output in the error
+	const outputMarker = " output:\n"
 	if idx := strings.Index(errorMsg, outputMarker); idx >= 0 {
 		errorMsg = errorMsg[idx+len(outputMarker):]
 	}
@@ -118,7 +212,13 @@ func truncateString(s string, max int) string {
 	return s[:max] + "..."
 }
 
-func convertToPDF(latexContent, texFilename, outputPath string) (string, error) {
+// convertToPDF compiles with the engine picked for ctx, see WithEngine
+func convertToPDF(ctx context.Context, latexContent, texFilename, outputPath string) (string, error) {
+	engine, err := engineFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	// Check if LaTeX content is empty before proceeding
 	latexContent = strings.TrimSpace(latexContent)
 	if latexContent == "" {
@@ -136,6 +236,12 @@ func convertToPDF(latexContent, texFilename, outputPath string) (string, error)
 		return "", fmt.Errorf("invalid LaTeX content: missing required elements")
 	}
 
+	// Refuse documents that read files outside the jail or run programs before anything runs
+	texFilename = filepath.Base(texFilename)
+	if err := prescanDocument(latexContent, texFilename); err != nil {
+		return "", err
+	}
+
 	// Log the first and last 100 characters of the content for debugging
 	contentPreview := latexContent
 	if len(contentPreview) > 200 {
@@ -143,13 +249,17 @@ func convertToPDF(latexContent, texFilename, outputPath string) (string, error)
 	}
 	log.Printf("[DEBUG] LaTeX content preview: %s", contentPreview)
 
-	// Create temporary directory for conversion
-	tempDir, err := ioutil.TempDir("", "latex-conversion")
+	// Create the jail directory for conversion, the engine can only touch files in it
+	sandbox := sandboxFromConfig()
+	tempDir, err := sandbox.newJail()
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
+	ctx, cancel := context.WithTimeout(ctx, sandbox.Timeout())
+	defer cancel()
+
 	// Log directory and file details
 	log.Printf("[DEBUG] LaTeX conversion temp directory: %s", tempDir)
 	log.Printf("[DEBUG] LaTeX content length: %d bytes", len(latexContent))
@@ -175,27 +285,45 @@ func convertToPDF(latexContent, texFilename, outputPath string) (string, error)
 
 	log.Printf("[DEBUG] Expected PDF output path: %s", tempPDFPath)
 
-	// Run Tectonic command with detailed output capture
-	cmd := exec.Command("tectonic", "--outfmt=pdf", "--keep-logs", "-o", tempDir, tempTexPath)
-
-	// Set working directory to temp directory to ensure relative paths work
-	cmd.Dir = tempDir
-	log.Printf("[DEBUG] Running Tectonic in directory: %s", cmd.Dir)
-	log.Printf("[DEBUG] Tectonic command: %v", cmd.Args)
+	// Run the engine with detailed output capture, in the temp directory so relative paths work
+	cmd := engine.Command(ctx, tempTexPath, tempDir)
+	sandboxCommand(cmd, sandbox, tempDir)
+	log.Printf("[DEBUG] Running %s in directory: %s", engine.Title(), cmd.Dir)
+	log.Printf("[DEBUG] %s command: %v", engine.Title(), cmd.Args)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		// The job was cancelled, the engine was killed and there is nothing to diagnose
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return "", fmt.Errorf("%s stopped: %w", engine.Name(), ctx.Err())
+		}
+
 		// Save the error output for debugging
 		errorLogPath := filepath.Join("./generated/error_logs", fileBase+".log")
 		os.MkdirAll(filepath.Dir(errorLogPath), 0755)
 		ioutil.WriteFile(errorLogPath, output, 0644)
 
-		return "", fmt.Errorf("%w\nTectonic output:\n%s", err, string(output))
+		// The .log kept next to the PDF has the l.<line> context the console output lacks
+		logText := string(output)
+		if texLog, readErr := ioutil.ReadFile(filepath.Join(tempDir, fileBase+".log")); readErr == nil {
+			logText = string(texLog) + "\n" + logText
+		}
+		diagnostics := ParseTeXLog(logText, texFilename)
+		attachSourceContext(diagnostics, latexContent)
+
+		// Timeouts and rlimits get a clear message instead of "signal: killed"
+		if stopped := sandboxError(ctx, cmd, string(output), err, sandbox); stopped != nil {
+			log.Printf("[WARNING] Sandbox stopped %s: %v", engine.Name(), stopped)
+			reportProgress(ctx, "Sandbox", stopped.Error(), nil)
+			err = stopped
+		}
+
+		return "", &CompileError{Engine: engine.Title(), Err: err, Output: string(output), Diagnostics: diagnostics}
 	}
 
 	// Check if PDF was created
 	if _, err := os.Stat(tempPDFPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("tectonic completed but PDF file not found\nOutput: %s", string(output))
+		return "", fmt.Errorf("%s completed but PDF file not found\nOutput: %s", engine.Name(), string(output))
 	}
 
 	log.Printf("[DEBUG] PDF file created successfully at: %s", tempPDFPath)
@@ -219,3 +347,16 @@ func convertToPDF(latexContent, texFilename, outputPath string) (string, error)
 
 	return outputPath, nil
 }
+
+// prescanDocument runs Prescan on a document, returning the blocked primitives as a CompileError
+func prescanDocument(latexContent, texFilename string) error {
+	blocked := Prescan(latexContent)
+	if len(blocked) == 0 {
+		return nil
+	}
+	for i := range blocked {
+		blocked[i].File = texFilename
+	}
+	log.Printf("[WARNING] Sandbox pre-scan blocked %d primitives in %s", len(blocked), texFilename)
+	return &CompileError{Engine: "Sandbox", Err: ErrBlocked, Output: FormatCompileDiagnostics(blocked), Diagnostics: blocked}
+}
@@ -0,0 +1,264 @@
+package latex
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// Engine compiles a .tex file into a PDF in the same directory
+// Every engine writes <name>.log next to the PDF, which ParseTeXLog reads
+type Engine interface {
+	// Name is what jobs, notebooks and LATEX_ENGINE use to pick the engine
+	Name() string
+	// Title is the human readable name, used in prompts and logs
+	Title() string
+	// Binaries must all be on PATH for the engine to be available
+	Binaries() []string
+	// Command builds the command that compiles texPath, with outDir as the working and output directory
+	Command(ctx context.Context, texPath, outDir string) *exec.Cmd
+}
+
+// Engine names
+const (
+	EngineTectonic = "tectonic"
+	EnginePDFLaTeX = "pdflatex"
+	EngineXeLaTeX  = "xelatex"
+	EngineLuaLaTeX = "lualatex"
+)
+
+// enginePreference is the order the default engine is picked in when LATEX_ENGINE is not set
+var enginePreference = []string{EngineTectonic, EnginePDFLaTeX, EngineXeLaTeX, EngineLuaLaTeX}
+
+// commandEngine is an engine that runs a single command
+type commandEngine struct {
+	name     string
+	title    string
+	binaries []string
+	args     func(texPath, outDir string) []string
+}
+
+func (e *commandEngine) Name() string       { return e.name }
+func (e *commandEngine) Title() string      { return e.title }
+func (e *commandEngine) Binaries() []string { return e.binaries }
+
+func (e *commandEngine) Command(ctx context.Context, texPath, outDir string) *exec.Cmd {
+	args := e.args(texPath, outDir)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = outDir
+	return cmd
+}
+
+// latexmk runs a TeX Live engine as many times as the document needs
+func latexmk(flag string) func(texPath, outDir string) []string {
+	return func(texPath, outDir string) []string {
+		return []string{"latexmk", flag, "-no-shell-escape", "-interaction=nonstopmode", "-halt-on-error", "-outdir=" + outDir, filepath.Base(texPath)}
+	}
+}
+
+var (
+	engines   = make(map[string]Engine)
+	enginesMu sync.RWMutex
+
+	// detected is filled in by DetectEngines, nil until it has run
+	detected map[string]bool
+	// versions holds the first line of each installed engine's --version
+	versions map[string]string
+)
+
+func init() {
+	RegisterEngine(&commandEngine{
+		name:     EngineTectonic,
+		title:    "Tectonic",
+		binaries: []string{"tectonic"},
+		args: func(texPath, outDir string) []string {
+			return []string{"tectonic", "--untrusted", "--outfmt=pdf", "--keep-logs", "-o", outDir, texPath}
+		},
+	})
+	RegisterEngine(&commandEngine{
+		name:     EnginePDFLaTeX,
+		title:    "pdfLaTeX",
+		binaries: []string{"latexmk", "pdflatex"},
+		args:     latexmk("-pdf"),
+	})
+	RegisterEngine(&commandEngine{
+		name:     EngineXeLaTeX,
+		title:    "XeLaTeX",
+		binaries: []string{"latexmk", "xelatex"},
+		args:     latexmk("-xelatex"),
+	})
+	RegisterEngine(&commandEngine{
+		name:     EngineLuaLaTeX,
+		title:    "LuaLaTeX",
+		binaries: []string{"latexmk", "lualatex"},
+		args:     latexmk("-lualatex"),
+	})
+}
+
+// RegisterEngine adds an engine, replacing any engine with the same name
+func RegisterEngine(engine Engine) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	engines[engine.Name()] = engine
+	detected = nil
+}
+
+// DetectEngines looks up every registered engine's binaries and returns the names of the installed ones
+// It runs at startup, anything asking for engines before that triggers it
+func DetectEngines() []string {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	detected = make(map[string]bool)
+	versions = make(map[string]string)
+	var available []string
+	for name, engine := range engines {
+		ok := true
+		for _, binary := range engine.Binaries() {
+			if _, err := exec.LookPath(binary); err != nil {
+				ok = false
+				break
+			}
+		}
+		detected[name] = ok
+		if ok {
+			available = append(available, name)
+			versions[name] = binaryVersion(engine.Binaries()[len(engine.Binaries())-1])
+		}
+	}
+	sort.Strings(available)
+	return available
+}
+
+// binaryVersion returns the first line of "<binary> --version", the engine itself for latexmk engines
+func binaryVersion(binary string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, binary, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+// EngineVersion returns the version an installed engine reported at detection
+func EngineVersion(name string) string {
+	engineAvailable(name)
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	return versions[name]
+}
+
+// EngineInfo describes an engine for the API
+type EngineInfo struct {
+	Name      string `json:"name"`
+	Title     string `json:"title"`
+	Version   string `json:"version,omitempty"`
+	Available bool   `json:"available"`
+	Default   bool   `json:"default"`
+}
+
+// Engines lists every registered engine and whether it is installed
+func Engines() []EngineInfo {
+	def := DefaultEngine()
+
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	var infos []EngineInfo
+	for name, engine := range engines {
+		infos = append(infos, EngineInfo{
+			Name:      name,
+			Title:     engine.Title(),
+			Version:   versions[name],
+			Available: detected[name],
+			Default:   def != nil && def.Name() == name,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// engineAvailable reports whether an engine is registered and installed
+func engineAvailable(name string) (Engine, bool) {
+	enginesMu.RLock()
+	needsDetect := detected == nil
+	enginesMu.RUnlock()
+	if needsDetect {
+		DetectEngines()
+	}
+
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	engine, ok := engines[name]
+	return engine, ok && detected[name]
+}
+
+// DefaultEngine returns LATEX_ENGINE if it is installed, otherwise the first installed engine
+// in the order tectonic, pdflatex, xelatex, lualatex. Nil when none is installed
+func DefaultEngine() Engine {
+	if name, ok := config.GetConfigValue("LATEX_ENGINE").(string); ok && name != "" {
+		if engine, ok := engineAvailable(name); ok {
+			return engine
+		}
+		log.Printf("[WARNING] LATEX_ENGINE %q is not installed, picking another engine", name)
+	}
+	for _, name := range enginePreference {
+		if engine, ok := engineAvailable(name); ok {
+			return engine
+		}
+	}
+	return nil
+}
+
+// ResolveEngine returns the named engine, or the default one for an empty name
+func ResolveEngine(name string) (Engine, error) {
+	if name == "" {
+		if engine := DefaultEngine(); engine != nil {
+			return engine, nil
+		}
+		return nil, fmt.Errorf("no LaTeX engine installed")
+	}
+	enginesMu.RLock()
+	_, known := engines[name]
+	enginesMu.RUnlock()
+	if !known {
+		return nil, fmt.Errorf("unknown LaTeX engine %q", name)
+	}
+	engine, ok := engineAvailable(name)
+	if !ok {
+		return nil, fmt.Errorf("LaTeX engine %q is not installed", name)
+	}
+	return engine, nil
+}
+
+type engineKey struct{}
+
+// WithEngine picks the engine compiles under ctx use, an empty name keeps the default
+func WithEngine(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, engineKey{}, name)
+}
+
+// EngineFor is ResolveEngine for a job, an engine that has gone missing since the job was
+// queued falls back to the default
+func EngineFor(name string) (Engine, error) {
+	engine, err := ResolveEngine(name)
+	if err != nil && name != "" {
+		log.Printf("[WARNING] %v, using the default engine", err)
+		return ResolveEngine("")
+	}
+	return engine, err
+}
+
+// engineFrom returns the engine picked for ctx
+func engineFrom(ctx context.Context) (Engine, error) {
+	name, _ := ctx.Value(engineKey{}).(string)
+	return EngineFor(name)
+}
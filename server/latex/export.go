@@ -0,0 +1,1043 @@
+package latex
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats, PDF is what the compiler produces
+const (
+	FormatPDF      = "pdf"
+	FormatHTML     = "html"
+	FormatMarkdown = "md"
+)
+
+// ExportFormat normalizes a format name from a query string, false when it is not one we export
+func ExportFormat(name string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "pdf":
+		return FormatPDF, true
+	case "html", "htm":
+		return FormatHTML, true
+	case "md", "markdown":
+		return FormatMarkdown, true
+	}
+	return "", false
+}
+
+// Separators a tabular body is rendered with before it is split into rows and cells, and the
+// line break marker, replaced once the body is done so the newline after \\ can't start a paragraph
+const (
+	cellSep   = "\x00cell\x00"
+	rowSep    = "\x00row\x00"
+	lineBreak = "\x00br\x00"
+)
+
+var lineBreaks = regexp.MustCompile(lineBreak + `\s*`)
+
+// exporter renders the body of a worksheet as HTML or Markdown
+// Math is passed through as TeX for MathJax (or KaTeX) to typeset in the browser
+type exporter struct {
+	src      string
+	markdown bool
+	colors   map[string]string
+	title    string
+	author   string
+	date     string
+
+	// nested is above zero inside lists, boxes and tables, where blank lines don't start paragraphs
+	nested    int
+	listDepth int
+	inTable   bool
+
+	// styles are the CSS rules of the classes colours are given with, the page's CSP allows no style attributes
+	styles       []string
+	styleClasses map[string]string
+}
+
+// ExportHTML converts a worksheet to a standalone responsive HTML page that typesets its math with MathJax
+func ExportHTML(src string) string {
+	e := newExporter(src, false)
+	body := e.paragraphs(lineBreaks.ReplaceAllString(e.body(), "<br>\n"))
+
+	title := e.title
+	if title == "" {
+		title = "Worksheet"
+	}
+	var header strings.Builder
+	if e.title != "" {
+		header.WriteString("<header>\n<h1>" + e.title + "</h1>\n")
+		if byline := e.byline(" &middot; "); byline != "" {
+			header.WriteString(`<p class="byline">` + byline + "</p>\n")
+		}
+		header.WriteString("</header>\n")
+	}
+	return fmt.Sprintf(htmlPage, stripTags(title), strings.Join(e.styles, "\n"), header.String(), body)
+}
+
+// ExportPolicy is the Content-Security-Policy an exported page is served with. Only MathJax from
+// its CDN and the page's own script and style, marked with nonce by WithNonce, are allowed
+func ExportPolicy(nonce string) string {
+	return fmt.Sprintf("default-src 'none'; script-src 'nonce-%[1]s' https://cdn.jsdelivr.net; "+
+		"style-src 'nonce-%[1]s'; font-src https://cdn.jsdelivr.net; img-src 'self' data:; "+
+		"base-uri 'none'; form-action 'none'", nonce)
+}
+
+// WithNonce adds nonce to the inline <script> and <style> of an exported page
+// Only the first of each is marked, text from the worksheet is escaped and can't add tags of its own
+func WithNonce(page []byte, nonce string) []byte {
+	attr := ` nonce="` + html.EscapeString(nonce) + `">`
+	out := strings.Replace(string(page), "<script>", "<script"+attr, 1)
+	out = strings.Replace(out, "<style>", "<style"+attr, 1)
+	return []byte(out)
+}
+
+// ExportMarkdown converts a worksheet to Markdown, math kept as $...$ and $$...$$ for MathJax or KaTeX
+func ExportMarkdown(src string) string {
+	e := newExporter(src, true)
+	body := lineBreaks.ReplaceAllString(e.body(), "  \n")
+
+	var b strings.Builder
+	if e.title != "" {
+		b.WriteString("# " + e.title + "\n\n")
+		if byline := e.byline(" · "); byline != "" {
+			b.WriteString("_" + byline + "_\n\n")
+		}
+	}
+	b.WriteString(body)
+	return strings.TrimSpace(collapseBlankLines(b.String())) + "\n"
+}
+
+// Export converts a worksheet to the given format
+func Export(src, format string) (string, error) {
+	switch format {
+	case FormatHTML:
+		return ExportHTML(src), nil
+	case FormatMarkdown:
+		return ExportMarkdown(src), nil
+	}
+	return "", fmt.Errorf("cannot export LaTeX to %q", format)
+}
+
+// ExportBeside writes the HTML or Markdown version of a compiled PDF next to it, from the
+// source the compile kept at SourcePath. It returns the path of the exported file
+func ExportBeside(pdfPath, format string) (string, error) {
+	base := strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath))
+	src, err := ioutil.ReadFile(SourcePath(pdfPath))
+	if err != nil {
+		return "", fmt.Errorf("no LaTeX source for %s: %w", filepath.Base(pdfPath), err)
+	}
+	out, err := Export(string(src), format)
+	if err != nil {
+		return "", err
+	}
+	path := base + "." + format
+	if err := ioutil.WriteFile(path, []byte(out), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return path, nil
+}
+
+// ExportedPath returns the file a PDF is exported to in a format, exporting it first when it
+// is missing or older than the source
+func ExportedPath(pdfPath, format string) (string, error) {
+	if format == FormatPDF {
+		return pdfPath, nil
+	}
+	base := strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath))
+	path := base + "." + format
+	if exported, err := os.Stat(path); err == nil {
+		if src, err := os.Stat(SourcePath(pdfPath)); err != nil || !src.ModTime().After(exported.ModTime()) {
+			return path, nil
+		}
+	}
+	return ExportBeside(pdfPath, format)
+}
+
+func newExporter(src string, markdown bool) *exporter {
+	e := &exporter{src: src, markdown: markdown, colors: make(map[string]string), styleClasses: make(map[string]string)}
+	root, _ := Parse(src)
+	// Colors and the title block are usually set in the preamble
+	Walk(root, func(n *Node) bool {
+		if n.Kind != NodeCommand {
+			return true
+		}
+		switch n.Name {
+		case "definecolor":
+			if len(groupArgs(n)) == 3 {
+				e.defineColor(n)
+			}
+		case "title":
+			if e.title == "" {
+				e.title = strings.TrimSpace(e.inline(firstGroup(n)))
+			}
+		case "author":
+			if e.author == "" {
+				e.author = strings.TrimSpace(e.inline(firstGroup(n)))
+			}
+		case "date":
+			if e.date == "" {
+				e.date = strings.TrimSpace(e.inline(firstGroup(n)))
+			}
+		}
+		return true
+	})
+	return e
+}
+
+// body renders what is inside \begin{document}, or the whole source when there is no document environment
+func (e *exporter) body() string {
+	root, _ := Parse(e.src)
+	var doc *Node
+	Walk(root, func(n *Node) bool {
+		if doc == nil && n.Kind == NodeEnvironment && n.Name == "document" {
+			doc = n
+		}
+		return doc == nil
+	})
+	if doc == nil {
+		return e.render(root.Children)
+	}
+	return e.render(doc.Children)
+}
+
+func (e *exporter) byline(sep string) string {
+	var parts []string
+	for _, part := range []string{e.author, e.date} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func (e *exporter) render(nodes []*Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(e.node(n))
+	}
+	return b.String()
+}
+
+// inline renders a node on its own, for titles and arguments
+func (e *exporter) inline(n *Node) string {
+	if n == nil {
+		return ""
+	}
+	e.nested++
+	defer func() { e.nested-- }()
+	return collapseSpace(e.render(n.Children))
+}
+
+func (e *exporter) node(n *Node) string {
+	switch n.Kind {
+	case NodeText:
+		return e.text(n.Text)
+	case NodeGroup:
+		return e.render(n.Children)
+	case NodeOptional:
+		// Brackets the parser took for an argument of a command we render anyway
+		return e.text("[") + e.render(n.Children) + e.text("]")
+	case NodeMath:
+		return e.math(n)
+	case NodeCommand:
+		return e.command(n)
+	case NodeEnvironment:
+		return e.environment(n)
+	case NodeVerbatim:
+		return e.code(n.Text)
+	}
+	return ""
+}
+
+// block surrounds a block element with blank lines at the top level, so it becomes its own paragraph
+func (e *exporter) block(s string) string {
+	if e.nested > 0 {
+		if e.markdown {
+			return " " + s + " "
+		}
+		return s
+	}
+	return "\n\n" + s + "\n\n"
+}
+
+var (
+	htmlText        = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	markdownSpecial = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "$", `\$`, "<", "&lt;")
+	typography      = strings.NewReplacer("---", "—", "--", "–", "``", "“", "''", "”", "`", "‘", "~", " ")
+)
+
+// text renders running text, turning LaTeX quotes, dashes and ties into their characters
+func (e *exporter) text(s string) string {
+	s = typography.Replace(s)
+	if e.inTable {
+		s = strings.ReplaceAll(s, "&", cellSep)
+	}
+	s = e.escape(s)
+	if e.nested > 0 {
+		return blankLine.ReplaceAllString(s, " ")
+	}
+	return blankLine.ReplaceAllString(s, "\n\n")
+}
+
+func (e *exporter) escape(s string) string {
+	if e.markdown {
+		return markdownSpecial.Replace(s)
+	}
+	return htmlText.Replace(s)
+}
+
+func (e *exporter) code(s string) string {
+	if e.markdown {
+		fence := "`"
+		if strings.Contains(s, "`") {
+			fence = "``"
+		}
+		return fence + s + fence
+	}
+	return "<code>" + html.EscapeString(s) + "</code>"
+}
+
+// math passes math through as TeX, \(...\) and \[...\] in HTML, $...$ and $$...$$ in Markdown
+func (e *exporter) math(n *Node) string {
+	raw := e.src[n.Pos.Offset:n.End.Offset]
+	closer := n.Delim
+	if n.Delim == `\(` || n.Delim == `\[` {
+		closer = matchingMathClose(n.Delim)
+	}
+	body := strings.TrimPrefix(raw, n.Delim)
+	if !n.Unclosed {
+		body = strings.TrimSuffix(body, closer)
+	}
+	if n.Display {
+		return e.displayMath(strings.TrimSpace(body))
+	}
+	if e.markdown {
+		return "$" + strings.TrimSpace(body) + "$"
+	}
+	return `\(` + html.EscapeString(body) + `\)`
+}
+
+// mathAlignments maps numbered display environments to the inner ones MathJax and KaTeX take inside \[...\]
+var mathAlignments = map[string]string{
+	"align": "aligned", "flalign": "aligned", "alignat": "aligned", "eqnarray": "aligned",
+	"gather": "gathered",
+}
+
+func (e *exporter) mathEnvironment(n *Node) string {
+	body := ""
+	if len(n.Children) > 0 {
+		body = e.src[n.Children[0].Pos.Offset:n.Children[len(n.Children)-1].End.Offset]
+	}
+	body = strings.TrimSpace(body)
+	if inner, ok := mathAlignments[strings.TrimSuffix(n.Name, "*")]; ok {
+		body = `\begin{` + inner + "}\n" + body + "\n" + `\end{` + inner + "}"
+	}
+	return e.displayMath(body)
+}
+
+func (e *exporter) displayMath(body string) string {
+	if e.markdown {
+		if e.nested > 0 {
+			return " $$" + collapseSpace(body) + "$$ "
+		}
+		return e.block("$$\n" + body + "\n$$")
+	}
+	return e.block(`<div class="math">\[` + html.EscapeString(body) + `\]</div>`)
+}
+
+var headings = map[string]int{"section": 2, "subsection": 3, "subsubsection": 4, "paragraph": 5}
+
+// ignored are layout and preamble commands, dropped together with their arguments
+var ignored = map[string]bool{
+	"documentclass": true, "usepackage": true, "RequirePackage": true, "definecolor": true, "colorlet": true,
+	"newcommand": true, "renewcommand": true, "providecommand": true, "DeclareMathOperator": true,
+	"geometry": true, "hypersetup": true, "setlist": true, "pagestyle": true, "thispagestyle": true,
+	"setlength": true, "addtolength": true, "setcounter": true, "tcbset": true, "tcbuselibrary": true,
+	"vspace": true, "vspace*": true, "hspace": true, "hspace*": true, "vfill": true,
+	"newpage": true, "clearpage": true, "pagebreak": true, "noindent": true, "indent": true,
+	"centering": true, "raggedright": true, "raggedleft": true, "color": true,
+	"tiny": true, "scriptsize": true, "footnotesize": true, "small": true, "normalsize": true,
+	"large": true, "Large": true, "LARGE": true, "huge": true, "Huge": true,
+	"bfseries": true, "itshape": true, "ttfamily": true, "sffamily": true, "rmfamily": true, "normalfont": true,
+	"bigskip": true, "medskip": true, "smallskip": true, "tableofcontents": true,
+	"label": true, "includegraphics": true, "hline": true, "toprule": true, "midrule": true,
+	"bottomrule": true, "cline": true, "title": true, "author": true, "date": true,
+}
+
+// symbols are commands that stand for a character
+var symbols = map[string]string{
+	"%": "%", "&": "&", "$": "$", "#": "#", "_": "_", "{": "{", "}": "}",
+	"ldots": "…", "dots": "…", "textbullet": "•", "checkmark": "✓", "textbackslash": `\`,
+	"LaTeX": "LaTeX", "TeX": "TeX", "copyright": "©", "textdegree": "°", "S": "§",
+	"quad": " ", "qquad": "  ", "enspace": " ", ",": " ", " ": " ", "hfill": " ",
+}
+
+func (e *exporter) command(n *Node) string {
+	if level, ok := headings[strings.TrimSuffix(n.Name, "*")]; ok {
+		return e.heading(level, e.inline(firstGroup(n)))
+	}
+	if ignored[n.Name] {
+		return ""
+	}
+	if s, ok := symbols[n.Name]; ok {
+		return e.escape(s)
+	}
+
+	switch n.Name {
+	case "maketitle":
+		// The title block is rendered as the page header
+		return ""
+	case `\`, "newline":
+		if e.inTable {
+			return rowSep
+		}
+		if e.markdown && e.nested > 0 {
+			return " "
+		}
+		return lineBreak
+	case "par":
+		if e.nested > 0 {
+			return " "
+		}
+		return "\n\n"
+	case "today":
+		return time.Now().Format("January 2, 2006")
+	case "textbf":
+		return e.wrap(firstGroup(n), "**", "<strong>", "</strong>")
+	case "textit", "emph", "textsl":
+		return e.wrap(firstGroup(n), "_", "<em>", "</em>")
+	case "underline", "uline":
+		return e.wrap(firstGroup(n), "", "<u>", "</u>")
+	case "texttt":
+		return e.wrap(firstGroup(n), "`", "<code>", "</code>")
+	case "textcolor":
+		args := groupArgs(n)
+		if len(args) < 2 {
+			return e.renderArgs(n)
+		}
+		text := e.inline(args[1])
+		if e.markdown {
+			return text
+		}
+		return fmt.Sprintf(`<span class="%s">%s</span>`, e.styleClass("color: "+e.color(TextOf(args[0]))), text)
+	case "colorbox":
+		args := groupArgs(n)
+		if len(args) < 2 {
+			return e.renderArgs(n)
+		}
+		text := e.inline(args[1])
+		if e.markdown {
+			return text
+		}
+		return fmt.Sprintf(`<span class="highlight %s">%s</span>`, e.styleClass("background: "+e.color(TextOf(args[0]))), text)
+	case "href":
+		args := groupArgs(n)
+		if len(args) < 2 {
+			return e.renderArgs(n)
+		}
+		return e.link(TextOf(args[0]), e.inline(args[1]))
+	case "url":
+		target := TextOf(firstGroup(n))
+		return e.link(target, e.escape(target))
+	case "footnote":
+		return " (" + e.inline(firstGroup(n)) + ")"
+	case "ref", "eqref", "pageref", "cite":
+		return ""
+	case "verb", "verb*":
+		if len(n.Args) > 0 {
+			return e.code(stripVerbDelims(n.Args[0].Text))
+		}
+		return ""
+	case "item":
+		// Only reached outside a list
+		return e.escape("• ") + e.renderArgs(n)
+	case "hrule", "rule":
+		if e.nested > 0 {
+			return ""
+		}
+		if e.markdown {
+			return e.block("---")
+		}
+		return e.block("<hr>")
+	case "caption":
+		return e.wrap(firstGroup(n), "_", `<p class="caption">`, "</p>")
+	}
+
+	// Anything else keeps the text of its arguments
+	return e.renderArgs(n)
+}
+
+// renderArgs renders the {...} arguments of a command one after the other, [...] dropped
+func (e *exporter) renderArgs(n *Node) string {
+	var b strings.Builder
+	for _, arg := range groupArgs(n) {
+		b.WriteString(e.render(arg.Children))
+	}
+	return b.String()
+}
+
+func (e *exporter) wrap(n *Node, md, open, close string) string {
+	text := strings.TrimSpace(e.inline(n))
+	if text == "" {
+		return ""
+	}
+	if e.markdown {
+		return md + text + md
+	}
+	return open + text + close
+}
+
+func (e *exporter) heading(level int, text string) string {
+	if e.markdown {
+		return e.block(strings.Repeat("#", level) + " " + text)
+	}
+	return e.block(fmt.Sprintf("<h%d>%s</h%d>", level, text, level))
+}
+
+// link only keeps web and mail links, a javascript: href from the model must not end up on the page
+func (e *exporter) link(target, text string) string {
+	target = strings.TrimSpace(target)
+	lower := strings.ToLower(target)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "mailto:") {
+		return text
+	}
+	if e.markdown {
+		return "[" + text + "](" + strings.ReplaceAll(target, ")", "%29") + ")"
+	}
+	return `<a href="` + html.EscapeString(target) + `">` + text + "</a>"
+}
+
+func (e *exporter) environment(n *Node) string {
+	if IsMathEnvironment(n.Name) {
+		return e.mathEnvironment(n)
+	}
+	switch n.Name {
+	case "document":
+		return e.render(n.Children)
+	case "itemize", "enumerate", "description":
+		return e.list(n)
+	case "tcolorbox", "mdframed", "framed", "shaded", "boxed":
+		return e.box(n)
+	case "tabular", "tabular*", "tabularx", "longtable":
+		return e.table(n)
+	case "verbatim", "lstlisting", "Verbatim", "minted":
+		return e.pre(n)
+	case "quote", "quotation":
+		return e.quote("", n)
+	case "center", "flushleft", "flushright":
+		if e.markdown || e.nested > 0 {
+			return e.render(n.Children)
+		}
+		align := map[string]string{"center": "center", "flushleft": "left", "flushright": "right"}[n.Name]
+		return e.block(fmt.Sprintf(`<div class="align-%s">%s</div>`, align, e.paragraphs(e.render(n.Children))))
+	}
+	// minipage, multicols, table, figure and anything unknown keep their body
+	return e.render(n.Children)
+}
+
+// listItem is one \item and the nodes up to the next one
+type listItem struct {
+	label *Node
+	nodes []*Node
+}
+
+func splitItems(n *Node) []listItem {
+	var items []listItem
+	for _, child := range n.Children {
+		if child.Kind == NodeCommand && child.Name == "item" {
+			item := listItem{}
+			for _, arg := range child.Args {
+				if arg.Kind == NodeOptional && item.label == nil {
+					item.label = arg
+				} else if arg.Kind == NodeGroup {
+					// \item{text}, the group is part of the item
+					item.nodes = append(item.nodes, arg)
+				}
+			}
+			items = append(items, item)
+			continue
+		}
+		if len(items) == 0 {
+			// Text before the first \item goes nowhere in LaTeX either
+			continue
+		}
+		items[len(items)-1].nodes = append(items[len(items)-1].nodes, child)
+	}
+	return items
+}
+
+// listStyles maps enumitem labels to HTML list types
+var listStyles = []struct{ label, kind string }{
+	{`\alph`, "a"}, {`\Alph`, "A"}, {`\roman`, "i"}, {`\Roman`, "I"},
+}
+
+func (e *exporter) list(n *Node) string {
+	items := splitItems(n)
+	ordered := n.Name == "enumerate"
+	description := n.Name == "description"
+	top := e.nested == 0
+
+	e.nested++
+	e.listDepth++
+	defer func() {
+		e.nested--
+		e.listDepth--
+	}()
+
+	if e.markdown {
+		indent := strings.Repeat("   ", e.listDepth-1)
+		var lines []string
+		for i, item := range items {
+			marker := "- "
+			if ordered {
+				marker = strconv.Itoa(i+1) + ". "
+			}
+			content := strings.TrimSpace(e.render(item.nodes))
+			if item.label != nil {
+				label := strings.TrimSpace(collapseSpace(e.render(item.label.Children)))
+				if description {
+					content = "**" + label + "** " + content
+				} else if label != "" {
+					marker = "- " + label + " "
+				}
+			}
+			// Nested lists come back as their own, already indented lines
+			parts := strings.Split(content, "\n")
+			lines = append(lines, indent+marker+collapseSpace(parts[0]))
+			for _, part := range parts[1:] {
+				if strings.TrimSpace(part) == "" {
+					continue
+				}
+				if !strings.HasPrefix(part, " ") && !listLine.MatchString(part) {
+					part = indent + "   " + collapseSpace(part)
+				}
+				lines = append(lines, part)
+			}
+		}
+		out := strings.Join(lines, "\n")
+		if top {
+			return "\n\n" + out + "\n\n"
+		}
+		return "\n" + out + "\n"
+	}
+
+	var b strings.Builder
+	switch {
+	case description:
+		b.WriteString("<dl>\n")
+		for _, item := range items {
+			if item.label != nil {
+				b.WriteString("<dt>" + collapseSpace(e.render(item.label.Children)) + "</dt>\n")
+			}
+			b.WriteString("<dd>" + strings.TrimSpace(e.render(item.nodes)) + "</dd>\n")
+		}
+		b.WriteString("</dl>")
+	default:
+		tag := "ul"
+		attrs := ""
+		if ordered {
+			tag = "ol"
+			if len(n.Args) > 0 {
+				spec := e.src[n.Args[0].Pos.Offset:n.Args[0].End.Offset]
+				for _, style := range listStyles {
+					if strings.Contains(spec, style.label) {
+						attrs = ` type="` + html.EscapeString(style.kind) + `"`
+						break
+					}
+				}
+			}
+		}
+		b.WriteString("<" + tag + attrs + ">\n")
+		for _, item := range items {
+			content := strings.TrimSpace(e.render(item.nodes))
+			if item.label != nil && !ordered {
+				content = "<strong>" + collapseSpace(e.render(item.label.Children)) + "</strong> " + content
+			}
+			b.WriteString("<li>" + content + "</li>\n")
+		}
+		b.WriteString("</" + tag + ">")
+	}
+	if top {
+		return "\n\n" + b.String() + "\n\n"
+	}
+	return b.String()
+}
+
+var listLine = regexp.MustCompile(`^\s*(-|\d+\.) `)
+
+// box renders a tcolorbox as a framed block, with the title from title= in its options
+func (e *exporter) box(n *Node) string {
+	options := map[string]string{}
+	for _, arg := range n.Args {
+		if arg.Kind == NodeOptional {
+			options = keyValues(e.src[arg.Pos.Offset+1 : arg.End.Offset-1])
+		}
+	}
+	title := ""
+	if raw, ok := options["title"]; ok {
+		root, _ := Parse(raw)
+		title = e.inline(root)
+	}
+
+	if e.markdown {
+		// The title is bold already
+		return e.quote(strings.ReplaceAll(title, "**", ""), n)
+	}
+
+	e.nested++
+	content := strings.TrimSpace(e.render(n.Children))
+	e.nested--
+
+	classes := "box"
+	titleClasses := "box-title"
+	if frame, ok := options["colframe"]; ok {
+		classes += " " + e.styleClass("border-color: "+e.color(frame))
+		titleClasses += " " + e.styleClass("background: "+e.color(frame))
+	}
+	if back, ok := options["colback"]; ok {
+		classes += " " + e.styleClass("background: "+e.color(back))
+	}
+	var b strings.Builder
+	b.WriteString(`<div class="` + classes + `">`)
+	if title != "" {
+		b.WriteString(`<div class="` + titleClasses + `">` + title + "</div>")
+	}
+	b.WriteString(`<div class="box-body">` + content + "</div></div>")
+	return e.block(b.String())
+}
+
+// quote renders a box or quote as a Markdown blockquote, or an HTML blockquote
+func (e *exporter) quote(title string, n *Node) string {
+	e.nested++
+	content := strings.TrimSpace(collapseBlankLines(e.render(n.Children)))
+	e.nested--
+
+	if !e.markdown {
+		if title != "" {
+			content = "<strong>" + title + "</strong><br>" + content
+		}
+		return e.block("<blockquote>" + content + "</blockquote>")
+	}
+	if e.nested > 0 {
+		if title != "" {
+			return " **" + title + "** " + collapseSpace(content) + " "
+		}
+		return " " + collapseSpace(content) + " "
+	}
+	var lines []string
+	if title != "" {
+		lines = append(lines, "> **"+title+"**", ">")
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, ">")
+		} else {
+			lines = append(lines, "> "+line)
+		}
+	}
+	return e.block(strings.Join(lines, "\n"))
+}
+
+// table splits a tabular body on & and \\ into rows of cells
+func (e *exporter) table(n *Node) string {
+	e.nested++
+	inTable := e.inTable
+	e.inTable = true
+	body := e.render(n.Children)
+	e.inTable = inTable
+	e.nested--
+
+	var rows [][]string
+	for _, row := range strings.Split(body, rowSep) {
+		if strings.TrimSpace(row) == "" {
+			continue
+		}
+		var cells []string
+		for _, cell := range strings.Split(row, cellSep) {
+			cells = append(cells, strings.TrimSpace(collapseSpace(cell)))
+		}
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if e.markdown {
+		// Markdown tables need a header row, the first row takes that place
+		for i, cells := range rows {
+			b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			if i == 0 {
+				b.WriteString("|" + strings.Repeat(" --- |", len(cells)) + "\n")
+			}
+		}
+		return e.block(strings.TrimSuffix(b.String(), "\n"))
+	}
+	b.WriteString(`<div class="table"><table>` + "\n")
+	for _, cells := range rows {
+		b.WriteString("<tr>")
+		for _, cell := range cells {
+			b.WriteString("<td>" + cell + "</td>")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table></div>")
+	return e.block(b.String())
+}
+
+// pre renders a verbatim environment as a code block
+func (e *exporter) pre(n *Node) string {
+	var b strings.Builder
+	for _, child := range n.Children {
+		b.WriteString(e.src[child.Pos.Offset:child.End.Offset])
+	}
+	code := strings.Trim(b.String(), "\n")
+	if e.markdown {
+		return e.block("```\n" + code + "\n```")
+	}
+	return e.block("<pre><code>" + html.EscapeString(code) + "</code></pre>")
+}
+
+var hexColor = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+// defineColor records \definecolor{name}{model}{spec} as a CSS color
+// The spec ends up in the page's CSS, so only hex digits and numbers are taken, anything else is dropped
+func (e *exporter) defineColor(n *Node) {
+	args := groupArgs(n)
+	name := strings.TrimSpace(TextOf(args[0]))
+	model := strings.TrimSpace(TextOf(args[1]))
+	spec := strings.TrimSpace(TextOf(args[2]))
+	switch model {
+	case "HTML":
+		if hexColor.MatchString(spec) {
+			e.colors[name] = "#" + strings.ToLower(spec)
+		}
+	case "RGB":
+		if c, ok := colorComponents(spec, 3, 255); ok {
+			e.colors[name] = fmt.Sprintf("rgb(%d, %d, %d)", int(c[0]), int(c[1]), int(c[2]))
+		}
+	case "rgb":
+		if c, ok := colorComponents(spec, 3, 1); ok {
+			e.colors[name] = fmt.Sprintf("rgb(%d, %d, %d)", int(c[0]*255+0.5), int(c[1]*255+0.5), int(c[2]*255+0.5))
+		}
+	case "gray":
+		if c, ok := colorComponents(spec, 1, 1); ok {
+			g := int(c[0]*255 + 0.5)
+			e.colors[name] = fmt.Sprintf("rgb(%d, %d, %d)", g, g, g)
+		}
+	}
+}
+
+// colorComponents parses the n comma separated numbers of a color spec, each from 0 to max
+func colorComponents(spec string, n int, max float64) ([]float64, bool) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != n {
+		return nil, false
+	}
+	values := make([]float64, n)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || f < 0 || f > max {
+			return nil, false
+		}
+		values[i] = f
+	}
+	return values, true
+}
+
+// styleClass returns the class that applies a CSS declaration, adding its rule to the page
+func (e *exporter) styleClass(declaration string) string {
+	if class, ok := e.styleClasses[declaration]; ok {
+		return class
+	}
+	class := fmt.Sprintf("style-%d", len(e.styles)+1)
+	e.styleClasses[declaration] = class
+	e.styles = append(e.styles, "."+class+" { "+declaration+"; }")
+	return class
+}
+
+var cssColorName = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// color turns an xcolor expression into CSS, blue!10 is 10% blue on white
+func (e *exporter) color(expr string) string {
+	expr = strings.TrimSpace(expr)
+	parts := strings.Split(expr, "!")
+	base := parts[0]
+	css, ok := e.colors[base]
+	if !ok {
+		if !cssColorName.MatchString(base) {
+			return "inherit"
+		}
+		css = base
+	}
+	if len(parts) >= 2 {
+		if percent, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && percent >= 0 && percent < 100 {
+			return fmt.Sprintf("color-mix(in srgb, %s %d%%, white)", css, percent)
+		}
+	}
+	return css
+}
+
+// paragraphs wraps the runs of text between block elements in <p>
+func (e *exporter) paragraphs(body string) string {
+	var out []string
+	for _, chunk := range strings.Split(body, "\n\n") {
+		chunk = strings.TrimSpace(chunk)
+		if chunk == "" {
+			continue
+		}
+		if isBlockHTML(chunk) {
+			out = append(out, chunk)
+		} else {
+			out = append(out, "<p>"+chunk+"</p>")
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func isBlockHTML(s string) bool {
+	for _, tag := range []string{"<h", "<ul", "<ol", "<dl", "<div", "<table", "<pre", "<hr", "<blockquote", "<p"} {
+		if strings.HasPrefix(s, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupArgs returns the {...} arguments of a node
+func groupArgs(n *Node) []*Node {
+	var groups []*Node
+	for _, arg := range n.Args {
+		if arg.Kind == NodeGroup {
+			groups = append(groups, arg)
+		}
+	}
+	return groups
+}
+
+func firstGroup(n *Node) *Node {
+	if groups := groupArgs(n); len(groups) > 0 {
+		return groups[0]
+	}
+	return nil
+}
+
+// keyValues splits "key=value, key={a, b}" at the commas outside braces
+func keyValues(s string) map[string]string {
+	values := map[string]string{}
+	depth, start := 0, 0
+	add := func(part string) {
+		key, value, _ := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+			value = value[1 : len(value)-1]
+		}
+		if key != "" {
+			values[key] = value
+		}
+	}
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				add(s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(s[start:])
+	return values
+}
+
+func stripVerbDelims(s string) string {
+	if len(s) >= 2 {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+var (
+	spaces      = regexp.MustCompile(`\s+`)
+	blankLines  = regexp.MustCompile(`\n{3,}[ \t]*|\n\n[ \t]+`)
+	tags        = regexp.MustCompile(`<[^>]*>`)
+	trailingSpc = regexp.MustCompile(`[ \t]+\n`)
+)
+
+func collapseSpace(s string) string {
+	return spaces.ReplaceAllString(s, " ")
+}
+
+func collapseBlankLines(s string) string {
+	// Keep the two trailing spaces of Markdown line breaks
+	s = trailingSpc.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasSuffix(m, "  \n") {
+			return "  \n"
+		}
+		return "\n"
+	})
+	return blankLines.ReplaceAllString(s, "\n\n")
+}
+
+func stripTags(s string) string {
+	return tags.ReplaceAllString(s, "")
+}
+
+// htmlPage is the page around an exported worksheet: title, color rules, header, body
+// Its <script> and <style> are the only inline ones, WithNonce marks them for the CSP it is served with
+const htmlPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%s</title>
+<script>
+window.MathJax = {tex: {inlineMath: [['\\(', '\\)']], displayMath: [['\\[', '\\]']]}};
+</script>
+<script defer src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml.js"></script>
+<style>
+body { margin: 0; font: 17px/1.6 system-ui, -apple-system, "Segoe UI", Roboto, sans-serif; color: #1f2328; background: #fff; }
+main { max-width: 48rem; margin: 0 auto; padding: 2rem 1.25rem 4rem; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 1.5rem; }
+h1 { font-size: 2rem; margin: 0 0 .25rem; }
+h2 { font-size: 1.5rem; margin-top: 2rem; }
+h3 { font-size: 1.2rem; }
+.byline { color: #57606a; margin-top: 0; }
+.box { border: 2px solid #8c959f; border-radius: 6px; margin: 1.25rem 0; overflow: hidden; }
+.box-title { background: #8c959f; color: #fff; font-weight: 600; padding: .4rem .9rem; }
+.box-body { padding: .75rem .9rem; }
+.math { overflow-x: auto; }
+.table { overflow-x: auto; }
+table { border-collapse: collapse; margin: 1rem 0; }
+td { border: 1px solid #d0d7de; padding: .35rem .7rem; }
+blockquote { border-left: 4px solid #d0d7de; margin: 1rem 0; padding: .25rem 1rem; color: #424a53; }
+pre { background: #f6f8fa; padding: .75rem; overflow-x: auto; }
+li { margin: .3rem 0; }
+.caption { text-align: center; color: #57606a; }
+.align-center { text-align: center; }
+.align-left { text-align: left; }
+.align-right { text-align: right; }
+@media (max-width: 600px) { body { font-size: 16px; } main { padding: 1.25rem .9rem 3rem; } h1 { font-size: 1.6rem; } }
+@media print { main { max-width: none; } }
+%s
+</style>
+</head>
+<body>
+<main>
+%s%s
+</main>
+</body>
+</html>
+`
@@ -0,0 +1,133 @@
+package latex
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportHTMLColors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"html", `\definecolor{brand}{HTML}{1A2B3C}\textcolor{brand}{x}`, "color: #1a2b3c;"},
+		{"RGB", `\definecolor{brand}{RGB}{10, 20, 30}\textcolor{brand}{x}`, "color: rgb(10, 20, 30);"},
+		{"rgb", `\definecolor{brand}{rgb}{0,0.5,1}\textcolor{brand}{x}`, "color: rgb(0, 128, 255);"},
+		{"gray", `\definecolor{brand}{gray}{0.5}\colorbox{brand}{x}`, "background: rgb(128, 128, 128);"},
+		{"mix", `\textcolor{blue!10}{x}`, "color: color-mix(in srgb, blue 10%, white);"},
+		{"box", "\\begin{tcolorbox}[colframe=red,colback=blue!5]x\\end{tcolorbox}", "border-color: red;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := ExportHTML(tt.src)
+			if !strings.Contains(page, tt.want) {
+				t.Errorf("ExportHTML(%q) has no %q:\n%s", tt.src, tt.want, page)
+			}
+			if strings.Contains(page, "style=") {
+				t.Errorf("ExportHTML(%q) has a style attribute:\n%s", tt.src, page)
+			}
+		})
+	}
+}
+
+func TestExportHTMLRejectsColorInjection(t *testing.T) {
+	tests := []string{
+		`\definecolor{x}{HTML}{000000"><script>alert(1)</script>}\textcolor{x}{hi}`,
+		`\definecolor{x}{HTML}{red;background:url(//evil)}\colorbox{x}{hi}`,
+		`\definecolor{x}{RGB}{1,2,3);}</style><script>alert(1)</script><style>{}\textcolor{x}{hi}`,
+		`\definecolor{x}{rgb}{0,0,1" onmouseover="alert(1)}\textcolor{x}{hi}`,
+		`\definecolor{x}{gray}{0.5</style>}\textcolor{x}{hi}`,
+		`\definecolor{x}{HTML}{FF00001}\textcolor{x}{hi}`,
+		`\textcolor{red" onmouseover="alert(1)}{hi}`,
+		"\\begin{tcolorbox}[colframe={red\" onclick=\"alert(1)}]hi\\end{tcolorbox}",
+	}
+
+	for _, src := range tests {
+		page := ExportHTML(src)
+		body := page[strings.Index(page, "<body>"):]
+		for _, tag := range htmlTag.FindAllString(body, -1) {
+			if !safeTag.MatchString(tag) {
+				t.Errorf("ExportHTML(%q) has the tag %s", src, tag)
+			}
+		}
+		for _, rule := range styleRule.FindAllString(page, -1) {
+			if !safeRule.MatchString(rule) {
+				t.Errorf("ExportHTML(%q) has the rule %s", src, rule)
+			}
+		}
+		if strings.Count(page, "<script") != 2 || strings.Count(page, "<style") != 1 || strings.Count(page, "</style") != 1 {
+			t.Errorf("ExportHTML(%q) added script or style tags:\n%s", src, page)
+		}
+	}
+}
+
+var (
+	htmlTag   = regexp.MustCompile(`<[^>]*>`)
+	safeTag   = regexp.MustCompile(`^</?[a-z0-9]+( class="[a-z0-9 -]+")?>$`)
+	styleRule = regexp.MustCompile(`\.style-[^\n]*`)
+	safeRule  = regexp.MustCompile(`^\.style-\d+ \{ [a-z-]+: [#a-z0-9(), %]+; \}$`)
+)
+
+func TestWithNonce(t *testing.T) {
+	page := string(WithNonce([]byte(ExportHTML(`\textcolor{red}{a <script>b</script>}`)), "abc"))
+	if !strings.Contains(page, `<script nonce="abc">`) || !strings.Contains(page, `<style nonce="abc">`) {
+		t.Errorf("page has no nonces:\n%s", page)
+	}
+	if strings.Count(page, `nonce="abc"`) != 2 {
+		t.Errorf("nonce added to more than the page's own tags:\n%s", page)
+	}
+	if policy := ExportPolicy("abc"); strings.Contains(policy, "unsafe-inline") || !strings.Contains(policy, "'nonce-abc'") {
+		t.Errorf("ExportPolicy = %q", policy)
+	}
+}
+
+// chdirTemp runs the test in a fresh directory, for code that writes to ./generated and ./storage
+func chdirTemp(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestExportFromKeptSource(t *testing.T) {
+	chdirTemp(t)
+	pdf := filepath.Join("storage", "bucket", "job.pdf")
+	if err := os.MkdirAll(filepath.Dir(pdf), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A source an older version left in the bucket
+	writeFiles(t, filepath.Dir(pdf), time.Now(), "job.pdf", "job.tex")
+
+	keepSource(pdf, "\\documentclass{article}\n\\begin{document}\n\\section{Kept}\n\\end{document}\n")
+	if _, err := os.Stat(SourcePath(pdf)); err != nil {
+		t.Fatalf("source not kept: %v", err)
+	}
+	if strings.HasPrefix(SourcePath(pdf), "storage") {
+		t.Errorf("source kept at %s, inside the public storage", SourcePath(pdf))
+	}
+	if _, err := os.Stat(filepath.Join("storage", "bucket", "job.tex")); !os.IsNotExist(err) {
+		t.Error("the source is still in the bucket")
+	}
+
+	html, err := ExportedPath(pdf, FormatHTML)
+	if err != nil {
+		t.Fatalf("ExportedPath: %v", err)
+	}
+	data, err := os.ReadFile(html)
+	if err != nil || !strings.Contains(string(data), "Kept") {
+		t.Errorf("export %s is %q (%v), want the kept section", html, data, err)
+	}
+	if filepath.Dir(html) != filepath.Dir(pdf) {
+		t.Errorf("export written to %s, want it beside the PDF", html)
+	}
+}
@@ -0,0 +1,446 @@
+package latex
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// LintFix is one automatic fix applied to a document
+// Line and Column point at the problem in the source the fix was made on
+type LintFix struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+// LintResult is a document after every automatic fix was applied
+type LintResult struct {
+	Content   string       `json:"-"`
+	Fixes     []LintFix    `json:"fixes"`
+	Remaining []Diagnostic `json:"remaining"`
+}
+
+// LintRule finds one kind of problem and proposes edits that fix it
+// Rules must be deterministic, the same source always gives the same edits
+type LintRule struct {
+	Name        string
+	Description string
+	Fix         func(src string, parsed *ParseResult) []lintEdit
+}
+
+// lintEdit replaces src[start:end] with text, start == end is an insertion
+type lintEdit struct {
+	start int
+	end   int
+	text  string
+	fix   LintFix
+}
+
+// Fixes can uncover more problems (a closed brace reveals a stray \end), so the
+// linter re-parses and runs again until nothing changes or it gives up
+const maxLintPasses = 5
+
+// DefaultPackageAllowlist is what the sheet prompt asks for plus common packages that
+// ship with every TeX distribution. LATEX_PACKAGE_ALLOWLIST in set.json replaces it
+var DefaultPackageAllowlist = []string{
+	"geometry", "amsmath", "amssymb", "amsthm", "graphicx", "enumitem", "xcolor", "tcolorbox", "hyperref",
+	"inputenc", "fontenc", "lmodern", "mathtools", "array", "booktabs", "tabularx", "multicol",
+	"fancyhdr", "titlesec", "parskip", "setspace", "caption", "float", "url",
+}
+
+// alignmentEnvironments are where & is a column separator
+var alignmentEnvironments = map[string]bool{
+	"tabular": true, "tabular*": true, "tabularx": true, "tabulary": true, "longtable": true, "array": true,
+	"align": true, "align*": true, "alignat": true, "alignat*": true, "flalign": true, "flalign*": true,
+	"eqnarray": true, "eqnarray*": true, "split": true, "aligned": true, "alignedat": true,
+	"matrix": true, "pmatrix": true, "bmatrix": true, "Bmatrix": true, "vmatrix": true, "Vmatrix": true,
+	"smallmatrix": true, "cases": true, "dcases": true, "rcases": true,
+}
+
+// definitionCommands hold code that only runs where it is used, their arguments are not linted
+var definitionCommands = map[string]bool{
+	"newcommand": true, "renewcommand": true, "providecommand": true,
+	"newenvironment": true, "renewenvironment": true, "def": true,
+	"url": true, "href": true,
+}
+
+var strayEndPattern = regexp.MustCompile(`^\\end\s*\{[^}]*\}`)
+
+// LintRules are applied in order, an edit overlapping an earlier rule's edit waits for the next pass
+var LintRules = []LintRule{
+	{Name: "unmatched-brace", Description: "Removes } that close nothing", Fix: fixUnmatchedBraces},
+	{Name: "unclosed-brace", Description: "Closes { and [ that are never closed", Fix: fixUnclosedGroups},
+	{Name: "environment-mismatch", Description: "Renames or removes stray \\end and closes unclosed environments", Fix: fixEnvironments},
+	{Name: "math-delimiters", Description: "Closes unclosed math and removes stray \\) and \\]", Fix: fixMath},
+	{Name: "stray-ampersand", Description: "Escapes & outside tables and alignments", Fix: fixAmpersands},
+	{Name: "stray-percent", Description: "Escapes % written right after a number (50%)", Fix: fixPercents},
+	{Name: "package-placement", Description: "Moves \\usepackage out of the document body", Fix: fixPackagePlacement},
+	{Name: "package-allowlist", Description: "Drops duplicate packages and packages outside the allowlist", Fix: fixPackages},
+}
+
+// Lint applies every rule's fixes until the document stops changing
+func Lint(content string) *LintResult {
+	result := &LintResult{Content: content, Fixes: []LintFix{}, Remaining: []Diagnostic{}}
+	if strings.TrimSpace(content) == "" {
+		return result
+	}
+
+	for pass := 0; pass < maxLintPasses; pass++ {
+		parsed, err := ParseLaTeX(result.Content)
+		if err != nil {
+			return result
+		}
+		result.Remaining = parsed.Diagnostics
+
+		var edits []lintEdit
+		for _, rule := range LintRules {
+			for _, e := range rule.Fix(result.Content, parsed) {
+				e.fix.Rule = rule.Name
+				edits = append(edits, e)
+			}
+		}
+		if len(edits) == 0 {
+			return result
+		}
+
+		applied := applyEdits(&result.Content, edits)
+		result.Fixes = append(result.Fixes, applied...)
+	}
+
+	if parsed, err := ParseLaTeX(result.Content); err == nil {
+		result.Remaining = parsed.Diagnostics
+	}
+	return result
+}
+
+// applyEdits applies the edits that don't overlap an earlier one and returns their fixes
+func applyEdits(src *string, edits []lintEdit) []LintFix {
+	var kept []lintEdit
+	for _, e := range edits {
+		overlaps := false
+		for _, k := range kept {
+			if e.start < k.end && k.start < e.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, e)
+		}
+	}
+
+	// Apply back to front so offsets stay valid, a deletion before an insertion at the same place
+	ordered := append([]lintEdit(nil), kept...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].start != ordered[j].start {
+			return ordered[i].start > ordered[j].start
+		}
+		return ordered[i].end > ordered[j].end
+	})
+	out := *src
+	for _, e := range ordered {
+		out = out[:e.start] + e.text + out[e.end:]
+	}
+	*src = out
+
+	fixes := make([]LintFix, len(kept))
+	for i, e := range kept {
+		fixes[i] = e.fix
+	}
+	return fixes
+}
+
+func fixAt(pos Position, format string, args ...interface{}) LintFix {
+	return LintFix{Message: fmt.Sprintf(format, args...), Line: pos.Line, Column: pos.Column}
+}
+
+func fixUnmatchedBraces(src string, parsed *ParseResult) []lintEdit {
+	var edits []lintEdit
+	for _, d := range parsed.Diagnostics {
+		if d.Code == "unmatched-brace" {
+			pos := Position{Line: d.Line, Column: d.Column}
+			edits = append(edits, lintEdit{start: d.Offset, end: d.Offset + 1, fix: fixAt(pos, "removed unmatched }")})
+		}
+	}
+	return edits
+}
+
+func fixUnclosedGroups(src string, parsed *ParseResult) []lintEdit {
+	var edits []lintEdit
+	Walk(parsed.AST, func(n *Node) bool {
+		if !n.Unclosed {
+			return true
+		}
+		switch n.Kind {
+		case NodeGroup:
+			edits = append(edits, lintEdit{start: n.End.Offset, end: n.End.Offset, text: "}", fix: fixAt(n.Pos, "closed { opened here")})
+		case NodeOptional:
+			edits = append(edits, lintEdit{start: n.End.Offset, end: n.End.Offset, text: "]", fix: fixAt(n.Pos, "closed [ opened here")})
+		}
+		return true
+	})
+	return edits
+}
+
+func fixEnvironments(src string, parsed *ParseResult) []lintEdit {
+	var unclosed []*Node
+	Walk(parsed.AST, func(n *Node) bool {
+		if n.Kind == NodeEnvironment && n.Unclosed {
+			unclosed = append(unclosed, n)
+		}
+		return true
+	})
+
+	var edits []lintEdit
+	renamed := make(map[*Node]bool)
+	for _, d := range parsed.Diagnostics {
+		if d.Code != "stray-end" {
+			continue
+		}
+		match := strayEndPattern.FindString(src[d.Offset:])
+		if match == "" {
+			continue
+		}
+		pos := Position{Line: d.Line, Column: d.Column}
+
+		// \begin{itemize} ... \end{enumerate} is a typo, rename the \end rather than adding one
+		var owner *Node
+		for _, env := range unclosed {
+			if env.Pos.Offset < d.Offset && d.Offset < env.End.Offset && !renamed[env] {
+				owner = env // Walk is depth first, so the last match is the innermost
+			}
+		}
+		if owner != nil {
+			renamed[owner] = true
+			edits = append(edits, lintEdit{start: d.Offset, end: d.Offset + len(match), text: "\\end{" + owner.Name + "}", fix: fixAt(pos, "changed %s to \\end{%s}", match, owner.Name)})
+			continue
+		}
+		edits = append(edits, lintEdit{start: d.Offset, end: d.Offset + len(match), fix: fixAt(pos, "removed %s without a matching \\begin", match)})
+	}
+
+	for _, env := range unclosed {
+		if renamed[env] {
+			continue
+		}
+		text := "\\end{" + env.Name + "}\n"
+		if at := env.End.Offset; at > 0 && src[at-1] != '\n' {
+			text = "\n" + text
+		}
+		edits = append(edits, lintEdit{start: env.End.Offset, end: env.End.Offset, text: text, fix: fixAt(env.Pos, "closed \\begin{%s}", env.Name)})
+	}
+	return edits
+}
+
+func fixMath(src string, parsed *ParseResult) []lintEdit {
+	var edits []lintEdit
+	for _, d := range parsed.Diagnostics {
+		if d.Code == "stray-math-close" {
+			pos := Position{Line: d.Line, Column: d.Column}
+			edits = append(edits, lintEdit{start: d.Offset, end: d.Offset + 2, fix: fixAt(pos, "removed %s without an opening delimiter", src[d.Offset:d.Offset+2])})
+		}
+	}
+
+	Walk(parsed.AST, func(n *Node) bool {
+		if n.Kind != NodeMath || !n.Unclosed {
+			return true
+		}
+		closer := n.Delim
+		if closer == "\\(" || closer == "\\[" {
+			closer = matchingMathClose(closer)
+		}
+
+		// Inline math can't span paragraphs, close it before the first blank line
+		at := n.End.Offset
+		body := src[n.Pos.Offset+len(n.Delim) : n.End.Offset]
+		if !n.Display {
+			if loc := blankLine.FindStringIndex(body); loc != nil {
+				at = n.Pos.Offset + len(n.Delim) + loc[0]
+			}
+		}
+		for at > n.Pos.Offset+len(n.Delim) && strings.ContainsRune(" \t\n", rune(src[at-1])) {
+			at--
+		}
+		edits = append(edits, lintEdit{start: at, end: at, text: closer, fix: fixAt(n.Pos, "closed math opened with %s", n.Delim)})
+		return false
+	})
+	return edits
+}
+
+func fixAmpersands(src string, parsed *ParseResult) []lintEdit {
+	var edits []lintEdit
+	var visit func(n *Node, aligned bool)
+	visit = func(n *Node, aligned bool) {
+		switch n.Kind {
+		case NodeText:
+			if aligned {
+				return
+			}
+			for i := 0; i < len(n.Text); i++ {
+				if n.Text[i] != '&' {
+					continue
+				}
+				offset := n.Pos.Offset + i
+				pos := positionAt(src, offset)
+				edits = append(edits, lintEdit{start: offset, end: offset + 1, text: "\\&", fix: fixAt(pos, "escaped & outside a table")})
+			}
+			return
+		case NodeCommand:
+			if definitionCommands[n.Name] {
+				return
+			}
+		case NodeEnvironment:
+			if alignmentEnvironments[n.Name] {
+				aligned = true
+			}
+		case NodeComment, NodeVerbatim:
+			return
+		}
+		for _, arg := range n.Args {
+			visit(arg, aligned)
+		}
+		for _, child := range n.Children {
+			visit(child, aligned)
+		}
+	}
+	visit(parsed.AST, false)
+	return edits
+}
+
+func fixPercents(src string, parsed *ParseResult) []lintEdit {
+	var edits []lintEdit
+	Walk(parsed.AST, func(n *Node) bool {
+		if n.Kind == NodeCommand && definitionCommands[n.Name] {
+			return false
+		}
+		if n.Kind != NodeComment || n.Pos.Offset == 0 {
+			return true
+		}
+		if prev := src[n.Pos.Offset-1]; prev >= '0' && prev <= '9' {
+			edits = append(edits, lintEdit{start: n.Pos.Offset, end: n.Pos.Offset + 1, text: "\\%", fix: fixAt(n.Pos, "escaped %% after a number, it was hiding the rest of the line")})
+		}
+		return true
+	})
+	return edits
+}
+
+// packageCommands returns every \usepackage and whether it sits inside the document body
+func packageCommands(root *Node) (commands []*Node, inBody []bool, document *Node) {
+	var visit func(n *Node, body bool)
+	visit = func(n *Node, body bool) {
+		if n.Kind == NodeEnvironment && n.Name == "document" && document == nil {
+			document = n
+			body = true
+		}
+		if n.Kind == NodeCommand && n.Name == "usepackage" {
+			commands = append(commands, n)
+			inBody = append(inBody, body)
+			return
+		}
+		for _, child := range n.Children {
+			visit(child, body)
+		}
+	}
+	visit(root, false)
+	return commands, inBody, document
+}
+
+func fixPackagePlacement(src string, parsed *ParseResult) []lintEdit {
+	commands, inBody, document := packageCommands(parsed.AST)
+	if document == nil {
+		return nil
+	}
+	var edits []lintEdit
+	for i, cmd := range commands {
+		if !inBody[i] {
+			continue
+		}
+		text := src[cmd.Pos.Offset:cmd.End.Offset]
+		end := cmd.End.Offset
+		if end < len(src) && src[end] == '\n' {
+			end++
+		}
+		edits = append(edits,
+			lintEdit{start: cmd.Pos.Offset, end: end, fix: fixAt(cmd.Pos, "moved %s to the preamble", text)},
+			lintEdit{start: document.Pos.Offset, end: document.Pos.Offset, text: text + "\n", fix: fixAt(document.Pos, "inserted %s before \\begin{document}", text)},
+		)
+	}
+	return edits
+}
+
+func fixPackages(src string, parsed *ParseResult) []lintEdit {
+	allowed := make(map[string]bool)
+	for _, pkg := range packageAllowlist() {
+		allowed[pkg] = true
+	}
+
+	commands, _, _ := packageCommands(parsed.AST)
+	seen := make(map[string]bool)
+	var edits []lintEdit
+	for _, cmd := range commands {
+		var keep, dropped []string
+		for _, pkg := range strings.Split(argText(cmd, 0), ",") {
+			pkg = strings.TrimSpace(pkg)
+			if pkg == "" {
+				continue
+			}
+			if seen[pkg] || !allowed[pkg] {
+				dropped = append(dropped, pkg)
+				continue
+			}
+			seen[pkg] = true
+			keep = append(keep, pkg)
+		}
+		if len(dropped) == 0 {
+			continue
+		}
+
+		fix := fixAt(cmd.Pos, "removed package %s (duplicate or not allowed)", strings.Join(dropped, ", "))
+		if len(keep) == 0 {
+			end := cmd.End.Offset
+			if end < len(src) && src[end] == '\n' {
+				end++
+			}
+			edits = append(edits, lintEdit{start: cmd.Pos.Offset, end: end, fix: fix})
+			continue
+		}
+
+		options := ""
+		for _, arg := range cmd.Args {
+			if arg.Kind == NodeOptional {
+				options = src[arg.Pos.Offset:arg.End.Offset]
+				break
+			}
+		}
+		text := "\\usepackage" + options + "{" + strings.Join(keep, ",") + "}"
+		edits = append(edits, lintEdit{start: cmd.Pos.Offset, end: cmd.End.Offset, text: text, fix: fix})
+	}
+	return edits
+}
+
+// packageAllowlist returns LATEX_PACKAGE_ALLOWLIST or the default
+func packageAllowlist() []string {
+	var list []string
+	if config.DecodeConfigValue("LATEX_PACKAGE_ALLOWLIST", &list) && len(list) > 0 {
+		return list
+	}
+	return DefaultPackageAllowlist
+}
+
+// positionAt works out the line and column of a byte offset
+func positionAt(src string, offset int) Position {
+	pos := Position{Offset: offset, Line: 1, Column: 1}
+	for _, r := range src[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
@@ -0,0 +1,75 @@
+package latex
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fixesMarker separates the linted document from its fixes in a golden file
+const fixesMarker = "-- fixes --\n"
+
+// TestLintGolden lints testdata/lint/<case>.tex and compares the document and its fixes with
+// <case>.golden. The linted document has to be clean, linting it again must not change a byte
+func TestLintGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "lint", "*.tex"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("no lint cases: %v", err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".tex")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := Lint(string(src))
+
+			var got strings.Builder
+			got.WriteString(result.Content)
+			got.WriteString(fixesMarker)
+			for _, fix := range result.Fixes {
+				fmt.Fprintf(&got, "%s %d:%d %s\n", fix.Rule, fix.Line, fix.Column, fix.Message)
+			}
+
+			golden := strings.TrimSuffix(input, ".tex") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got.String()), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("Lint(%s) =\n%s\nwant\n%s", input, got.String(), want)
+			}
+
+			again := Lint(result.Content)
+			if again.Content != result.Content || len(again.Fixes) != 0 {
+				t.Errorf("linting the fixed document again changed it, fixes %+v:\n%s", again.Fixes, again.Content)
+			}
+		})
+	}
+}
+
+func TestLintLeavesCleanDocument(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "lint", "clean.tex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Lint(string(src))
+	if result.Content != string(src) {
+		t.Errorf("Lint changed a clean document:\n%s", result.Content)
+	}
+	if len(result.Fixes) != 0 || len(result.Remaining) != 0 {
+		t.Errorf("Lint reported fixes %+v and diagnostics %+v for a clean document", result.Fixes, result.Remaining)
+	}
+}
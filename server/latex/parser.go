@@ -7,129 +7,220 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 // ParseResult contains information about parsed LaTeX content
+// Errors and Warnings are the Diagnostics formatted as "line:column: severity: message"
 type ParseResult struct {
 	IsValid      bool              `json:"isValid"`
 	HasErrors    bool              `json:"hasErrors"`
 	Warnings     []string          `json:"warnings"`
 	Errors       []string          `json:"errors"`
+	Diagnostics  []Diagnostic      `json:"diagnostics"`
 	Stats        map[string]int    `json:"stats"`
 	Structure    map[string]string `json:"structure"`
 	Dependencies []string          `json:"dependencies"`
+	AST          *Node             `json:"-"`
+}
+
+// listEnvironments are the environments \item belongs in
+var listEnvironments = map[string]bool{
+	"itemize": true, "enumerate": true, "description": true,
+	"list": true, "trivlist": true, "questions": true, "parts": true, "choices": true, "checkboxes": true,
 }
 
 // ParseLaTeX analyzes LaTeX content for validity and structure
+// The source is parsed into an AST, so comments and verbatim blocks are not counted
+// and every problem comes with the line and column it starts at
 func ParseLaTeX(content string) (*ParseResult, error) {
-	fmt.Println(content) // debug content
-
 	if content == "" {
 		return nil, fmt.Errorf("empty LaTeX content")
 	}
 
+	root, diagnostics := Parse(content)
 	result := &ParseResult{
 		IsValid:      true,
 		HasErrors:    false,
 		Warnings:     []string{},
 		Errors:       []string{},
+		Diagnostics:  diagnostics,
 		Stats:        make(map[string]int),
 		Structure:    make(map[string]string),
 		Dependencies: []string{},
+		AST:          root,
 	}
 
-	// Check for document class
-	documentClassRegex := regexp.MustCompile(`\\documentclass(\[.*?\])?\{(.*?)\}`)
-	documentClassMatch := documentClassRegex.FindStringSubmatch(content)
-
-	if len(documentClassMatch) < 3 {
-		result.IsValid = false
-		result.HasErrors = true
-		result.Errors = append(result.Errors, "Missing \\documentclass declaration")
-	} else {
-		result.Structure["documentClass"] = documentClassMatch[2]
+	warn := func(code string, pos Position, format string, args ...interface{}) {
+		result.Diagnostics = append(result.Diagnostics, newDiagnostic(SeverityWarning, code, pos, fmt.Sprintf(format, args...)))
 	}
-
-	// Check for document environment
-	if !strings.Contains(content, "\\begin{document}") || !strings.Contains(content, "\\end{document}") {
-		result.IsValid = false
-		result.HasErrors = true
-		result.Errors = append(result.Errors, "Missing document environment")
+	fail := func(code string, pos Position, format string, args ...interface{}) {
+		result.Diagnostics = append(result.Diagnostics, newDiagnostic(SeverityError, code, pos, fmt.Sprintf(format, args...)))
 	}
 
-	// Extract packages
-	packageRegex := regexp.MustCompile(`\\usepackage(\[.*?\])?\{(.*?)\}`)
-	packageMatches := packageRegex.FindAllStringSubmatch(content, -1)
+	var documentEnv *Node
+	var documentClass *Node
+	hasTitle := false
+	packages := make(map[string]Position)
+	mathCount := 0
 
-	packages := []string{}
-	for _, match := range packageMatches {
-		if len(match) >= 3 {
-			packages = append(packages, match[2])
+	// Walk the tree keeping the enclosing environments to check \item and preamble-only commands
+	var visit func(n *Node, envs []string)
+	visit = func(n *Node, envs []string) {
+		switch n.Kind {
+		case NodeCommand:
+			result.Stats["commandCount"]++
+			switch n.Name {
+			case "documentclass":
+				if documentClass != nil {
+					fail("duplicate-documentclass", n.Pos, "\\documentclass given twice, first on line %d", documentClass.Pos.Line)
+				} else {
+					documentClass = n
+					result.Structure["documentClass"] = argText(n, 0)
+				}
+			case "usepackage", "RequirePackage":
+				if documentEnv != nil {
+					fail("package-in-body", n.Pos, "\\%s{%s} can only be used in the preamble", n.Name, argText(n, 0))
+				}
+				for _, pkg := range strings.Split(argText(n, 0), ",") {
+					pkg = strings.TrimSpace(pkg)
+					if pkg == "" {
+						continue
+					}
+					if first, seen := packages[pkg]; seen {
+						warn("duplicate-package", n.Pos, "package %s is loaded twice, first on line %d", pkg, first.Line)
+						continue
+					}
+					packages[pkg] = n.Pos
+					result.Dependencies = append(result.Dependencies, pkg)
+				}
+			case "section":
+				result.Stats["sectionCount"]++
+			case "subsection":
+				result.Stats["subsectionCount"]++
+			case "title":
+				hasTitle = true
+				result.Structure["title"] = strings.TrimSpace(argText(n, 0))
+			case "item":
+				if !inList(envs) {
+					fail("item-outside-list", n.Pos, "\\item outside a list environment")
+				}
+			case "newcommand", "renewcommand", "providecommand", "newenvironment", "renewenvironment":
+				// Definitions are checked where they are used, not where they are written
+				envs = append(envs, "list")
+			}
+		case NodeEnvironment:
+			result.Stats["environmentCount"]++
+			if n.Name == "document" {
+				if documentEnv != nil {
+					fail("duplicate-document", n.Pos, "second document environment, the first starts on line %d", documentEnv.Pos.Line)
+				} else {
+					documentEnv = n
+				}
+			}
+			if IsMathEnvironment(n.Name) {
+				mathCount++
+			}
+			envs = append(envs, n.Name)
+		case NodeMath:
+			mathCount++
+		case NodeComment:
+			result.Stats["commentCount"]++
+			return
+		case NodeVerbatim:
+			result.Stats["verbatimCount"]++
+			return
 		}
-	}
-	result.Dependencies = packages
-	result.Stats["packageCount"] = len(packages)
-
-	// Count sections
-	sectionRegex := regexp.MustCompile(`\\section\{`)
-	sectionMatches := sectionRegex.FindAllStringIndex(content, -1)
-	result.Stats["sectionCount"] = len(sectionMatches)
-
-	// Count subsections
-	subsectionRegex := regexp.MustCompile(`\\subsection\{`)
-	subsectionMatches := subsectionRegex.FindAllStringIndex(content, -1)
-	result.Stats["subsectionCount"] = len(subsectionMatches)
-
-	// Count environments
-	environmentRegex := regexp.MustCompile(`\\begin\{(.*?)\}`)
-	environmentMatches := environmentRegex.FindAllStringSubmatch(content, -1)
-
-	environments := make(map[string]int)
-	for _, match := range environmentMatches {
-		if len(match) >= 2 {
-			env := match[1]
-			environments[env]++
+		for _, arg := range n.Args {
+			visit(arg, envs)
+		}
+		for _, child := range n.Children {
+			visit(child, envs)
 		}
 	}
+	visit(root, nil)
 
-	// Check for environment balance
-	for env, count := range environments {
-		beginCount := count
-		endCount := strings.Count(content, "\\end{"+env+"}")
+	result.Stats["packageCount"] = len(result.Dependencies)
+	result.Stats["mathCount"] = mathCount
 
-		if beginCount != endCount {
-			result.HasErrors = true
-			result.Errors = append(result.Errors,
-				fmt.Sprintf("Unbalanced environment: %s (begin: %d, end: %d)",
-					env, beginCount, endCount))
+	if documentClass == nil {
+		fail("missing-documentclass", Position{Line: 1, Column: 1}, "Missing \\documentclass declaration")
+	}
+	if documentEnv == nil {
+		fail("missing-document", root.End, "Missing document environment")
+	} else {
+		// Anything but whitespace and comments after \end{document} is ignored by TeX
+		after := false
+		for _, n := range root.Children {
+			if after && !(n.Kind == NodeComment || (n.Kind == NodeText && strings.TrimSpace(n.Text) == "")) {
+				warn("content-after-document", n.Pos, "content after \\end{document} is ignored")
+				break
+			}
+			if n == documentEnv {
+				after = true
+			}
 		}
 	}
+	if !hasTitle {
+		warn("no-title", Position{Line: 1, Column: 1}, "No title defined")
+	}
 
-	// Count math environments
-	mathEnvs := []string{"equation", "align", "math", "displaymath"}
-	mathCount := 0
-	for _, env := range mathEnvs {
-		if count, ok := environments[env]; ok {
-			mathCount += count
+	sort.SliceStable(result.Diagnostics, func(i, j int) bool {
+		a, b := result.Diagnostics[i], result.Diagnostics[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	for _, d := range result.Diagnostics {
+		if d.Severity == SeverityError {
+			result.Errors = append(result.Errors, d.String())
+		} else {
+			result.Warnings = append(result.Warnings, d.String())
 		}
 	}
-	// Also count inline math
-	inlineMathRegex := regexp.MustCompile(`\$[^\$]+\$`)
-	inlineMathMatches := inlineMathRegex.FindAllStringIndex(content, -1)
-	mathCount += len(inlineMathMatches)
+	if len(result.Errors) > 0 {
+		result.IsValid = false
+		result.HasErrors = true
+	}
 
-	result.Stats["mathCount"] = mathCount
+	return result, nil
+}
 
-	// Check for common warnings
-	if !strings.Contains(content, "\\title{") {
-		result.Warnings = append(result.Warnings, "No title defined")
+// FormatDiagnostics lists diagnostics one per line, errors first, for logs and AI prompts
+func FormatDiagnostics(diagnostics []Diagnostic) string {
+	var lines []string
+	for _, severity := range []string{SeverityError, SeverityWarning} {
+		for _, d := range diagnostics {
+			if d.Severity == severity {
+				lines = append(lines, "line "+d.String())
+			}
+		}
 	}
+	return strings.Join(lines, "\n")
+}
 
-	RemoveCodeBlockMarkers(content) // Clean content from code block markers
+// argText returns the plain text of the i-th {...} argument of a node
+func argText(n *Node, i int) string {
+	for _, arg := range n.Args {
+		if arg.Kind != NodeGroup {
+			continue
+		}
+		if i == 0 {
+			return TextOf(arg)
+		}
+		i--
+	}
+	return ""
+}
 
-	return result, nil
+// inList reports whether any enclosing environment is a list
+func inList(envs []string) bool {
+	for _, env := range envs {
+		if listEnvironments[env] {
+			return true
+		}
+	}
+	return false
 }
 
 // ExtractOutput extracts LaTeX content from a string between <Output> tags
@@ -0,0 +1,223 @@
+package latex
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Hunk is one "@@ -a,b +c,d @@" block of a unified diff
+// Lines keep their ' ', '-' or '+' prefix
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []string
+}
+
+// Patch is a unified diff against a single document
+type Patch struct {
+	Hunks []Hunk
+}
+
+// PatchStats counts what a patch did to a document
+// Removed counts every line a hunk took out, replaced or not, Deleted only the ones it didn't replace
+type PatchStats struct {
+	Hunks         int `json:"hunks"`
+	Added         int `json:"added"`
+	Removed       int `json:"removed"`
+	Deleted       int `json:"deleted"`
+	DocumentLines int `json:"documentLines"`
+}
+
+// RemovedShare is the part of the document the patch removed or replaced, from 0 to 1
+// A hunk that swaps lines for as many new ones changes the document as much as one that deletes them
+func (s PatchStats) RemovedShare() float64 {
+	if s.DocumentLines == 0 {
+		return 0
+	}
+	return float64(s.Removed) / float64(s.DocumentLines)
+}
+
+// The line numbers are optional, models often get them wrong or leave them out
+var (
+	hunkHeaderPattern   = regexp.MustCompile(`^@@(?: -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))?)? ?@@`)
+	numberedLinePattern = regexp.MustCompile(`^ *\d+ \| `)
+)
+
+// ParsePatch reads a unified diff
+// Code fences, ---/+++ headers and any text before or between hunks are skipped
+func ParsePatch(text string) (*Patch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	patch := &Patch{}
+	var current *Hunk
+
+	for _, line := range lines {
+		if m := hunkHeaderPattern.FindStringSubmatch(line); m != nil {
+			patch.Hunks = append(patch.Hunks, Hunk{
+				OldStart: atoiDefault(m[1], 0),
+				OldLines: atoiDefault(m[2], 1),
+				NewStart: atoiDefault(m[3], 0),
+				NewLines: atoiDefault(m[4], 1),
+			})
+			current = &patch.Hunks[len(patch.Hunks)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file"
+		case line == "":
+			current.Lines = append(current.Lines, " ")
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			// Drop line numbers copied over from the numbered document in the prompt
+			current.Lines = append(current.Lines, line[:1]+numberedLinePattern.ReplaceAllString(line[1:], ""))
+		default:
+			// Anything else is commentary, skip it until the next hunk
+			current = nil
+		}
+	}
+
+	// Blank lines at the end of a hunk are usually just the end of the answer
+	for i := range patch.Hunks {
+		h := &patch.Hunks[i]
+		for len(h.Lines) > 0 && h.Lines[len(h.Lines)-1] == " " {
+			h.Lines = h.Lines[:len(h.Lines)-1]
+		}
+	}
+
+	kept := patch.Hunks[:0]
+	for _, h := range patch.Hunks {
+		if len(h.Lines) > 0 {
+			kept = append(kept, h)
+		}
+	}
+	patch.Hunks = kept
+	if len(patch.Hunks) == 0 {
+		return nil, fmt.Errorf("no diff hunks found")
+	}
+	return patch, nil
+}
+
+// Apply applies the patch to content
+// Each hunk is matched by its context and removed lines, starting at its line number and then
+// looking further away, so slightly wrong line numbers still apply. A hunk that can't be found
+// fails the whole patch
+func (p *Patch) Apply(content string) (string, PatchStats, error) {
+	doc := strings.Split(content, "\n")
+	stats := PatchStats{Hunks: len(p.Hunks), DocumentLines: len(doc)}
+	if strings.HasSuffix(content, "\n") {
+		stats.DocumentLines--
+	}
+
+	// Hunks are applied top to bottom, sort them when every one has a line number
+	hunks := append([]Hunk(nil), p.Hunks...)
+	numbered := true
+	for _, h := range hunks {
+		numbered = numbered && h.OldStart > 0
+	}
+	if numbered {
+		sort.SliceStable(hunks, func(i, j int) bool { return hunks[i].OldStart < hunks[j].OldStart })
+	}
+
+	var out []string
+	cursor := 0
+	for i, h := range hunks {
+		var old, replacement []string
+		removed, added := 0, 0
+		for _, line := range h.Lines {
+			switch line[0] {
+			case ' ':
+				old = append(old, line[1:])
+				replacement = append(replacement, line[1:])
+			case '-':
+				old = append(old, line[1:])
+				removed++
+			case '+':
+				replacement = append(replacement, line[1:])
+				added++
+			}
+		}
+		stats.Removed += removed
+		stats.Added += added
+		if removed > added {
+			stats.Deleted += removed - added
+		}
+
+		expected := cursor
+		if h.OldStart > 0 {
+			expected = h.OldStart - 1
+			if len(old) == 0 {
+				// "-n,0" inserts after line n
+				expected = h.OldStart
+			}
+		}
+		at, ok := findLines(doc, old, expected, cursor)
+		if !ok {
+			return "", stats, fmt.Errorf("hunk %d does not match the document near line %d", i+1, expected+1)
+		}
+
+		out = append(out, doc[cursor:at]...)
+		out = append(out, replacement...)
+		cursor = at + len(old)
+	}
+	out = append(out, doc[cursor:]...)
+
+	return strings.Join(out, "\n"), stats, nil
+}
+
+// findLines finds want in doc at or after min, trying the lines closest to expected first
+// Trailing whitespace is ignored
+func findLines(doc, want []string, expected, min int) (int, bool) {
+	if expected < min {
+		expected = min
+	}
+	if expected > len(doc) {
+		expected = len(doc)
+	}
+	if len(want) == 0 {
+		return expected, true
+	}
+
+	matches := func(at int) bool {
+		if at < min || at+len(want) > len(doc) {
+			return false
+		}
+		for i, line := range want {
+			if strings.TrimRight(doc[at+i], " \t") != strings.TrimRight(line, " \t") {
+				return false
+			}
+		}
+		return true
+	}
+	for offset := 0; offset <= len(doc); offset++ {
+		if matches(expected + offset) {
+			return expected + offset, true
+		}
+		if offset > 0 && matches(expected-offset) {
+			return expected - offset, true
+		}
+	}
+	return 0, false
+}
+
+// NumberLines prefixes every line with its number, for prompts that ask for line based edits
+func NumberLines(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf("%4d | %s", i+1, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func atoiDefault(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return def
+}
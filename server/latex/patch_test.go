@@ -0,0 +1,134 @@
+package latex
+
+import (
+	"strings"
+	"testing"
+)
+
+const patchDocument = `\documentclass{article}
+\begin{document}
+one
+two
+three
+four
+five
+six
+seven
+\end{document}
+`
+
+func TestPatchApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		removed int
+		added   int
+		wantErr string
+	}{
+		{
+			name:    "exact line numbers",
+			patch:   "@@ -3,2 +3,2 @@\n one\n-two\n+TWO\n",
+			want:    strings.Replace(patchDocument, "two", "TWO", 1),
+			removed: 1, added: 1,
+		},
+		{
+			name:    "offset line numbers",
+			patch:   "@@ -1,3 +1,3 @@\n five\n-six\n+SIX\n seven\n",
+			want:    strings.Replace(patchDocument, "six", "SIX", 1),
+			removed: 1, added: 1,
+		},
+		{
+			name:    "no line numbers",
+			patch:   "@@ @@\n three\n-four\n+FOUR\n",
+			want:    strings.Replace(patchDocument, "four", "FOUR", 1),
+			removed: 1, added: 1,
+		},
+		{
+			name:  "pure insertion",
+			patch: "@@ -4,0 +5,1 @@\n+inserted\n",
+			want:  strings.Replace(patchDocument, "two\n", "two\ninserted\n", 1),
+			added: 1,
+		},
+		{
+			name:    "fenced with headers and line numbers",
+			patch:   "```diff\n--- a/doc.tex\n+++ b/doc.tex\n@@ -5,1 +5,1 @@\n-   5 | three\n+   5 | THREE\n```\n",
+			want:    strings.Replace(patchDocument, "three", "THREE", 1),
+			removed: 1, added: 1,
+		},
+		{
+			name:    "two hunks out of order",
+			patch:   "@@ -8,1 +8,1 @@\n-six\n+SIX\n@@ -3,1 +3,1 @@\n-one\n+ONE\n",
+			want:    strings.Replace(strings.Replace(patchDocument, "six", "SIX", 1), "one", "ONE", 1),
+			removed: 2, added: 2,
+		},
+		{
+			name:    "hunk that doesn't match",
+			patch:   "@@ -3,2 +3,2 @@\n one\n-zwei\n+TWO\n",
+			wantErr: "hunk 1 does not match",
+		},
+		{
+			name:    "overlapping hunks",
+			patch:   "@@ -3,3 +3,3 @@\n one\n-two\n+TWO\n three\n@@ -5,2 +5,2 @@\n three\n-four\n+FOUR\n",
+			wantErr: "hunk 2 does not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParsePatch(tt.patch)
+			if err != nil {
+				t.Fatalf("ParsePatch: %v", err)
+			}
+			got, stats, err := patch.Apply(patchDocument)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Apply =\n%s\nwant\n%s", got, tt.want)
+			}
+			if stats.Removed != tt.removed || stats.Added != tt.added {
+				t.Errorf("stats = %+v, want %d removed and %d added", stats, tt.removed, tt.added)
+			}
+			if stats.DocumentLines != 10 {
+				t.Errorf("DocumentLines = %d, want 10", stats.DocumentLines)
+			}
+		})
+	}
+}
+
+func TestPatchRemovedShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    string
+		rejected bool
+	}{
+		{"one line replaced", "@@ -3,1 +3,1 @@\n-one\n+ONE\n", false},
+		{"two lines deleted", "@@ -3,2 +3,0 @@\n-one\n-two\n", false},
+		{"lines deleted", "@@ -3,3 +3,0 @@\n-one\n-two\n-three\n", true},
+		// Replacing counts as much as deleting, a rewrite of the body is not a fix
+		{"lines replaced", "@@ -3,3 +3,3 @@\n-one\n-two\n-three\n+uno\n+dos\n+tres\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParsePatch(tt.patch)
+			if err != nil {
+				t.Fatalf("ParsePatch: %v", err)
+			}
+			_, stats, err := patch.Apply(patchDocument)
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if rejected := stats.RemovedShare() > defaultMaxDeleteShare; rejected != tt.rejected {
+				t.Errorf("RemovedShare = %.2f, rejected %v, want %v", stats.RemovedShare(), rejected, tt.rejected)
+			}
+		})
+	}
+}
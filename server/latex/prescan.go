@@ -0,0 +1,332 @@
+package latex
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ClassBlocked is the class of compile diagnostics for primitives the sandbox refuses to run
+const ClassBlocked = "blocked-primitive"
+
+// ErrBlocked is the error of a CompileError from a document that failed the pre-scan
+var ErrBlocked = errors.New("document uses blocked file or shell primitives")
+
+// blockedCommands read or write files outside the document, run programs, or can be used to hide
+// the other ones from the scan
+var blockedCommands = map[string]string{
+	"openin":      "opens a file for reading",
+	"openout":     "opens a file for writing",
+	"read":        "reads from a file",
+	"readline":    "reads from a file",
+	"closein":     "reads from a file",
+	"directlua":   "runs Lua code",
+	"latelua":     "runs Lua code",
+	"luaexec":     "runs Lua code",
+	"luadirect":   "runs Lua code",
+	"ShellEscape": "runs a shell command",
+	"catcode":     "changes character codes, which can hide commands from the check",
+	"scantokens":  "re-reads text as commands, which can hide commands from the check",
+	// The kernel's copies of \input, reachable after \makeatletter
+	"@@input":  "reads a file with the TeX primitive",
+	"@input":   "reads a file",
+	"@iinput":  "reads a file",
+	"@input@":  "reads a file",
+	"@include": "reads a file",
+	// The directories files are searched in, pointed outside they make \input{passwd} read /etc/passwd
+	"input@path":  "sets where files are searched for",
+	"Ginput@path": "sets where images are searched for",
+	// catchfile reads a whole file into a macro
+	"CatchFileDef":  "reads a file into a macro",
+	"CatchFileEdef": "reads a file into a macro",
+	// expl3 names have _ and :, which the scan doesn't read as part of a name, so \file_input:n
+	// and the like would go unseen
+	"ExplSyntaxOn": "switches to expl3 names, which hide file commands from the check",
+	// pdfTeX and XeTeX primitives that read a file by name
+	"pdfobj":            "can embed a file",
+	"pdfximage":         "embeds a file",
+	"pdffiledump":       "reads a file",
+	"pdffilesize":       "reads a file's size",
+	"pdffilemoddate":    "reads a file's date",
+	"pdfmdfivesum":      "can read a file",
+	"filedump":          "reads a file",
+	"filesize":          "reads a file's size",
+	"filemoddate":       "reads a file's date",
+	"mdfivesum":         "can read a file",
+	"XeTeXpicfile":      "embeds a file",
+	"XeTeXpdffile":      "embeds a file",
+	"XeTeXpdfpagecount": "reads a file",
+}
+
+// aliasCommands give an existing command a new name, which the scan would no longer recognise
+var aliasCommands = map[string]bool{
+	"let":                true,
+	"glet":               true,
+	"futurelet":          true,
+	"LetLtxMacro":        true,
+	"NewCommandCopy":     true,
+	"RenewCommandCopy":   true,
+	"DeclareCommandCopy": true,
+}
+
+// fileCommands take a file name, which has to stay inside the compile directory
+var fileCommands = map[string]bool{
+	"input":             true,
+	"include":           true,
+	"InputIfFileExists": true,
+	"IfFileExists":      true,
+	"lstinputlisting":   true,
+	"verbatiminput":     true,
+	"includegraphics":   true,
+	"includepdf":        true,
+	"usepackage":        true,
+	"RequirePackage":    true,
+	"documentclass":     true,
+	"bibliography":      true,
+	"addbibresource":    true,
+}
+
+var drivePattern = regexp.MustCompile(`^[A-Za-z]:`)
+
+// Prescan looks for primitives that read files outside the compile directory or run programs
+// Verbatim blocks are checked too, whether they really are verbatim depends on the packages loaded
+func Prescan(content string) []CompileDiagnostic {
+	var diagnostics []CompileDiagnostic
+	block := func(pos Position, token, reason string) {
+		diagnostics = append(diagnostics, CompileDiagnostic{
+			Line:    pos.Line,
+			Class:   ClassBlocked,
+			Message: fmt.Sprintf("%s is not allowed, it %s", token, reason),
+			Token:   token,
+		})
+	}
+
+	tokens := Tokenize(content)
+	for i, tok := range tokens {
+		if tok.Kind == TokenComment {
+			continue
+		}
+
+		if tok.Kind == TokenCommand {
+			// @ counts as a letter, so \@@input is one command as it is after \makeatletter
+			name, rest := tok.Value, tokens[i+1:]
+			if reason, ok := blockedCommands[name]; ok {
+				block(tok.Pos, `\`+name, reason)
+				continue
+			}
+			if aliasCommands[name] {
+				if target, ok := aliasTarget(rest); ok {
+					if reason, ok := blockedCommands[target]; ok {
+						block(tok.Pos, fmt.Sprintf(`\%s \%s`, name, target), reason+", under another name")
+					} else if fileCommands[target] {
+						block(tok.Pos, fmt.Sprintf(`\%s \%s`, name, target), "renames a file command, which hides its file name from the check")
+					}
+				}
+				continue
+			}
+			if fileCommands[name] {
+				// Only a file name written out can be checked, \input\p or \input{#1} could be anything
+				path, literal := fileArgument(rest)
+				if !literal {
+					block(tok.Pos, `\`+name, "takes a file name that is not written out, so it can't be checked")
+				} else if reason := unsafePath(path); reason != "" {
+					block(tok.Pos, fmt.Sprintf(`\%s{%s}`, name, path), reason)
+				}
+				continue
+			}
+		}
+
+		switch {
+		case tok.Kind == TokenCommand && tok.Value == "write":
+			// \write18 tokenizes as \write then "18"
+			if i+1 < len(tokens) && tokens[i+1].Kind == TokenText && strings.HasPrefix(strings.TrimSpace(tokens[i+1].Value), "18") {
+				block(tok.Pos, `\write18`, "runs a shell command")
+			}
+		case tok.Kind == TokenCommand && tok.Value == "csname":
+			// \csname write18\endcsname builds a command the checks above never see
+			var name strings.Builder
+			expanded := false
+			for _, next := range tokens[i+1:] {
+				if next.Kind == TokenCommand && next.Value == "endcsname" {
+					break
+				}
+				if next.Kind != TokenText {
+					// \csname\x put\endcsname, the name depends on what \x holds
+					expanded = true
+				}
+				name.WriteString(next.Value)
+			}
+			built := strings.TrimSpace(name.String())
+			if expanded {
+				block(tok.Pos, `\csname`, "builds a command name from macros, which hides it from the check")
+			} else if reason, ok := blockedCommands[built]; ok {
+				block(tok.Pos, `\csname `+built+`\endcsname`, reason)
+			} else if fileCommands[built] || strings.HasPrefix(built, "write18") || strings.HasPrefix(built, "input") {
+				block(tok.Pos, `\csname `+built+`\endcsname`, "can run a shell command or read a file")
+			}
+		case tok.Kind == TokenCommand && tok.Value == "graphicspath":
+			// \graphicspath{{images/}{figures/}}, every directory has to stay inside the document
+			dirs, literal := graphicsPaths(tokens[i+1:])
+			if !literal {
+				block(tok.Pos, `\graphicspath`, "takes a directory that is not written out, so it can't be checked")
+			}
+			for _, dir := range dirs {
+				if reason := unsafePath(dir); reason != "" {
+					block(tok.Pos, `\graphicspath{`+dir+`}`, reason)
+				}
+			}
+		case tok.Kind == TokenVerbatim:
+			for _, d := range Prescan(tok.Value) {
+				d.Line += tok.Pos.Line - 1
+				diagnostics = append(diagnostics, d)
+			}
+		case tok.Kind == TokenText && strings.Contains(tok.Value, "^^"):
+			block(tok.Pos, "^^", "writes characters by code, which can hide commands from the check")
+		}
+	}
+
+	attachSourceContext(diagnostics, content)
+	return dedupeDiagnostics(diagnostics)
+}
+
+// graphicsPaths returns the directories of a \graphicspath argument
+// literal is false when one of them isn't plain text or the argument is missing
+func graphicsPaths(tokens []Token) (dirs []string, literal bool) {
+	i := 0
+	for i < len(tokens) && tokens[i].Kind == TokenText && strings.TrimSpace(tokens[i].Value) == "" {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].Kind != TokenOpenBrace {
+		return nil, false
+	}
+	for i++; i < len(tokens) && tokens[i].Kind != TokenCloseBrace; i++ {
+		switch {
+		case tokens[i].Kind == TokenOpenBrace:
+			var dir strings.Builder
+			for i++; i < len(tokens) && tokens[i].Kind != TokenCloseBrace; i++ {
+				if tokens[i].Kind != TokenText {
+					return dirs, false
+				}
+				dir.WriteString(tokens[i].Value)
+			}
+			dirs = append(dirs, strings.TrimSpace(dir.String()))
+		case tokens[i].Kind == TokenText && strings.TrimSpace(tokens[i].Value) == "":
+		default:
+			// {/etc/} without the inner braces, or a macro
+			return dirs, false
+		}
+	}
+	return dirs, i < len(tokens)
+}
+
+// aliasTarget returns the command an alias command copies, the second command after it
+// \let\x\input, \let\x=\input and \NewCommandCopy{\x}{\input} all give input
+func aliasTarget(tokens []Token) (string, bool) {
+	seen := 0
+	for _, tok := range tokens {
+		switch {
+		case tok.Kind == TokenCommand:
+			seen++
+			if seen == 2 {
+				return tok.Value, true
+			}
+		case tok.Kind == TokenOpenBrace || tok.Kind == TokenCloseBrace:
+		case tok.Kind == TokenText && strings.Trim(tok.Value, " \t\n=") == "":
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// fileArgument returns the file name given to a file command, in braces or after a space
+// literal is false when the name isn't written out: a macro, a parameter, a group or nothing
+func fileArgument(tokens []Token) (path string, literal bool) {
+	i := 0
+	skipSpace := func() {
+		for i < len(tokens) && tokens[i].Kind == TokenText && strings.TrimSpace(tokens[i].Value) == "" {
+			i++
+		}
+	}
+
+	skipSpace()
+	// Optional arguments, [width=\linewidth] and the like
+	for i < len(tokens) && tokens[i].Kind == TokenText && tokens[i].Value == "[" {
+		depth := 0
+		for ; i < len(tokens); i++ {
+			if tokens[i].Kind == TokenText && tokens[i].Value == "[" {
+				depth++
+			} else if tokens[i].Kind == TokenText && tokens[i].Value == "]" {
+				depth--
+				if depth == 0 {
+					i++
+					break
+				}
+			}
+		}
+		skipSpace()
+	}
+	if i >= len(tokens) {
+		return "", false
+	}
+
+	if tokens[i].Kind == TokenOpenBrace {
+		var b strings.Builder
+		for i++; i < len(tokens) && tokens[i].Kind != TokenCloseBrace; i++ {
+			if tokens[i].Kind != TokenText {
+				return "", false
+			}
+			b.WriteString(tokens[i].Value)
+		}
+		if i >= len(tokens) {
+			return "", false
+		}
+		path = strings.TrimSpace(b.String())
+		return path, path != "" && !strings.ContainsAny(path, "#^")
+	}
+
+	// TeX's own \input file syntax, the name runs up to the next space
+	if tokens[i].Kind == TokenText {
+		path = strings.Fields(tokens[i].Value)[0]
+		// \input file\p reads a name that goes on with the macro
+		if strings.TrimLeft(tokens[i].Value, " \t\n") == path && i+1 < len(tokens) && tokens[i+1].Kind == TokenCommand {
+			return "", false
+		}
+		return path, !strings.ContainsAny(path, "#^")
+	}
+	return "", false
+}
+
+// unsafePath says why a file name given to a file command is not allowed, empty when it is fine
+func unsafePath(path string) string {
+	for _, name := range strings.Split(path, ",") {
+		name = strings.Trim(strings.TrimSpace(name), `"`)
+		switch {
+		case strings.HasPrefix(name, "|"):
+			return "runs a shell command"
+		case strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || drivePattern.MatchString(name):
+			return "reads a file by absolute path"
+		case strings.HasPrefix(name, "~") || strings.HasPrefix(name, "$"):
+			return "reads a file outside the document"
+		case name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, "/../") || strings.HasSuffix(name, "/.."):
+			return "reads a file outside the document"
+		}
+	}
+	return ""
+}
+
+// dedupeDiagnostics drops repeats of the same token on the same line
+func dedupeDiagnostics(diagnostics []CompileDiagnostic) []CompileDiagnostic {
+	seen := make(map[string]bool)
+	var out []CompileDiagnostic
+	for _, d := range diagnostics {
+		key := fmt.Sprintf("%d|%s", d.Line, d.Token)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, d)
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package latex
+
+import "testing"
+
+func TestPrescanBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		token   string
+	}{
+		{"absolute path", `\input{/etc/passwd}`, `\input{/etc/passwd}`},
+		{"tex syntax", `\input /etc/passwd`, `\input{/etc/passwd}`},
+		{"parent directory", `\include{../secret}`, `\include{../secret}`},
+		{"pipe", `\input{|ls}`, `\input{|ls}`},
+		{"macro argument", `\def\p{/etc/passwd}\input\p`, `\input`},
+		{"expandafter", `\expandafter\input\p`, `\input`},
+		{"macro in braces", `\input{\p}`, `\input`},
+		{"name continued by a macro", `\input file\p`, `\input`},
+		{"parameter", `\newcommand\x[1]{\input{#1}}`, `\input`},
+		{"missing argument", `\def\x{\input}`, `\input`},
+		{"kernel primitive", `\makeatletter\@@input /etc/passwd`, `\@@input`},
+		{"kernel input", `\makeatletter\@input{/etc/passwd}`, `\@input`},
+		{"let alias", `\let\x\input \x{/etc/passwd}`, `\let \input`},
+		{"let alias with equals", `\let\x=\input`, `\let \input`},
+		{"let alias of primitive", `\let\x\@@input`, `\let \@@input`},
+		{"command copy", `\NewCommandCopy{\x}{\input}`, `\NewCommandCopy \input`},
+		{"catchfile", `\usepackage{catchfile}\CatchFileDef\x{/etc/passwd}{}`, `\CatchFileDef`},
+		{"catchfile expanded", `\CatchFileEdef\x{notes.txt}{}`, `\CatchFileEdef`},
+		{"csname", `\csname @@input\endcsname /etc/passwd`, `\csname @@input\endcsname`},
+		{"write18", `\immediate\write18{ls}`, `\write18`},
+		{"openin", `\openin5=/etc/passwd`, `\openin`},
+		{"csname from a macro", `\def\x{in}\csname\x put\endcsname /etc/passwd`, `\csname`},
+		{"csname of a file command", `\csname include\endcsname{/etc/passwd}`, `\csname include\endcsname`},
+		{"input path", `\makeatletter\def\input@path{{/etc/}}\makeatother\input{passwd}`, `\input@path`},
+		{"graphics input path", `\makeatletter\def\Ginput@path{{/etc/}}`, `\Ginput@path`},
+		{"graphicspath", `\graphicspath{{/etc/}}\includegraphics{passwd}`, `\graphicspath{/etc/}`},
+		{"graphicspath parent", `\graphicspath{{images/}{../../}}`, `\graphicspath{../../}`},
+		{"graphicspath macro", `\graphicspath{{\dir}}`, `\graphicspath`},
+		{"kernel iinput", `\makeatletter\@iinput{/etc/passwd}`, `\@iinput`},
+		{"kernel input at", `\makeatletter\@input@{/etc/passwd}`, `\@input@`},
+		{"expl3", `\ExplSyntaxOn\file_input:n{/etc/passwd}`, `\ExplSyntaxOn`},
+		{"pdfobj", `\pdfobj stream file {/etc/passwd}`, `\pdfobj`},
+		{"pdffiledump", `\pdffiledump length 100 {/etc/passwd}`, `\pdffiledump`},
+		{"pdfximage", `\pdfximage{/etc/passwd}`, `\pdfximage`},
+		{"xetex filedump", `\filedump length 100 {/etc/passwd}`, `\filedump`},
+		{"xetex picfile", `\XeTeXpicfile "/etc/passwd"`, `\XeTeXpicfile`},
+		{"xetex pdffile", `\XeTeXpdffile "/etc/secret.pdf"`, `\XeTeXpdffile`},
+		{"verbatim", "\\begin{verbatim}\n\\input{/etc/passwd}\n\\end{verbatim}", `\input{/etc/passwd}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := Prescan(tt.content)
+			for _, d := range diagnostics {
+				if d.Class != ClassBlocked {
+					t.Errorf("diagnostic %q has class %q", d.Token, d.Class)
+				}
+				if d.Token == tt.token {
+					return
+				}
+			}
+			t.Errorf("Prescan(%q) did not block %q, got %+v", tt.content, tt.token, diagnostics)
+		})
+	}
+}
+
+func TestPrescanAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"packages", "\\documentclass{article}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amsmath,amssymb}"},
+		{"graphics options", `\includegraphics[width=\linewidth]{img.png}`},
+		{"nested options", `\includegraphics[width=0.5\textwidth,trim={1 2 3 4}]{figures/plot.pdf}`},
+		{"relative input", `\input{chapters/one}`},
+		{"tex syntax", `\input chapter1 more text`},
+		{"tex syntax at the end", `\input chapter1`},
+		{"let of another command", `\let\oldsection\section`},
+		{"makeatletter", `\makeatletter\def\@maketitle{\centering\@title}\makeatother`},
+		{"graphicspath", `\graphicspath{{images/}{figures/plots/}}`},
+		{"csname", `\csname section\endcsname{Intro}`},
+		{"commented out", `% \input{/etc/passwd}`},
+		{"write to a stream", `\immediate\write\out{text}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diagnostics := Prescan(tt.content); len(diagnostics) != 0 {
+				t.Errorf("Prescan(%q) blocked %+v", tt.content, diagnostics)
+			}
+		})
+	}
+}
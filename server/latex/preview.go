@@ -0,0 +1,193 @@
+package latex
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// PreviewConfig is read from LATEX_PREVIEWS
+// Rasterizer is "pdftoppm" or "mutool", empty picks whichever is installed
+type PreviewConfig struct {
+	Disabled       bool   `json:"disabled"`
+	Pages          bool   `json:"pages"`
+	ThumbnailWidth int    `json:"thumbnailWidth"`
+	DPI            int    `json:"dpi"`
+	MaxPages       int    `json:"maxPages"`
+	Rasterizer     string `json:"rasterizer"`
+}
+
+var defaultPreviews = PreviewConfig{
+	ThumbnailWidth: 320,
+	DPI:            100,
+	MaxPages:       10,
+}
+
+const previewTimeout = 60 * time.Second
+
+func previewsFromConfig() PreviewConfig {
+	cfg := defaultPreviews
+	config.DecodeConfigValue("LATEX_PREVIEWS", &cfg)
+	if cfg.ThumbnailWidth <= 0 {
+		cfg.ThumbnailWidth = defaultPreviews.ThumbnailWidth
+	}
+	if cfg.DPI <= 0 {
+		cfg.DPI = defaultPreviews.DPI
+	}
+	if cfg.MaxPages <= 0 {
+		cfg.MaxPages = defaultPreviews.MaxPages
+	}
+	return cfg
+}
+
+// Previews are the PNGs rendered beside a PDF, as file paths
+// For foo.pdf the thumbnail is foo.thumb.png and the pages foo.page-1.png, foo.page-2.png, ...
+type Previews struct {
+	Thumbnail string   `json:"thumbnail,omitempty"`
+	Pages     []string `json:"pages,omitempty"`
+}
+
+// rasterizer picks the tool that renders PDFs to PNG
+func rasterizer(cfg PreviewConfig) (string, error) {
+	candidates := []string{"pdftoppm", "mutool"}
+	if cfg.Rasterizer != "" {
+		candidates = []string{cfg.Rasterizer}
+	}
+	for _, name := range candidates {
+		if _, err := exec.LookPath(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no PDF rasterizer installed, install poppler-utils (pdftoppm) or mupdf-tools (mutool)")
+}
+
+// RenderPreviews renders the first page thumbnail of a PDF, and every page up to MaxPages when
+// LATEX_PREVIEWS asks for pages. Nothing is rendered when previews are disabled
+func RenderPreviews(ctx context.Context, pdfPath string) (*Previews, error) {
+	cfg := previewsFromConfig()
+	if cfg.Disabled {
+		return &Previews{}, nil
+	}
+	tool, err := rasterizer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	base := strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath))
+	previews := &Previews{Thumbnail: base + ".thumb.png"}
+	// A PDF rendered again can have fewer pages, the old page files must not be listed with it
+	if err := RemovePreviews(pdfPath); err != nil {
+		return nil, err
+	}
+
+	var thumbnail *exec.Cmd
+	if tool == "pdftoppm" {
+		// -singlefile writes <prefix>.png without a page number
+		thumbnail = exec.CommandContext(ctx, "pdftoppm", "-png", "-f", "1", "-l", "1", "-singlefile",
+			"-scale-to-x", strconv.Itoa(cfg.ThumbnailWidth), "-scale-to-y", "-1", pdfPath, base+".thumb")
+	} else {
+		thumbnail = exec.CommandContext(ctx, "mutool", "draw", "-q", "-F", "png", "-w", strconv.Itoa(cfg.ThumbnailWidth),
+			"-o", previews.Thumbnail, pdfPath, "1")
+	}
+	if output, err := thumbnail.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed to render the thumbnail: %v\n%s", tool, err, output)
+	}
+
+	if !cfg.Pages {
+		return previews, nil
+	}
+
+	var pages *exec.Cmd
+	if tool == "pdftoppm" {
+		pages = exec.CommandContext(ctx, "pdftoppm", "-png", "-r", strconv.Itoa(cfg.DPI),
+			"-l", strconv.Itoa(cfg.MaxPages), pdfPath, base+".page")
+	} else {
+		pages = exec.CommandContext(ctx, "mutool", "draw", "-q", "-F", "png", "-r", strconv.Itoa(cfg.DPI),
+			"-o", base+".page-%d.png", pdfPath, fmt.Sprintf("1-%d", cfg.MaxPages))
+	}
+	if output, err := pages.CombinedOutput(); err != nil {
+		log.Printf("[WARNING] %s failed to render pages of %s: %v\n%s", tool, pdfPath, err, output)
+		return previews, nil
+	}
+
+	// pdftoppm pads page numbers to the page count (page-01.png), name them all page-N.png
+	previews.Pages = normalizePageFiles(base)
+	return previews, nil
+}
+
+// FindPreviews returns the previews already rendered beside a PDF, nil when there are none or
+// when they are older than the PDF, which was compiled again since
+func FindPreviews(pdfPath string) *Previews {
+	base := strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath))
+	previews := &Previews{}
+	if _, err := os.Stat(base + ".thumb.png"); err == nil {
+		previews.Thumbnail = base + ".thumb.png"
+	}
+	previews.Pages = normalizePageFiles(base)
+	if previews.Thumbnail == "" && len(previews.Pages) == 0 {
+		return nil
+	}
+	if pdf, err := os.Stat(pdfPath); err == nil {
+		for _, path := range append([]string{previews.Thumbnail}, previews.Pages...) {
+			if info, err := os.Stat(path); err == nil && info.ModTime().Before(pdf.ModTime()) {
+				return nil
+			}
+		}
+	}
+	return previews
+}
+
+// RemovePreviews deletes the thumbnail and page PNGs beside a PDF
+func RemovePreviews(pdfPath string) error {
+	base := strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath))
+	pages, _ := filepath.Glob(base + ".page-*.png")
+	for _, path := range append([]string{base + ".thumb.png"}, pages...) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old preview %s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// normalizePageFiles renames <base>.page-0N.png to <base>.page-N.png and lists them in page order
+func normalizePageFiles(base string) []string {
+	matches, _ := filepath.Glob(base + ".page-*.png")
+	type page struct {
+		n    int
+		path string
+	}
+	var pages []page
+	for _, match := range matches {
+		number := strings.TrimSuffix(strings.TrimPrefix(match, base+".page-"), ".png")
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		path := fmt.Sprintf("%s.page-%d.png", base, n)
+		if path != match {
+			if err := os.Rename(match, path); err != nil {
+				continue
+			}
+		}
+		pages = append(pages, page{n, path})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].n < pages[j].n })
+
+	paths := make([]string, len(pages))
+	for i, p := range pages {
+		paths[i] = p.path
+	}
+	return paths
+}
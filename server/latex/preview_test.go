@@ -0,0 +1,68 @@
+package latex
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, modTime time.Time, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindPreviews(t *testing.T) {
+	dir := t.TempDir()
+	pdf := filepath.Join(dir, "job.pdf")
+	compiled := time.Now().Add(-time.Hour)
+	writeFiles(t, dir, compiled, "job.pdf")
+	writeFiles(t, dir, compiled.Add(time.Minute), "job.thumb.png", "job.page-01.png", "job.page-02.png", "job.page-10.png", "other.page-1.png")
+
+	previews := FindPreviews(pdf)
+	if previews == nil {
+		t.Fatal("FindPreviews found nothing")
+	}
+	want := []string{filepath.Join(dir, "job.page-1.png"), filepath.Join(dir, "job.page-2.png"), filepath.Join(dir, "job.page-10.png")}
+	if previews.Thumbnail != filepath.Join(dir, "job.thumb.png") || !reflect.DeepEqual(previews.Pages, want) {
+		t.Errorf("FindPreviews = %+v, want the thumbnail and pages %v", previews, want)
+	}
+
+	// The PDF was compiled again, what is beside it belongs to the old one
+	writeFiles(t, dir, compiled.Add(2*time.Minute), "job.pdf")
+	if previews := FindPreviews(pdf); previews != nil {
+		t.Errorf("FindPreviews returned previews older than the PDF: %+v", previews)
+	}
+}
+
+func TestRemovePreviews(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, time.Now(), "job.pdf", "job.tex", "job.thumb.png", "job.page-1.png", "job.page-2.png", "job2.page-1.png")
+
+	if err := RemovePreviews(filepath.Join(dir, "job.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	if want := []string{"job.pdf", "job.tex", "job2.page-1.png"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("left %v, want %v", left, want)
+	}
+	if err := RemovePreviews(filepath.Join(dir, "job.pdf")); err != nil {
+		t.Errorf("removing previews that are gone: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package latex
+
+import "context"
+
+// ProgressFunc receives stage updates from the compile pipeline
+type ProgressFunc func(stage, message string, extra map[string]interface{})
+
+type progressKey struct{}
+
+// WithProgress attaches a stage update callback to the context
+// The sheet queue uses it to forward lint fixes and compile steps to the job websocket
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress sends a stage update if the context carries a callback
+func reportProgress(ctx context.Context, stage, message string, extra map[string]interface{}) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && fn != nil {
+		fn(stage, message, extra)
+	}
+}
@@ -0,0 +1,119 @@
+package latex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// SandboxConfig limits what a compile can do, set with LATEX_SANDBOX
+// A zero CPUSec or MemoryMB turns that limit off
+type SandboxConfig struct {
+	TimeoutSec int      `json:"timeoutSec"`
+	CPUSec     int      `json:"cpuSec"`
+	MemoryMB   int      `json:"memoryMB"`
+	Dir        string   `json:"dir"`
+	CacheDir   string   `json:"cacheDir"`
+	PassEnv    []string `json:"passEnv"`
+}
+
+var defaultSandbox = SandboxConfig{
+	TimeoutSec: 120,
+	CPUSec:     90,
+	MemoryMB:   2048,
+}
+
+// sandboxFromConfig reads LATEX_SANDBOX over the defaults
+func sandboxFromConfig() SandboxConfig {
+	sandbox := defaultSandbox
+	config.DecodeConfigValue("LATEX_SANDBOX", &sandbox)
+	if sandbox.TimeoutSec <= 0 {
+		sandbox.TimeoutSec = defaultSandbox.TimeoutSec
+	}
+	if sandbox.Dir == "" {
+		sandbox.Dir = filepath.Join(os.TempDir(), "nightways-latex")
+	}
+	if sandbox.CacheDir == "" {
+		if cache, err := os.UserCacheDir(); err == nil {
+			sandbox.CacheDir = filepath.Join(cache, "nightways-tex")
+		} else {
+			sandbox.CacheDir = filepath.Join(sandbox.Dir, "cache")
+		}
+	}
+	return sandbox
+}
+
+// Timeout is the wall clock limit of one compile
+func (s SandboxConfig) Timeout() time.Duration {
+	return time.Duration(s.TimeoutSec) * time.Second
+}
+
+// newJail creates an empty directory for one compile, only the server user can read it
+func (s SandboxConfig) newJail() (string, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+	return os.MkdirTemp(s.Dir, "jail-*")
+}
+
+// env is the whole environment of a compile
+// Nothing from the server's environment gets through except PATH and PassEnv. kpathsea (TeX Live)
+// is told to only open files below the jail and never run shell commands, and package caches live
+// outside the jail so they survive between compiles
+func (s SandboxConfig) env(jail string) []string {
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + jail,
+		"TMPDIR=" + jail,
+		"LANG=C.UTF-8",
+		"openin_any=p",
+		"openout_any=p",
+		"shell_escape=f",
+		"TECTONIC_CACHE_DIR=" + filepath.Join(s.CacheDir, "tectonic"),
+		"TEXMFVAR=" + filepath.Join(s.CacheDir, "texmf-var"),
+		"XDG_CACHE_HOME=" + s.CacheDir,
+	}
+	for _, name := range s.PassEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// sandboxCommand runs cmd inside the sandbox: scrubbed environment, jail as working directory,
+// and CPU and memory limits where the OS supports them
+func sandboxCommand(cmd *exec.Cmd, sandbox SandboxConfig, jail string) {
+	os.MkdirAll(sandbox.CacheDir, 0700)
+	cmd.Env = sandbox.env(jail)
+	cmd.Dir = jail
+	limitCommand(cmd, sandbox)
+	// Don't wait on pipes held open by a child that outlived the kill
+	cmd.WaitDelay = 5 * time.Second
+}
+
+// sandboxError explains a compile the sandbox stopped, nil when it wasn't stopped by the sandbox
+func sandboxError(ctx context.Context, cmd *exec.Cmd, output string, err error, sandbox SandboxConfig) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("compile took longer than %s and was stopped", sandbox.Timeout())
+	}
+	if err == nil || cmd.ProcessState == nil {
+		return nil
+	}
+	// Going over the CPU rlimit ends in SIGXCPU or SIGKILL, the CPU time tells it apart from a crash
+	used := cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	if sandbox.CPUSec > 0 && used >= time.Duration(sandbox.CPUSec)*time.Second-100*time.Millisecond {
+		return fmt.Errorf("compile used more than %ds of CPU time and was stopped", sandbox.CPUSec)
+	}
+	if sandbox.MemoryMB > 0 && (strings.Contains(err.Error(), "signal: killed") || strings.Contains(strings.ToLower(output), "out of memory") ||
+		strings.Contains(strings.ToLower(output), "cannot allocate memory")) {
+		return fmt.Errorf("compile was killed, it may have used more than %dMB of memory: %w", sandbox.MemoryMB, err)
+	}
+	return nil
+}
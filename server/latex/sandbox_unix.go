@@ -0,0 +1,32 @@
+//go:build !windows
+
+package latex
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// limitCommand applies the CPU and memory rlimits through the shell's ulimit, and puts the
+// engine in its own process group so a timeout kills latexmk and everything it started
+func limitCommand(cmd *exec.Cmd, sandbox SandboxConfig) {
+	var limits string
+	if sandbox.CPUSec > 0 {
+		limits += fmt.Sprintf("ulimit -t %d && ", sandbox.CPUSec)
+	}
+	if sandbox.MemoryMB > 0 {
+		limits += fmt.Sprintf("ulimit -v %d && ", sandbox.MemoryMB*1024)
+	}
+	if limits != "" {
+		if sh, err := exec.LookPath("sh"); err == nil {
+			cmd.Args = append([]string{"sh", "-c", limits + `exec "$0" "$@"`, cmd.Path}, cmd.Args[1:]...)
+			cmd.Path = sh
+		}
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,6 @@
+package latex
+
+import "os/exec"
+
+// limitCommand does nothing on Windows, there is no ulimit. The timeout still applies
+func limitCommand(cmd *exec.Cmd, sandbox SandboxConfig) {}
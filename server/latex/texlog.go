@@ -0,0 +1,217 @@
+package latex
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Error classes of compile diagnostics
+const (
+	ClassUndefinedControlSequence = "undefined-control-sequence"
+	ClassMissingDollar            = "missing-dollar"
+	ClassRunawayArgument          = "runaway-argument"
+	ClassMissingPackage           = "missing-package"
+	ClassMissingFile              = "missing-file"
+	ClassUndefinedEnvironment     = "undefined-environment"
+	ClassEnvironmentMismatch      = "environment-mismatch"
+	ClassBraceMismatch            = "brace-mismatch"
+	ClassAlignment                = "alignment"
+	ClassOther                    = "other"
+)
+
+// CompileDiagnostic is one error from a TeX log
+// Context holds the source lines around Line, the offending one marked with ">"
+type CompileDiagnostic struct {
+	File    string   `json:"file"`
+	Line    int      `json:"line"`
+	Class   string   `json:"class"`
+	Message string   `json:"message"`
+	Token   string   `json:"token,omitempty"`
+	Context []string `json:"context,omitempty"`
+}
+
+// String formats a diagnostic on one line, for logs and prompts
+func (d CompileDiagnostic) String() string {
+	s := fmt.Sprintf("%s:%d: [%s] %s", d.File, d.Line, d.Class, d.Message)
+	if d.Token != "" {
+		s += " (offending token: " + d.Token + ")"
+	}
+	return s
+}
+
+// CompileError is returned when the TeX engine fails, it keeps the raw output
+// and the diagnostics parsed from it
+type CompileError struct {
+	Engine      string
+	Err         error
+	Output      string
+	Diagnostics []CompileDiagnostic
+}
+
+func (e *CompileError) Error() string {
+	engine := e.Engine
+	if engine == "" {
+		engine = "Engine"
+	}
+	return fmt.Sprintf("%v\n%s output:\n%s", e.Err, engine, e.Output)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// CompileDiagnostics returns the diagnostics carried by a compile error, if any
+func CompileDiagnostics(err error) []CompileDiagnostic {
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) {
+		return compileErr.Diagnostics
+	}
+	return nil
+}
+
+var (
+	logLinePattern     = regexp.MustCompile(`^l\.(\d+) ?(.*)$`)
+	tectonicPattern    = regexp.MustCompile(`^error: (.+?):(\d+): (.*)$`)
+	fileLinePattern    = regexp.MustCompile(`^(?:\./)?([^\s:]+\.(?:tex|sty|cls)):(\d+): (.*)$`)
+	missingFilePattern = regexp.MustCompile("File `([^']+)' not found")
+	undefinedEnvRegexp = regexp.MustCompile(`Environment (\S+) undefined`)
+	lastCommandPattern = regexp.MustCompile(`\\[A-Za-z@]+\*?$|\\.$`)
+)
+
+// ParseTeXLog turns TeX log output into diagnostics, the same way for every engine
+// It understands the "! message" blocks of a .log file (with their l.<line> context),
+// "file:line: message" errors from TeX Live's -file-line-error and Tectonic's
+// "error: file:line: message" summaries. file names the main document
+func ParseTeXLog(output, file string) []CompileDiagnostic {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	var diagnostics []CompileDiagnostic
+	// Two undefined commands on one line are two errors, but Tectonic's one line summary of an
+	// error the .log already explained (without the token) is not
+	add := func(d CompileDiagnostic) {
+		for k := range diagnostics {
+			prev := &diagnostics[k]
+			if prev.Line != d.Line || prev.Class != d.Class {
+				continue
+			}
+			if prev.Token == d.Token || d.Token == "" {
+				return
+			}
+			if prev.Token == "" {
+				*prev = d
+				return
+			}
+		}
+		diagnostics = append(diagnostics, d)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "! ") {
+			message := strings.TrimSpace(strings.TrimPrefix(line, "! "))
+			if (message == "Emergency stop." || strings.HasPrefix(message, "==> Fatal error")) && len(diagnostics) > 0 {
+				continue
+			}
+			runaway := i > 0 && strings.HasPrefix(lines[i-1], "Runaway argument?")
+			d := CompileDiagnostic{File: file, Message: message, Class: classifyTeXError(message, runaway)}
+
+			// The l.<n> line has the source up to the error, the line after it the rest
+			for j := i + 1; j < len(lines) && j <= i+12; j++ {
+				if strings.HasPrefix(lines[j], "! ") {
+					break
+				}
+				if m := logLinePattern.FindStringSubmatch(lines[j]); m != nil {
+					d.Line, _ = strconv.Atoi(m[1])
+					if d.Class == ClassUndefinedControlSequence {
+						d.Token = lastCommandPattern.FindString(strings.TrimRight(m[2], " "))
+					}
+					break
+				}
+			}
+			if m := missingFilePattern.FindStringSubmatch(message); m != nil {
+				d.Token = m[1]
+			}
+			if m := undefinedEnvRegexp.FindStringSubmatch(message); m != nil {
+				d.Token = m[1]
+			}
+			add(d)
+			continue
+		}
+
+		m := tectonicPattern.FindStringSubmatch(line)
+		if m == nil {
+			m = fileLinePattern.FindStringSubmatch(line)
+		}
+		if m != nil {
+			lineNo, _ := strconv.Atoi(m[2])
+			add(CompileDiagnostic{File: m[1], Line: lineNo, Message: m[3], Class: classifyTeXError(m[3], false)})
+		}
+	}
+	return diagnostics
+}
+
+// classifyTeXError maps a TeX error message to a class
+func classifyTeXError(message string, runaway bool) string {
+	switch {
+	case runaway || strings.HasPrefix(message, "Paragraph ended before") || strings.HasPrefix(message, "File ended while scanning"):
+		return ClassRunawayArgument
+	case strings.HasPrefix(message, "Undefined control sequence"):
+		return ClassUndefinedControlSequence
+	case strings.HasPrefix(message, "Missing $ inserted") || strings.HasPrefix(message, "Display math should end with $$") || strings.Contains(message, "forgotten $"):
+		return ClassMissingDollar
+	case strings.Contains(message, ".sty' not found"):
+		return ClassMissingPackage
+	case missingFilePattern.MatchString(message):
+		return ClassMissingFile
+	case undefinedEnvRegexp.MatchString(message):
+		return ClassUndefinedEnvironment
+	case strings.Contains(message, "ended by \\end"):
+		return ClassEnvironmentMismatch
+	case strings.HasPrefix(message, "Missing } inserted") || strings.HasPrefix(message, "Missing { inserted") || strings.HasPrefix(message, "Too many }'s") || strings.HasPrefix(message, "Extra }"):
+		return ClassBraceMismatch
+	case strings.Contains(message, "alignment tab"):
+		return ClassAlignment
+	default:
+		return ClassOther
+	}
+}
+
+// attachSourceContext fills in Context with the source lines around each diagnostic
+func attachSourceContext(diagnostics []CompileDiagnostic, source string) {
+	lines := strings.Split(source, "\n")
+	for i := range diagnostics {
+		d := &diagnostics[i]
+		if d.Line <= 0 || d.Line > len(lines) {
+			continue
+		}
+		d.Context = nil
+		for n := d.Line - 2; n <= d.Line+2; n++ {
+			if n < 1 || n > len(lines) {
+				continue
+			}
+			marker := " "
+			if n == d.Line {
+				marker = ">"
+			}
+			d.Context = append(d.Context, fmt.Sprintf("%s %4d | %s", marker, n, lines[n-1]))
+		}
+	}
+}
+
+// FormatCompileDiagnostics lists compile diagnostics with their context, for the fixer prompt
+func FormatCompileDiagnostics(diagnostics []CompileDiagnostic) string {
+	var b strings.Builder
+	for i, d := range diagnostics {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(d.String())
+		b.WriteString("\n")
+		for _, line := range d.Context {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return b.String()
+}
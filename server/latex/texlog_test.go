@@ -0,0 +1,69 @@
+package latex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTeXLog(t *testing.T) {
+	tests := []struct {
+		log  string
+		want []CompileDiagnostic
+	}{
+		{
+			// Two undefined commands on one line are both reported, the repeated missing $ once
+			log: "pdflatex-undefined.log",
+			want: []CompileDiagnostic{
+				{File: "job.tex", Line: 7, Class: ClassUndefinedControlSequence, Token: `\sqaure`},
+				{File: "job.tex", Line: 7, Class: ClassUndefinedControlSequence, Token: `\fracc`},
+				{File: "job.tex", Line: 12, Class: ClassMissingDollar},
+			},
+		},
+		{
+			log: "latexmk-package.log",
+			want: []CompileDiagnostic{
+				{File: "job.tex", Class: ClassMissingPackage, Token: "tikzz.sty"},
+			},
+		},
+		{
+			log: "latexmk-environment.log",
+			want: []CompileDiagnostic{
+				{File: "job.tex", Line: 9, Class: ClassUndefinedEnvironment, Token: "enumerat"},
+			},
+		},
+		{
+			// The console summary repeats the error of the .log without its token
+			log: "tectonic-undefined.log",
+			want: []CompileDiagnostic{
+				{File: "job.tex", Line: 15, Class: ClassUndefinedControlSequence, Token: `\textbff`},
+			},
+		},
+		{
+			log: "tectonic-console.log",
+			want: []CompileDiagnostic{
+				{File: "job.tex", Line: 21, Class: ClassMissingDollar},
+				{File: "job.tex", Line: 30, Class: ClassAlignment},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.log, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "texlog", tt.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ParseTeXLog(string(data), "job.tex")
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d:\n%s", len(got), len(tt.want), FormatCompileDiagnostics(got))
+			}
+			for i, want := range tt.want {
+				d := got[i]
+				if d.File != want.File || d.Line != want.Line || d.Class != want.Class || d.Token != want.Token {
+					t.Errorf("diagnostic %d = %s, want %s:%d [%s] token %q", i, d, want.File, want.Line, want.Class, want.Token)
+				}
+			}
+		})
+	}
+}
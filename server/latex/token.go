@@ -0,0 +1,209 @@
+package latex
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind is the kind of a LaTeX token
+type TokenKind int
+
+const (
+	TokenText TokenKind = iota
+	TokenCommand
+	TokenOpenBrace
+	TokenCloseBrace
+	TokenMathShift // $ or $$
+	TokenMathOpen  // \( or \[
+	TokenMathClose // \) or \]
+	TokenComment
+	TokenVerbatim
+)
+
+// Position is a place in the source, Line and Column start at 1
+// Column counts runes, not bytes
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Token is one lexical unit of a LaTeX source
+// For commands Value is the name without the backslash, for everything else the raw text
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Pos   Position
+	End   Position
+}
+
+// verbatimEnvironments have their body kept as is, nothing inside them is parsed
+var verbatimEnvironments = map[string]bool{
+	"verbatim":   true,
+	"verbatim*":  true,
+	"Verbatim":   true,
+	"lstlisting": true,
+	"minted":     true,
+	"comment":    true,
+}
+
+// tokenizer walks a source keeping track of line and column
+type tokenizer struct {
+	src    string
+	pos    Position
+	tokens []Token
+}
+
+// Tokenize splits a LaTeX source into tokens
+// Brackets are single character text tokens so the parser can pick up optional arguments
+func Tokenize(src string) []Token {
+	t := &tokenizer{src: src, pos: Position{Line: 1, Column: 1}}
+	for t.pos.Offset < len(src) {
+		t.next()
+	}
+	return t.tokens
+}
+
+// advance moves past n bytes
+func (t *tokenizer) advance(n int) {
+	for _, r := range t.src[t.pos.Offset : t.pos.Offset+n] {
+		if r == '\n' {
+			t.pos.Line++
+			t.pos.Column = 1
+		} else {
+			t.pos.Column++
+		}
+	}
+	t.pos.Offset += n
+}
+
+// emit adds a token spanning the next n bytes
+func (t *tokenizer) emit(kind TokenKind, value string, n int) {
+	start := t.pos
+	t.advance(n)
+	t.tokens = append(t.tokens, Token{Kind: kind, Value: value, Pos: start, End: t.pos})
+}
+
+func (t *tokenizer) next() {
+	rest := t.src[t.pos.Offset:]
+	switch rest[0] {
+	case '%':
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		t.emit(TokenComment, rest[:end], end)
+	case '{':
+		t.emit(TokenOpenBrace, "{", 1)
+	case '}':
+		t.emit(TokenCloseBrace, "}", 1)
+	case '[', ']':
+		t.emit(TokenText, rest[:1], 1)
+	case '$':
+		if strings.HasPrefix(rest, "$$") {
+			t.emit(TokenMathShift, "$$", 2)
+		} else {
+			t.emit(TokenMathShift, "$", 1)
+		}
+	case '\\':
+		t.command(rest)
+	default:
+		end := strings.IndexAny(rest, "%{}[]$\\")
+		if end < 0 {
+			end = len(rest)
+		}
+		t.emit(TokenText, rest[:end], end)
+	}
+}
+
+// command reads a control word (\section) or control symbol (\\, \%, \[)
+func (t *tokenizer) command(rest string) {
+	if len(rest) == 1 {
+		t.emit(TokenText, rest, 1)
+		return
+	}
+
+	n := 1
+	for n < len(rest) && isLetter(rest[n]) {
+		n++
+	}
+	if n == 1 {
+		// Control symbol, one character after the backslash
+		switch rest[1] {
+		case '(', '[':
+			t.emit(TokenMathOpen, rest[:2], 2)
+		case ')', ']':
+			t.emit(TokenMathClose, rest[:2], 2)
+		default:
+			size := len(string([]rune(rest[1:])[0])) + 1
+			t.emit(TokenCommand, rest[1:size], size)
+		}
+		return
+	}
+
+	name := rest[1:n]
+	// A starred command (\section*) keeps the star in its name
+	if n < len(rest) && rest[n] == '*' {
+		n++
+		name += "*"
+	}
+	t.emit(TokenCommand, name, n)
+
+	switch name {
+	case "verb", "verb*":
+		t.verb()
+	case "begin":
+		t.verbatimEnvironment()
+	}
+}
+
+// verb reads the delimited argument of \verb as verbatim text
+func (t *tokenizer) verb() {
+	rest := t.src[t.pos.Offset:]
+	if rest == "" {
+		return
+	}
+	delim := rest[0]
+	end := strings.IndexByte(rest[1:], delim)
+	if newline := strings.IndexByte(rest[1:], '\n'); end < 0 || (newline >= 0 && newline < end) {
+		// Unterminated, stop at the end of the line like TeX does
+		end = newline
+		if end < 0 {
+			end = len(rest) - 1
+		}
+		t.emit(TokenVerbatim, rest[:end+1], end+1)
+		return
+	}
+	t.emit(TokenVerbatim, rest[:end+2], end+2)
+}
+
+// verbatimEnvironment reads the body of \begin{verbatim} and friends as one token
+// The \begin and \end themselves are tokenized normally
+func (t *tokenizer) verbatimEnvironment() {
+	rest := t.src[t.pos.Offset:]
+	if !strings.HasPrefix(rest, "{") {
+		return
+	}
+	close := strings.IndexByte(rest, '}')
+	if close < 0 || !verbatimEnvironments[rest[1:close]] {
+		return
+	}
+	name := rest[1:close]
+
+	t.emit(TokenOpenBrace, "{", 1)
+	t.emit(TokenText, name, len(name))
+	t.emit(TokenCloseBrace, "}", 1)
+
+	rest = t.src[t.pos.Offset:]
+	end := strings.Index(rest, "\\end{"+name+"}")
+	if end < 0 {
+		end = len(rest)
+	}
+	if end > 0 {
+		t.emit(TokenVerbatim, rest[:end], end)
+	}
+}
+
+func isLetter(b byte) bool {
+	return b < 0x80 && unicode.IsLetter(rune(b)) || b == '@'
+}
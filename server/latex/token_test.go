@@ -0,0 +1,103 @@
+package latex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var tokenKindNames = map[TokenKind]string{
+	TokenText:       "text",
+	TokenCommand:    "cmd",
+	TokenOpenBrace:  "{",
+	TokenCloseBrace: "}",
+	TokenMathShift:  "shift",
+	TokenMathOpen:   "open",
+	TokenMathClose:  "close",
+	TokenComment:    "comment",
+	TokenVerbatim:   "verb",
+}
+
+// tokenString writes tokens as kind:value@line:column, one per line
+func tokenString(tokens []Token) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		fmt.Fprintf(&b, "%s:%q@%d:%d\n", tokenKindNames[tok.Kind], tok.Value, tok.Pos.Line, tok.Pos.Column)
+	}
+	return b.String()
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "commands and groups",
+			src:  `\section*{Intro} text`,
+			want: "cmd:\"section*\"@1:1\n{:\"{\"@1:10\ntext:\"Intro\"@1:11\n}:\"}\"@1:16\ntext:\" text\"@1:17\n",
+		},
+		{
+			name: "control symbols",
+			src:  `50\% \\ \&`,
+			want: "text:\"50\"@1:1\ncmd:\"%\"@1:3\ntext:\" \"@1:5\ncmd:\"\\\\\"@1:6\ntext:\" \"@1:8\ncmd:\"&\"@1:9\n",
+		},
+		{
+			name: "at is a letter",
+			src:  `\@@input x`,
+			want: "cmd:\"@@input\"@1:1\ntext:\" x\"@1:9\n",
+		},
+		{
+			name: "math delimiters",
+			src:  `$a$ $$b$$ \(c\) \[d\]`,
+			want: "shift:\"$\"@1:1\ntext:\"a\"@1:2\nshift:\"$\"@1:3\ntext:\" \"@1:4\nshift:\"$$\"@1:5\ntext:\"b\"@1:7\nshift:\"$$\"@1:8\ntext:\" \"@1:10\n" +
+				"open:\"\\\\(\"@1:11\ntext:\"c\"@1:13\nclose:\"\\\\)\"@1:14\ntext:\" \"@1:16\nopen:\"\\\\[\"@1:17\ntext:\"d\"@1:19\nclose:\"\\\\]\"@1:20\n",
+		},
+		{
+			name: "comments and brackets",
+			src:  "a % note {\n[b]",
+			want: "text:\"a \"@1:1\ncomment:\"% note {\"@1:3\ntext:\"\\n\"@1:11\ntext:\"[\"@2:1\ntext:\"b\"@2:2\ntext:\"]\"@2:3\n",
+		},
+		{
+			name: "verb",
+			src:  `\verb|\x{|y`,
+			want: "cmd:\"verb\"@1:1\nverb:\"|\\\\x{|\"@1:6\ntext:\"y\"@1:11\n",
+		},
+		{
+			name: "unterminated verb stops at the line end",
+			src:  "\\verb|a\nb",
+			want: "cmd:\"verb\"@1:1\nverb:\"|a\"@1:6\ntext:\"\\nb\"@1:8\n",
+		},
+		{
+			name: "verbatim environment",
+			src:  "\\begin{verbatim}\n$ { %\n\\end{verbatim}",
+			want: "cmd:\"begin\"@1:1\n{:\"{\"@1:7\ntext:\"verbatim\"@1:8\n}:\"}\"@1:16\nverb:\"\\n$ { %\\n\"@1:17\n" +
+				"cmd:\"end\"@3:1\n{:\"{\"@3:5\ntext:\"verbatim\"@3:6\n}:\"}\"@3:14\n",
+		},
+		{
+			name: "columns count runes",
+			src:  "é\\x",
+			want: "text:\"é\"@1:1\ncmd:\"x\"@1:2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenString(Tokenize(tt.src)); got != tt.want {
+				t.Errorf("Tokenize(%q) =\n%s\nwant\n%s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeCoversSource(t *testing.T) {
+	src := "\\documentclass{article}\n\\begin{document}\n% c\n$x^2$ \\verb|y| \\[z\\]\n\\end{document}\n"
+	var b strings.Builder
+	for _, tok := range Tokenize(src) {
+		b.WriteString(src[tok.Pos.Offset:tok.End.Offset])
+	}
+	if b.String() != src {
+		t.Errorf("tokens don't cover the source:\n%s", b.String())
+	}
+}
@@ -26,7 +26,13 @@ func init() {
         queue_dir = "./storage/queue_data" // Fallback to default
     }
     
-    sheet.GlobalSheetGenerator, err = sheet.NewSheetGenerator(nil, queue_dir, 2)
+    // Workers sleep until a job is enqueued, so more of them cost nothing while idle
+    workers := 2
+    if n, ok := config.GetConfigValue("SHEET_WORKERS").(float64); ok && n > 0 {
+        workers = int(n)
+    }
+
+    sheet.GlobalSheetGenerator, err = sheet.NewSheetGenerator(nil, queue_dir, workers)
     if err != nil {
         logg.Error(fmt.Sprintf("Failed to initialize GlobalSheetGenerator: %v", err))
         logg.Exit()
@@ -0,0 +1,57 @@
+package prompts
+
+import (
+	"context"
+	"sync"
+)
+
+// selection carries the pinned versions of a job and collects the versions it used
+type selection struct {
+	pins map[string]int
+	mu   sync.Mutex
+	used map[string]int
+}
+
+type selectionKey struct{}
+
+// WithPins attaches pinned template versions (name -> version) to the context
+// Everything rendered through RenderCtx with it is recorded for Used
+func WithPins(ctx context.Context, pins map[string]int) context.Context {
+	return context.WithValue(ctx, selectionKey{}, &selection{pins: pins, used: make(map[string]int)})
+}
+
+// RenderCtx is Render with the version pinned in ctx, if any
+func RenderCtx(ctx context.Context, name string, data interface{}) (*Rendered, error) {
+	sel, _ := ctx.Value(selectionKey{}).(*selection)
+	pinned := 0
+	if sel != nil {
+		pinned = sel.pins[name]
+	}
+
+	rendered, err := Render(name, pinned, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if sel != nil {
+		sel.mu.Lock()
+		sel.used[name] = rendered.Version
+		sel.mu.Unlock()
+	}
+	return rendered, nil
+}
+
+// Used returns the template versions rendered with ctx so far
+func Used(ctx context.Context) map[string]int {
+	sel, _ := ctx.Value(selectionKey{}).(*selection)
+	if sel == nil {
+		return nil
+	}
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+	used := make(map[string]int, len(sel.used))
+	for name, version := range sel.used {
+		used[name] = version
+	}
+	return used
+}
@@ -0,0 +1,279 @@
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+)
+
+// Template names, one per prompt the server sends
+const (
+	Sheet           = "sheet"
+	Fix             = "fix"
+	FixPatch        = "fix-patch"
+	Tags            = "tags"
+	Subject         = "subject"
+	SubjectTags     = "subject-tags"
+	Course          = "course"
+	CourseTags      = "course-tags"
+	Description     = "description"
+	DescriptionTags = "description-tags"
+)
+
+// Where a template version came from
+const (
+	SourceBuiltin = "builtin"
+	SourceDisk    = "disk"
+	SourceStore   = "store"
+)
+
+// DefaultDir is used when PROMPTS_DIR is not set
+// Files are laid out as <dir>/<name>/v<version>.tmpl
+const DefaultDir = "./storage/prompts"
+
+//go:embed defaults/*.tmpl
+var defaults embed.FS
+
+var diskVersionPattern = regexp.MustCompile(`^v(\d+)\.tmpl$`)
+
+// funcs are available to every template
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// Rendered is a template executed for one request
+type Rendered struct {
+	Name    string
+	Version int
+	System  string
+	User    string
+}
+
+// Names lists every built in template
+func Names() []string {
+	entries, err := defaults.ReadDir("defaults")
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".tmpl"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Versions returns every version of a template, oldest first
+// Version 1 is built in, disk files and store entries add later versions
+// A store entry wins over a disk file with the same version
+func Versions(name string) ([]store.PromptTemplate, error) {
+	body, err := defaults.ReadFile("defaults/" + name + ".tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("unknown prompt template %q", name)
+	}
+
+	byVersion := map[int]store.PromptTemplate{
+		1: {Name: name, Version: 1, Body: string(body), Source: SourceBuiltin},
+	}
+
+	dir := filepath.Join(promptsDir(), name)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read prompt directory %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		match := diskVersionPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt %s: %w", entry.Name(), err)
+		}
+		info, _ := entry.Info()
+		created := time.Time{}
+		if info != nil {
+			created = info.ModTime()
+		}
+		byVersion[version] = store.PromptTemplate{Name: name, Version: version, Body: string(data), Source: SourceDisk, CreatedAt: created}
+	}
+
+	if db.PromptsDB != nil {
+		stored, err := store.GetPromptTemplates(db.PromptsDB, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored prompts: %w", err)
+		}
+		for _, t := range stored {
+			t.Source = SourceStore
+			byVersion[t.Version] = t
+		}
+	}
+
+	versions := make([]store.PromptTemplate, 0, len(byVersion))
+	for _, t := range byVersion {
+		versions = append(versions, t)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// Get returns one version of a template
+func Get(name string, version int) (*store.PromptTemplate, error) {
+	versions, err := Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range versions {
+		if t.Version == version {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("prompt %s has no version %d", name, version)
+}
+
+// ActiveVersion returns the version used when nothing is pinned
+// That is the version an admin switched to, otherwise the newest one
+func ActiveVersion(name string) (int, error) {
+	versions, err := Versions(name)
+	if err != nil {
+		return 0, err
+	}
+	if db.PromptsDB != nil {
+		if active, err := store.GetActivePromptVersions(db.PromptsDB); err == nil {
+			if version, ok := active[name]; ok {
+				for _, t := range versions {
+					if t.Version == version {
+						return version, nil
+					}
+				}
+			}
+		}
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+// Save stores a new version of a template, checking that it parses first
+func Save(name, body, description, createdBy string, activate bool) (*store.PromptTemplate, error) {
+	if db.PromptsDB == nil {
+		return nil, fmt.Errorf("prompts DB not initialized")
+	}
+	versions, err := Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parse(name, body); err != nil {
+		return nil, err
+	}
+
+	// The store picks the final version under its lock, this only keeps it past the disk versions
+	t := store.PromptTemplate{
+		Name:        name,
+		Version:     versions[len(versions)-1].Version + 1,
+		Body:        body,
+		Description: description,
+		Source:      SourceStore,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+	}
+	t, err = store.AddNextPromptTemplate(db.PromptsDB, t, activate)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Activate switches the default version of a template
+func Activate(name string, version int) error {
+	if db.PromptsDB == nil {
+		return fmt.Errorf("prompts DB not initialized")
+	}
+	if _, err := Get(name, version); err != nil {
+		return err
+	}
+	return store.SetActivePromptVersion(db.PromptsDB, name, version)
+}
+
+// Render executes the "system" and "user" blocks of a template
+// The pinned version is used when one is given (0 means the active version)
+// A version that fails to render falls back to the built in one so a bad edit can't stop generation
+// A pinned version that no longer exists is an error
+func Render(name string, pinned int, data interface{}) (*Rendered, error) {
+	version := pinned
+	if version == 0 {
+		active, err := ActiveVersion(name)
+		if err != nil {
+			return nil, err
+		}
+		version = active
+	}
+
+	t, err := Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := renderTemplate(t, data)
+	if err != nil && t.Source != SourceBuiltin {
+		log.Printf("[WARNING] Prompt %s v%d failed, using the built in version: %v", name, version, err)
+		body, _ := defaults.ReadFile("defaults/" + name + ".tmpl")
+		rendered, err = renderTemplate(&store.PromptTemplate{Name: name, Version: 1, Body: string(body), Source: SourceBuiltin}, data)
+	}
+	return rendered, err
+}
+
+// renderTemplate executes the "system" and "user" blocks of one template version
+func renderTemplate(t *store.PromptTemplate, data interface{}) (*Rendered, error) {
+	tmpl, err := parse(t.Name, t.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &Rendered{Name: t.Name, Version: t.Version}
+	for block, out := range map[string]*string{"system": &rendered.System, "user": &rendered.User} {
+		if tmpl.Lookup(block) == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, block, data); err != nil {
+			return nil, fmt.Errorf("failed to render prompt %s v%d: %w", t.Name, t.Version, err)
+		}
+		*out = buf.String()
+	}
+	return rendered, nil
+}
+
+// parse checks a template body, it must define a "user" block
+func parse(name, body string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template %s: %w", name, err)
+	}
+	if tmpl.Lookup("user") == nil {
+		return nil, fmt.Errorf("prompt template %s must define a \"user\" block", name)
+	}
+	return tmpl, nil
+}
+
+// promptsDir returns PROMPTS_DIR or the default
+func promptsDir() string {
+	if dir, ok := config.GetConfigValue("PROMPTS_DIR").(string); ok && dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"nadhi.dev/sarvar/fun/auth"
+	"nadhi.dev/sarvar/fun/config"
+)
+
+// Endpoint classes, every class has its own limit per rank
+const (
+	ClassDefault = "default"
+	ClassHelpers = "helpers"
+	ClassCreate  = "create"
+)
+
+// RankAnonymous is used for requests without a session, they are keyed by IP
+const RankAnonymous = "anonymous"
+
+// Limit is a token bucket: Burst requests at once, refilled at PerMinute
+type Limit struct {
+	Burst     int     `json:"burst"`
+	PerMinute float64 `json:"perMinute"`
+}
+
+// defaultLimits apply when RATE_LIMITS does not set a rank or class
+// Unknown ranks get the "user" limits
+var defaultLimits = map[string]map[string]Limit{
+	RankAnonymous: {
+		ClassDefault: {Burst: 20, PerMinute: 30},
+		ClassHelpers: {Burst: 2, PerMinute: 6},
+		ClassCreate:  {Burst: 1, PerMinute: 1},
+	},
+	"user": {
+		ClassDefault: {Burst: 60, PerMinute: 120},
+		ClassHelpers: {Burst: 5, PerMinute: 30},
+		ClassCreate:  {Burst: 3, PerMinute: 6},
+	},
+	"admin": {
+		ClassDefault: {Burst: 300, PerMinute: 600},
+		ClassHelpers: {Burst: 30, PerMinute: 120},
+		ClassCreate:  {Burst: 10, PerMinute: 30},
+	},
+}
+
+// Buckets nobody touched for this long are full again and can be dropped
+const idleTimeout = 10 * time.Minute
+
+// bucket holds the tokens of one user on one endpoint
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	lastSeen time.Time
+}
+
+var (
+	mu        sync.Mutex
+	buckets   = make(map[string]*bucket)
+	lastSweep time.Time
+)
+
+// clock and decodeConfig are replaced in tests
+var (
+	clock        = time.Now
+	decodeConfig = config.DecodeConfigValue
+)
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Time
+}
+
+// LimitFor returns the limit of a rank on an endpoint class, RATE_LIMITS entries win over the defaults
+func LimitFor(rank, class string) Limit {
+	var overrides map[string]map[string]Limit
+	if decodeConfig("RATE_LIMITS", &overrides) {
+		if limit, ok := overrides[rank][class]; ok && limit.Burst > 0 && limit.PerMinute > 0 {
+			return limit
+		}
+	}
+	if limits, ok := defaultLimits[rank]; ok {
+		return limits[class]
+	}
+	return defaultLimits["user"][class]
+}
+
+// Take removes one token from the bucket under key
+func Take(key string, limit Limit) Result {
+	now := clock()
+	rate := limit.PerMinute / 60
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if now.Sub(lastSweep) > idleTimeout {
+		for k, b := range buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(buckets, k)
+			}
+		}
+		lastSweep = now
+	}
+
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	b.lastSeen = now
+
+	result := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / rate * float64(time.Second)))
+	return result
+}
+
+// Refund gives a token back, used for requests that turned out to cost nothing
+func Refund(key string, limit Limit) {
+	mu.Lock()
+	defer mu.Unlock()
+	if b, ok := buckets[key]; ok {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+}
+
+// Classify maps a request to its endpoint class and the endpoint its bucket is kept for
+// Everything outside the AI endpoints shares one bucket per user
+func Classify(method, path string) (class, endpoint string) {
+	switch {
+	case method == fiber.MethodPost && path == "/api/v1/sheets/create":
+		return ClassCreate, path
+	case method == fiber.MethodPost && strings.HasPrefix(path, "/api/v1/sheets/generate-"):
+		return ClassHelpers, path
+	default:
+		return ClassDefault, ClassDefault
+	}
+}
+
+// Middleware limits every /api/v1 request per user and endpoint
+// Responses marked X-Cache: HIT are refunded, a cached helper answer costs nothing
+func Middleware(c *fiber.Ctx) error {
+	identity, rank := RankAnonymous+":"+c.IP(), RankAnonymous
+	if header := c.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		if user, err := auth.GetUserBySession(header[7:]); err == nil && user != nil {
+			identity, rank = user.Username, user.Rank
+		}
+	}
+
+	class, endpoint := Classify(c.Method(), c.Path())
+	limit := LimitFor(rank, class)
+	key := identity + "|" + endpoint
+	result := Take(key, limit)
+
+	c.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(429).JSON(fiber.Map{
+			"error":      "Too many requests, please wait",
+			"retryAfter": retryAfter,
+		})
+	}
+
+	err := c.Next()
+	if string(c.Response().Header.Peek("X-Cache")) == "HIT" {
+		Refund(key, limit)
+	}
+	return err
+}
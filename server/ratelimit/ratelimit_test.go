@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeClock is a clock the test moves by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// setup starts every test with no buckets, a fake clock and the given RATE_LIMITS
+func setup(t *testing.T, rateLimits string) *fakeClock {
+	fake := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	mu.Lock()
+	buckets = make(map[string]*bucket)
+	lastSweep = time.Time{}
+	mu.Unlock()
+	realClock, realDecode := clock, decodeConfig
+	clock = fake.Now
+	decodeConfig = func(key string, out interface{}) bool {
+		if key != "RATE_LIMITS" || rateLimits == "" {
+			return false
+		}
+		return json.Unmarshal([]byte(rateLimits), out) == nil
+	}
+	t.Cleanup(func() {
+		clock, decodeConfig = realClock, realDecode
+	})
+	return fake
+}
+
+func TestTakeRefill(t *testing.T) {
+	fake := setup(t, "")
+	limit := Limit{Burst: 3, PerMinute: 6} // a token every 10 seconds
+
+	for i := 2; i >= 0; i-- {
+		result := Take("k", limit)
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("take %d: %+v, want allowed with %d remaining", 3-i, result, i)
+		}
+	}
+	result := Take("k", limit)
+	if result.Allowed {
+		t.Fatalf("take past the burst was allowed: %+v", result)
+	}
+	if result.RetryAfter != 10*time.Second {
+		t.Errorf("RetryAfter = %v, want 10s", result.RetryAfter)
+	}
+	if want := fake.now.Add(30 * time.Second); !result.Reset.Equal(want) {
+		t.Errorf("Reset = %v, want %v", result.Reset, want)
+	}
+
+	// Half a token isn't enough
+	fake.Advance(5 * time.Second)
+	if result := Take("k", limit); result.Allowed || result.RetryAfter != 5*time.Second {
+		t.Errorf("after 5s: %+v, want refused with 5s to wait", result)
+	}
+	fake.Advance(5 * time.Second)
+	if result := Take("k", limit); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after 10s: %+v, want allowed with 0 remaining", result)
+	}
+
+	// The bucket never holds more than the burst
+	fake.Advance(time.Hour)
+	if result := Take("k", limit); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("after an hour: %+v, want allowed with 2 remaining", result)
+	}
+
+	// Other keys have their own bucket
+	if result := Take("other", limit); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("other key: %+v, want allowed with 2 remaining", result)
+	}
+}
+
+func TestTakeSweepsIdleBuckets(t *testing.T) {
+	fake := setup(t, "")
+	limit := Limit{Burst: 1, PerMinute: 1}
+	Take("idle", limit)
+	fake.Advance(idleTimeout + time.Second)
+	Take("active", limit)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := buckets["idle"]; ok {
+		t.Error("idle bucket was kept")
+	}
+	if _, ok := buckets["active"]; !ok {
+		t.Error("active bucket was dropped")
+	}
+}
+
+func TestLimitFor(t *testing.T) {
+	rateLimits := `{
+		"user": {"create": {"burst": 7, "perMinute": 14}},
+		"teacher": {"helpers": {"burst": 9, "perMinute": 90}},
+		"admin": {"default": {"burst": 0, "perMinute": 10}}
+	}`
+	tests := []struct {
+		name        string
+		rateLimits  string
+		rank, class string
+		want        Limit
+	}{
+		{"default", "", "user", ClassCreate, Limit{Burst: 3, PerMinute: 6}},
+		{"anonymous default", "", RankAnonymous, ClassHelpers, Limit{Burst: 2, PerMinute: 6}},
+		{"unknown rank gets user limits", "", "teacher", ClassDefault, Limit{Burst: 60, PerMinute: 120}},
+		{"override", rateLimits, "user", ClassCreate, Limit{Burst: 7, PerMinute: 14}},
+		{"other class keeps the default", rateLimits, "user", ClassHelpers, Limit{Burst: 5, PerMinute: 30}},
+		{"override for a new rank", rateLimits, "teacher", ClassHelpers, Limit{Burst: 9, PerMinute: 90}},
+		{"new rank falls back to user", rateLimits, "teacher", ClassCreate, Limit{Burst: 3, PerMinute: 6}},
+		{"zero burst is ignored", rateLimits, "admin", ClassDefault, Limit{Burst: 300, PerMinute: 600}},
+		{"malformed config", `{"user": []}`, "user", ClassCreate, Limit{Burst: 3, PerMinute: 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t, tt.rateLimits)
+			if got := LimitFor(tt.rank, tt.class); got != tt.want {
+				t.Errorf("LimitFor(%q, %q) = %+v, want %+v", tt.rank, tt.class, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRefundsCacheHits(t *testing.T) {
+	setup(t, `{"anonymous": {"helpers": {"burst": 2, "perMinute": 1}}}`)
+	app := fiber.New()
+	app.Use(Middleware)
+	app.Post("/api/v1/sheets/generate-hit", func(c *fiber.Ctx) error {
+		c.Set("X-Cache", "HIT")
+		return c.SendString("cached")
+	})
+	app.Post("/api/v1/sheets/generate-miss", func(c *fiber.Ctx) error {
+		c.Set("X-Cache", "MISS")
+		return c.SendString("generated")
+	})
+
+	status := func(path string) int {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 5; i++ {
+		if code := status("/api/v1/sheets/generate-hit"); code != 200 {
+			t.Fatalf("cache hit %d: status %d, want 200", i+1, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := status("/api/v1/sheets/generate-miss"); code != 200 {
+			t.Fatalf("miss %d: status %d, want 200", i+1, code)
+		}
+	}
+	if code := status("/api/v1/sheets/generate-miss"); code != 429 {
+		t.Errorf("miss past the burst: status %d, want 429", code)
+	}
+	// Hits share nothing with misses, they have their own endpoint bucket
+	if code := status("/api/v1/sheets/generate-hit"); code != 200 {
+		t.Errorf("hit after the misses: status %d, want 200", code)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"nadhi.dev/sarvar/fun/api-routes"
 	"nadhi.dev/sarvar/fun/auth"
+	"nadhi.dev/sarvar/fun/ratelimit"
 	"nadhi.dev/sarvar/fun/server"
 )
 
@@ -26,6 +27,8 @@ func Register() {
 	index()
 
 	health()
+	// Rate limit before auth so login and bad sessions are limited too
+	server.Route.Use("/api/v1", ratelimit.Middleware)
 	server.Route.Use("/api/v1", auth.CheckAuth)
 	api.Index()
 	api.KasmIndex()
@@ -35,6 +38,10 @@ func Register() {
 	api.SheetsIndex()
 	api.RegisterWebsocketRoutes()
 	api.Notebooks()
+	api.UsageIndex()
+	api.PromptsIndex()
+	api.HelperCacheIndex()
+	api.CompileCacheIndex()
 }
 
 /*
@@ -33,4 +33,13 @@ func init() {
     if err := db.InitNotebooksDB(); err != nil {
     logg.Error("Failed to initialize notebooks DB: ")
     }
+    if err := db.InitUsageDB(); err != nil {
+    logg.Error("Failed to initialize usage DB: ")
+    }
+    if err := db.InitPromptsDB(); err != nil {
+    logg.Error("Failed to initialize prompts DB: ")
+    }
+    if err := db.InitHelperCacheDB(); err != nil {
+    logg.Error("Failed to initialize helper cache DB: ")
+    }
 }
\ No newline at end of file
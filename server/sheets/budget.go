@@ -0,0 +1,91 @@
+package sheet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+)
+
+// ErrBudgetExceeded is returned when a user has used up their AI budget
+var ErrBudgetExceeded = errors.New("AI budget exceeded")
+
+// Budget caps the AI usage of a rank, a zero limit means unlimited
+type Budget struct {
+	DailyTokens   int     `json:"dailyTokens"`
+	MonthlyTokens int     `json:"monthlyTokens"`
+	DailyCost     float64 `json:"dailyCost"`
+	MonthlyCost   float64 `json:"monthlyCost"`
+}
+
+// defaultBudgets apply when AI_BUDGETS in set.json has no entry for a rank
+// A full sheet with a couple of fixes is around 20k tokens
+var defaultBudgets = map[string]Budget{
+	"user":  {DailyTokens: 200000, MonthlyTokens: 2000000, DailyCost: 1, MonthlyCost: 10},
+	"admin": {},
+}
+
+// BudgetForRank returns the budget of a rank, unknown ranks get the "user" budget
+func BudgetForRank(rank string) Budget {
+	var budgets map[string]Budget
+	if config.DecodeConfigValue("AI_BUDGETS", &budgets) {
+		if budget, ok := budgets[rank]; ok {
+			return budget
+		}
+	}
+	if budget, ok := defaultBudgets[rank]; ok {
+		return budget
+	}
+	return defaultBudgets["user"]
+}
+
+// CheckBudget returns an error wrapping ErrBudgetExceeded when the user is over their budget
+func CheckBudget(userID string) error {
+	if db.UsageDB == nil {
+		return nil
+	}
+
+	rank := "user"
+	if db.UsersDB != nil {
+		if user, err := store.GetUser(db.UsersDB, userID); err == nil && user != nil && user.Rank != "" {
+			rank = user.Rank
+		}
+	}
+	budget := BudgetForRank(rank)
+	if budget == (Budget{}) {
+		return nil
+	}
+
+	// The running totals, the records themselves are never read here
+	daily, monthly, err := store.GetUserUsage(db.UsageDB, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	switch {
+	case budget.DailyTokens > 0 && daily.TotalTokens >= budget.DailyTokens:
+		return fmt.Errorf("%w: daily limit of %d tokens reached", ErrBudgetExceeded, budget.DailyTokens)
+	case budget.DailyCost > 0 && daily.Cost >= budget.DailyCost:
+		return fmt.Errorf("%w: daily limit of $%.2f reached", ErrBudgetExceeded, budget.DailyCost)
+	case budget.MonthlyTokens > 0 && monthly.TotalTokens >= budget.MonthlyTokens:
+		return fmt.Errorf("%w: monthly limit of %d tokens reached", ErrBudgetExceeded, budget.MonthlyTokens)
+	case budget.MonthlyCost > 0 && monthly.Cost >= budget.MonthlyCost:
+		return fmt.Errorf("%w: monthly limit of $%.2f reached", ErrBudgetExceeded, budget.MonthlyCost)
+	}
+	return nil
+}
+
+// JobUsage sums the AI usage billed to a job
+func JobUsage(jobID string) store.UsageTotals {
+	if db.UsageDB == nil {
+		return store.UsageTotals{}
+	}
+	totals, err := store.SumUsage(db.UsageDB, store.UsageFilter{JobID: jobID})
+	if err != nil {
+		return store.UsageTotals{}
+	}
+	return totals
+}
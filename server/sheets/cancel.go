@@ -0,0 +1,106 @@
+package sheet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	logg "nadhi.dev/sarvar/fun/logs"
+	ws "nadhi.dev/sarvar/fun/websocket"
+)
+
+var (
+	// ErrJobNotFound is returned for a job ID the queue doesn't know
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when cancelling a job that already completed, failed or was cancelled
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// StatusCancelled is the terminal status of a cancelled job
+const StatusCancelled = "cancelled"
+
+// isFinished reports whether a status is terminal
+func isFinished(status string) bool {
+	return status == "completed" || status == "failed" || status == StatusCancelled
+}
+
+// runningJob is a job a worker is processing, cancel stops its AI calls and LaTeX engine
+type runningJob struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// stopped reports whether a job's run was cancelled, its late updates are dropped
+func (sq *SheetQueue) stopped(id string) bool {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	run, ok := sq.running[id]
+	return ok && run.ctx.Err() != nil
+}
+
+// untrackJob forgets a job once its worker is done with it
+// A retried job can be claimed again before the last run is untracked, that run is left alone
+func (sq *SheetQueue) untrackJob(id string, run *runningJob) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if sq.running[id] == run {
+		delete(sq.running, id)
+	}
+}
+
+// CancelJob stops a job. A waiting job is taken out of the queue, a running one has its context
+// cancelled, which stops the AI calls and kills the LaTeX engine. Either way the job ends as cancelled
+func (sq *SheetQueue) CancelJob(id string) error {
+	sq.mu.Lock()
+	storeJob, err := store.GetQueuedJob(db.QueueDB, id)
+	if err != nil {
+		sq.mu.Unlock()
+		return fmt.Errorf("failed to load job: %w", err)
+	}
+	if storeJob == nil {
+		sq.mu.Unlock()
+		return ErrJobNotFound
+	}
+	job := fromStoreJob(*storeJob)
+	if isFinished(job.Status) {
+		sq.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobFinished, job.Status)
+	}
+
+	// Saved before the worker is stopped, so whatever it reports while winding down is ignored
+	job.Status = StatusCancelled
+	job.UpdatedAt = time.Now()
+	if job.Data == nil {
+		job.Data = make(map[string]interface{})
+	}
+	job.Data["cancelled_at"] = time.Now().Unix()
+	if err := store.AddQueuedJob(db.QueueDB, toStoreJob(job)); err != nil {
+		sq.mu.Unlock()
+		return fmt.Errorf("failed to cancel job: %w", err)
+	}
+	run, running := sq.running[id]
+	sq.mu.Unlock()
+
+	sq.dispatch.remove(id)
+	sq.disarmReview(id)
+	if running {
+		run.cancel()
+	}
+	logg.Info(fmt.Sprintf("Job %s cancelled (running: %v)", id, running))
+
+	sq.statusUpdates <- StatusUpdate{
+		ID:     id,
+		Status: StatusCancelled,
+		Result: map[string]interface{}{"cancelled": true},
+		Data: ws.Cancelled("Sheet generation cancelled", map[string]interface{}{
+			"wasRunning": running,
+		})["data"].(map[string]interface{}),
+	}
+	if !running {
+		sq.announcePositions()
+	}
+	return nil
+}
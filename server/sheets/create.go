@@ -39,6 +39,11 @@ func NewSheetGenerator(logger *log.Logger, queueDir string, workerCount int) (*S
 }
 
 func (sg *SheetGenerator) CreateSheet(userID string, request *ai.GenerationRequest) (string, error) {
+	// Refuse up front rather than failing the job halfway through
+	if err := CheckBudget(userID); err != nil {
+		return "", err
+	}
+
 	jobID := fmt.Sprintf("sheet-%d", time.Now().UnixNano())
 
 	// Store the request directly as JSON in the Prompt field
@@ -48,7 +53,7 @@ func (sg *SheetGenerator) CreateSheet(userID string, request *ai.GenerationReque
 	}
 
 	// Use Data field for processing flags, Prompt for the actual request
-	err = sg.Queue.EnqueueJob(jobID, userID, string(requestJSON), 3)
+	err = sg.Queue.EnqueueJob(jobID, userID, string(requestJSON), 3, request.Batch)
 	if err != nil {
 		return "", fmt.Errorf("failed to enqueue job: %w", err)
 	}
@@ -31,6 +31,15 @@ func (sq *SheetQueue) DeleteJob(id string) error {
         return fmt.Errorf("job %s not found", id)
     }
     delete(jobs, id)
+    sq.dispatch.remove(id)
+    // A worker still on it stops instead of writing status for a job that is gone
+    if run, ok := sq.running[id]; ok {
+        run.cancel()
+    }
+    if timer, ok := sq.reviewTimers[id]; ok {
+        timer.Stop()
+        delete(sq.reviewTimers, id)
+    }
     log.Printf("DeleteJob: saving jobs")
     if err := sq.saveJobs(jobs); err != nil {
         log.Printf("DeleteJob: failed to save jobs: %v", err)
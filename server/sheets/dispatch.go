@@ -0,0 +1,335 @@
+package sheet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	logg "nadhi.dev/sarvar/fun/logs"
+)
+
+// queueEntry is a job waiting for a worker
+type queueEntry struct {
+	ID        string
+	UserID    string
+	CreatedAt time.Time
+	Batch     bool
+	// Weight is how many jobs the user starts per turn, from the priority of their rank
+	Weight int
+}
+
+// lane holds the jobs of one class. Users take turns, each user's jobs start oldest first
+type lane struct {
+	users  []string
+	jobs   map[string][]queueEntry
+	cursor int
+	// served counts the jobs the user at cursor has started this turn
+	served int
+}
+
+func newLane() *lane {
+	return &lane{jobs: make(map[string][]queueEntry)}
+}
+
+func (l *lane) add(e queueEntry) {
+	queue, ok := l.jobs[e.UserID]
+	if !ok {
+		l.users = append(l.users, e.UserID)
+	}
+	// Recovered jobs can be older than ones already waiting, keep CreatedAt order
+	i := len(queue)
+	for i > 0 && queue[i-1].CreatedAt.After(e.CreatedAt) {
+		i--
+	}
+	queue = append(queue, queueEntry{})
+	copy(queue[i+1:], queue[i:])
+	queue[i] = e
+	l.jobs[e.UserID] = queue
+}
+
+// take starts the next job of the user whose turn it is
+func (l *lane) take() (queueEntry, bool) {
+	if len(l.users) == 0 {
+		return queueEntry{}, false
+	}
+	if l.cursor >= len(l.users) {
+		l.cursor = 0
+	}
+	user := l.users[l.cursor]
+	queue := l.jobs[user]
+	e := queue[0]
+	l.served++
+	if len(queue) == 1 {
+		l.dropUser(l.cursor)
+		return e, true
+	}
+	l.jobs[user] = queue[1:]
+	if l.served >= e.Weight {
+		l.served = 0
+		l.cursor = (l.cursor + 1) % len(l.users)
+	}
+	return e, true
+}
+
+func (l *lane) remove(e queueEntry) {
+	queue := l.jobs[e.UserID]
+	kept := make([]queueEntry, 0, len(queue))
+	for _, other := range queue {
+		if other.ID != e.ID {
+			kept = append(kept, other)
+		}
+	}
+	if len(kept) > 0 {
+		l.jobs[e.UserID] = kept
+		return
+	}
+	for i, user := range l.users {
+		if user == e.UserID {
+			l.dropUser(i)
+			break
+		}
+	}
+}
+
+// dropUser takes a user without jobs out of the rotation, the next user's turn keeps its place
+func (l *lane) dropUser(i int) {
+	delete(l.jobs, l.users[i])
+	l.users = append(l.users[:i], l.users[i+1:]...)
+	if i < l.cursor {
+		l.cursor--
+	} else if i == l.cursor {
+		l.served = 0
+	}
+	if l.cursor >= len(l.users) {
+		l.cursor = 0
+	}
+}
+
+func (l *lane) clone() *lane {
+	c := &lane{
+		users:  append([]string(nil), l.users...),
+		jobs:   make(map[string][]queueEntry, len(l.jobs)),
+		cursor: l.cursor,
+		served: l.served,
+	}
+	for user, queue := range l.jobs {
+		c.jobs[user] = append([]queueEntry(nil), queue...)
+	}
+	return c
+}
+
+// Lanes in the order they are served, a batch job only starts when no interactive job is waiting
+const (
+	laneInteractive = iota
+	laneBatch
+)
+
+// dispatcher hands jobs to idle workers as they are enqueued
+// Workers sleep until they are woken, the store is only scanned to recover jobs (see recoverJobs)
+type dispatcher struct {
+	mu    sync.Mutex
+	lanes []*lane
+	known map[string]queueEntry
+	// wake holds at most one signal, a worker that takes a job passes it on while jobs are left
+	wake chan struct{}
+
+	// workers and avgRun feed the queue ETA
+	workers int
+	avgRun  time.Duration
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		lanes: []*lane{newLane(), newLane()},
+		known: make(map[string]queueEntry),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func laneOf(e queueEntry) int {
+	if e.Batch {
+		return laneBatch
+	}
+	return laneInteractive
+}
+
+// push adds a job and wakes a worker, false when the job is already waiting
+func (d *dispatcher) push(e queueEntry) bool {
+	if e.Weight < 1 {
+		e.Weight = 1
+	}
+	d.mu.Lock()
+	if _, ok := d.known[e.ID]; ok {
+		d.mu.Unlock()
+		return false
+	}
+	d.known[e.ID] = e
+	d.lanes[laneOf(e)].add(e)
+	d.mu.Unlock()
+
+	d.signal()
+	return true
+}
+
+// pop takes the next job, and reports whether more are waiting
+func (d *dispatcher) pop() (queueEntry, bool, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, l := range d.lanes {
+		if e, ok := l.take(); ok {
+			delete(d.known, e.ID)
+			return e, true, len(d.known) > 0
+		}
+	}
+	return queueEntry{}, false, false
+}
+
+// remove drops a job that was deleted before a worker got to it
+func (d *dispatcher) remove(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.known[id]
+	if !ok {
+		return
+	}
+	delete(d.known, id)
+	d.lanes[laneOf(e)].remove(e)
+}
+
+func (d *dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+		// A wake-up is already waiting to be picked up
+	}
+}
+
+// next blocks until there is a job for the worker, false once ctx is done
+func (d *dispatcher) next(ctx context.Context) (queueEntry, bool) {
+	for {
+		if e, ok, more := d.pop(); ok {
+			if more {
+				d.signal()
+			}
+			return e, true
+		}
+		select {
+		case <-ctx.Done():
+			return queueEntry{}, false
+		case <-d.wake:
+		}
+	}
+}
+
+// order returns the waiting jobs in the order workers will start them, by playing the schedule forward
+func (d *dispatcher) order() []queueEntry {
+	d.mu.Lock()
+	lanes := make([]*lane, len(d.lanes))
+	for i, l := range d.lanes {
+		lanes[i] = l.clone()
+	}
+	d.mu.Unlock()
+
+	var order []queueEntry
+	for _, l := range lanes {
+		for {
+			e, ok := l.take()
+			if !ok {
+				break
+			}
+			order = append(order, e)
+		}
+	}
+	return order
+}
+
+// recoveryInterval is how often the store is scanned for queued jobs the dispatcher never saw,
+// from QUEUE_RECOVERY_INTERVAL in seconds. Zero turns the scan off, startup recovery still runs
+func recoveryInterval() time.Duration {
+	if seconds, ok := config.GetConfigValue("QUEUE_RECOVERY_INTERVAL").(float64); ok && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Minute
+}
+
+// recoverJobs hands every queued job in the store to the dispatcher
+// At startup, jobs a previous run left processing are queued again, no worker owns them anymore,
+// and the review timeouts of jobs awaiting review are set again
+func (sq *SheetQueue) recoverJobs(startup bool) int {
+	sq.mu.Lock()
+	jobs, err := sq.loadJobs()
+	if err != nil {
+		sq.mu.Unlock()
+		sq.logger.Printf("Queue recovery: failed to load jobs: %v", err)
+		return 0
+	}
+
+	var queued, awaiting []QueuedJob
+	for id, job := range jobs {
+		job.ID = id
+		if processed, ok := job.Data["processed"].(bool); ok && processed {
+			continue
+		}
+		if startup && strings.HasPrefix(job.Status, "processing") {
+			job.Status = "queued"
+			job.UpdatedAt = time.Now()
+			if err := store.AddQueuedJob(db.QueueDB, toStoreJob(job)); err != nil {
+				sq.logger.Printf("Queue recovery: failed to requeue job %s: %v", id, err)
+				continue
+			}
+			sq.logger.Printf("Queue recovery: job %s was left processing, queued again", id)
+		}
+		if job.Status == "queued" {
+			queued = append(queued, job)
+		}
+		if startup && job.Status == StatusAwaitingReview {
+			awaiting = append(awaiting, job)
+		}
+	}
+	sq.mu.Unlock()
+
+	// Review timeouts only live in memory, a deadline that passed while the server was down approves now
+	for _, job := range awaiting {
+		deadline, _ := int64Value(job.Data["review_deadline"])
+		sq.armReview(job.ID, deadline)
+	}
+
+	// Oldest first, the order they were enqueued in
+	sort.Slice(queued, func(i, j int) bool { return queued[i].CreatedAt.Before(queued[j].CreatedAt) })
+	recovered := 0
+	for _, job := range queued {
+		if sq.dispatch.push(sq.entryFor(job)) {
+			recovered++
+		}
+	}
+	if recovered > 0 {
+		logg.Info(fmt.Sprintf("Queue recovery: dispatched %d queued jobs", recovered))
+	}
+	return recovered
+}
+
+// recoveryLoop runs recoverJobs every recoveryInterval
+func (sq *SheetQueue) recoveryLoop(ctx context.Context) {
+	defer sq.wg.Done()
+	interval := recoveryInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sq.recoverJobs(false)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package sheet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"nadhi.dev/sarvar/fun/ai"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	logg "nadhi.dev/sarvar/fun/logs"
+	websocket "nadhi.dev/sarvar/fun/websocket"
+)
+
+// Stage names, in the order a job runs them
+const (
+	StagePrompt    = "prompt"
+	StageGenerate  = "generate"
+	StageExtract   = "extract"
+	StageLint      = "lint"
+	StageCompile   = "compile"
+	StageThumbnail = "thumbnail"
+	StagePublish   = "publish"
+)
+
+// stage is one step from request to published sheet
+// Its output is saved with the job once it succeeds, a job that runs again loads it instead of
+// running the stage, so a retry or a restart resumes at the first stage that didn't finish
+type stage struct {
+	Name string
+	Run  func(ctx context.Context, run *pipelineRun) error
+	// Output points at the run field the stage fills in, it is what gets saved and loaded
+	Output func(run *pipelineRun) interface{}
+	// RetryFrom is where a retry starts when the stage fails, empty for the stage itself.
+	// Extraction fails the same way on the same answer, so it goes back to generation
+	RetryFrom string
+	// Optional stages only log a failure, the sheet is published without their output
+	Optional bool
+	// Review parks the job after the stage when the job is reviewed (see awaitReview)
+	Review bool
+}
+
+// pipeline is every stage a sheet goes through, the stage functions live in genAi.go
+var pipeline = []stage{
+	{Name: StagePrompt, Run: buildPromptStage, Output: func(r *pipelineRun) interface{} { return &r.Prompt }},
+	{Name: StageGenerate, Run: generateStage, Output: func(r *pipelineRun) interface{} { return &r.Response }},
+	{Name: StageExtract, Run: extractStage, Output: func(r *pipelineRun) interface{} { return &r.Draft },
+		RetryFrom: StageGenerate, Review: true},
+	{Name: StageLint, Run: lintStage, Output: func(r *pipelineRun) interface{} { return &r.Lint }},
+	{Name: StageCompile, Run: compileStage, Output: func(r *pipelineRun) interface{} { return &r.Compile }},
+	{Name: StageThumbnail, Run: thumbnailStage, Output: func(r *pipelineRun) interface{} { return &r.Previews },
+		Optional: true},
+	{Name: StagePublish, Run: publishStage, Output: func(r *pipelineRun) interface{} { return &r.Result }},
+}
+
+// pipelineRun is one run of a job through the pipeline
+type pipelineRun struct {
+	sq       *SheetQueue
+	job      *QueuedJob
+	request  *ai.GenerationRequest
+	provider ai.Provider
+
+	// Stage outputs
+	Prompt   *ai.SheetPrompt
+	Response *ai.SheetResponse
+	Draft    *sheetDraft
+	Lint     *lintOutput
+	Compile  *compileOutput
+	Previews *previewOutput
+	Result   map[string]interface{}
+}
+
+// Stage record statuses
+const (
+	stageDone    = "done"
+	stageFailed  = "failed"
+	stageSkipped = "skipped"
+)
+
+// stageRecord is what the job keeps of a stage, in job.Data["stages"]
+type stageRecord struct {
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  int64           `json:"startedAt"`
+	FinishedAt int64           `json:"finishedAt"`
+	Output     json.RawMessage `json:"output,omitempty"`
+}
+
+// finished reports whether a run can skip the stage
+func (r stageRecord) finished() bool {
+	return r.Status == stageDone || r.Status == stageSkipped
+}
+
+// StageError is a failed stage, handleJobError reports which one it was
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s stage failed: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// decodeData reads a value back from job data, which holds plain maps once the store was reloaded
+func decodeData(value interface{}, out interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// jobStages returns the stage records of a job
+func jobStages(job *QueuedJob) map[string]stageRecord {
+	stages := make(map[string]stageRecord)
+	if value, ok := job.Data["stages"]; ok && value != nil {
+		if err := decodeData(value, &stages); err != nil {
+			logg.Warning(fmt.Sprintf("Job %s has unreadable stage records, running every stage: %v", job.ID, err))
+			return make(map[string]stageRecord)
+		}
+	}
+	return stages
+}
+
+// stageIndex returns where a stage is in the pipeline, -1 for an unknown name
+func stageIndex(name string) int {
+	for i, st := range pipeline {
+		if st.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// resumeStage returns the first stage a run of the job would run, empty when every stage is done
+func resumeStage(job *QueuedJob) string {
+	stages := jobStages(job)
+	for _, st := range pipeline {
+		if !stages[st.Name].finished() {
+			return st.Name
+		}
+	}
+	return ""
+}
+
+// updateStages changes a job's stage records under the queue lock, with the job as it is in the store
+func (sq *SheetQueue) updateStages(id string, fn func(job *QueuedJob, stages map[string]stageRecord)) error {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	storeJob, err := store.GetQueuedJob(db.QueueDB, id)
+	if err != nil {
+		return fmt.Errorf("failed to load job: %w", err)
+	}
+	if storeJob == nil {
+		return ErrJobNotFound
+	}
+	job := fromStoreJob(*storeJob)
+	if job.Data == nil {
+		job.Data = make(map[string]interface{})
+	}
+	stages := jobStages(&job)
+	fn(&job, stages)
+	job.Data["stages"] = stages
+	job.UpdatedAt = time.Now()
+	return store.AddQueuedJob(db.QueueDB, toStoreJob(job))
+}
+
+// recordStage saves how a stage ended, with its output when it succeeded
+// A failed stage also forgets the stages from its RetryFrom on, so the retry starts there
+func (sq *SheetQueue) recordStage(id string, st stage, started time.Time, output interface{}, stageErr error) error {
+	var raw json.RawMessage
+	if output != nil && stageErr == nil {
+		encoded, err := json.Marshal(output)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s output: %w", st.Name, err)
+		}
+		raw = encoded
+	}
+	return sq.updateStages(id, func(job *QueuedJob, stages map[string]stageRecord) {
+		record := stages[st.Name]
+		record.Attempts++
+		record.StartedAt = started.Unix()
+		record.FinishedAt = time.Now().Unix()
+		record.Output = raw
+		record.Error = ""
+		switch {
+		case stageErr == nil:
+			record.Status = stageDone
+			// Whatever came after a stage that ran again was made from its old output
+			for _, later := range pipeline[stageIndex(st.Name)+1:] {
+				delete(stages, later.Name)
+			}
+		case st.Optional:
+			record.Status = stageSkipped
+			record.Error = stageErr.Error()
+		default:
+			record.Status = stageFailed
+			record.Error = stageErr.Error()
+			if from := stageIndex(st.RetryFrom); from >= 0 {
+				for _, earlier := range pipeline[from:stageIndex(st.Name)] {
+					delete(stages, earlier.Name)
+				}
+			}
+		}
+		stages[st.Name] = record
+	})
+}
+
+// loadStage fills in the output of a stage the job already finished, false when it has to run
+func loadStage(run *pipelineRun, st stage, record stageRecord) bool {
+	if !record.finished() {
+		return false
+	}
+	if record.Status == stageSkipped || len(record.Output) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(record.Output, st.Output(run)); err != nil {
+		logg.Warning(fmt.Sprintf("Job %s: saved %s output is unreadable, running the stage again: %v", run.job.ID, st.Name, err))
+		return false
+	}
+	return true
+}
+
+// runPipeline runs the stages of a job that aren't done yet, and loads the output of those that are
+func (sq *SheetQueue) runPipeline(ctx context.Context, run *pipelineRun) (interface{}, error) {
+	stages := jobStages(run.job)
+	ran := false
+	for i, st := range pipeline {
+		if !loadStage(run, st, stages[st.Name]) {
+			if i > 0 && !ran {
+				sq.statusUpdates <- StatusUpdate{
+					ID:     run.job.ID,
+					Status: "processing",
+					Data: websocket.Stage("Pipeline", fmt.Sprintf("Resuming at the %s stage", st.Name), map[string]interface{}{
+						"stage": st.Name,
+					})["data"].(map[string]interface{}),
+				}
+			}
+			ran = true
+
+			logg.Info(fmt.Sprintf("Job %s: running the %s stage", run.job.ID, st.Name))
+			started := time.Now()
+			err := st.Run(ctx, run)
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("job stopped: %w", ctx.Err())
+			}
+			var output interface{}
+			if err == nil {
+				output = st.Output(run)
+			} else if st.Optional {
+				logg.Warning(fmt.Sprintf("Job %s: optional %s stage failed, carrying on: %v", run.job.ID, st.Name, err))
+			}
+			if saveErr := sq.recordStage(run.job.ID, st, started, output, err); saveErr != nil {
+				logg.Warning(fmt.Sprintf("Job %s: failed to save the %s stage: %v", run.job.ID, st.Name, saveErr))
+			}
+			if err != nil && !st.Optional {
+				return nil, &StageError{Stage: st.Name, Err: err}
+			}
+		}
+
+		// Checked for loaded stages too, a job can stop between finishing the stage and being parked
+		if st.Review && needsReview(run.request) && !reviewApproved(run.job) {
+			return nil, sq.awaitReview(run.job, run.Draft)
+		}
+	}
+	return run.Result, nil
+}
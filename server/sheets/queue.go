@@ -3,6 +3,7 @@ package sheet
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +15,7 @@ import (
 	_ "nadhi.dev/sarvar/fun/ai"
 	store "nadhi.dev/sarvar/fun/database"
 	"nadhi.dev/sarvar/fun/db"
+	"nadhi.dev/sarvar/fun/latex"
 	logg "nadhi.dev/sarvar/fun/logs"
 	ws "nadhi.dev/sarvar/fun/websocket"
 )
@@ -31,6 +33,9 @@ type QueuedJob struct {
 	Retries           int                    `json:"retries"`
 	MaxRetry          int                    `json:"maxRetry"`
 	Data              map[string]interface{} `json:"data,omitempty"`
+
+	// Queue is where a queued job stands, filled in on reads and never stored
+	Queue *QueueInfo `json:"queue,omitempty"`
 }
 
 // Convert between store.QueuedJob and sheet.QueuedJob
@@ -110,6 +115,9 @@ func NewSheetQueue(logger *log.Logger, queueDir string) (*SheetQueue, error) {
 		statusUpdates: make(chan StatusUpdate, 100),
 		logger:        logger,
 		jobListeners:  make(map[string]func(StatusUpdate)),
+		dispatch:      newDispatcher(),
+		running:       make(map[string]*runningJob),
+		reviewTimers:  make(map[string]*time.Timer),
 	}, nil
 }
 
@@ -164,6 +172,15 @@ func (sq *SheetQueue) Start(ctx context.Context, workerCount int) {
 	sq.wg.Add(1)
 	go sq.statusHandler(ctx)
 
+	sq.dispatch.mu.Lock()
+	sq.dispatch.workers = workerCount
+	sq.dispatch.mu.Unlock()
+
+	// Jobs queued before a restart never went through EnqueueJob in this run
+	sq.recoverJobs(true)
+	sq.wg.Add(1)
+	go sq.recoveryLoop(ctx)
+
 	// Worker goroutines
 	for i := 0; i < workerCount; i++ {
 		sq.wg.Add(1)
@@ -179,8 +196,13 @@ func (sq *SheetQueue) Stop() {
 }
 
 // EnqueueJob adds a new sheet generation job to the queue
-func (sq *SheetQueue) EnqueueJob(id, userID, prompt string, maxRetry int) error {
+// Batch jobs only start when no interactive job is waiting
+func (sq *SheetQueue) EnqueueJob(id, userID, prompt string, maxRetry int, batch bool) error {
 	now := time.Now()
+	class := ClassInteractive
+	if batch {
+		class = ClassBatch
+	}
 	job := QueuedJob{
 		ID:                id,
 		UserID:            userID,
@@ -193,6 +215,7 @@ func (sq *SheetQueue) EnqueueJob(id, userID, prompt string, maxRetry int) error
 		Data: map[string]interface{}{
 			"processed":   false,
 			"enqueued_at": now.Unix(),
+			"class":       class,
 		},
 	}
 
@@ -202,83 +225,93 @@ func (sq *SheetQueue) EnqueueJob(id, userID, prompt string, maxRetry int) error
 	}
 
 	sq.logger.Printf("Enqueueing job %s for user %s", id, userID)
+	sq.dispatch.push(sq.entryFor(job))
+	sq.announcePositions()
 	return nil
 }
 
 // worker processes jobs from the queue
+// It sleeps until the dispatcher hands it a job, then claims it in the store
 func (sq *SheetQueue) worker(ctx context.Context, id int) {
 	defer sq.wg.Done()
 	logg.Success(fmt.Sprintf("Worker %d started", id))
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
 	for {
-		select {
-		case <-ctx.Done():
+		entry, ok := sq.dispatch.next(ctx)
+		if !ok {
 			sq.logger.Printf("Worker %d shutting down due to context cancellation", id)
 			return
-
-		case <-ticker.C:
-			// Try to claim a job atomically
-			jobToProcess := sq.claimNextJob(id)
-			if jobToProcess != nil {
-				sq.processJob(ctx, jobToProcess)
+		}
+		// Everyone behind it moved up one
+		sq.announcePositions()
+
+		// Cancelling the job cancels jobCtx, which stops its AI calls and the LaTeX engine
+		jobCtx, cancel := context.WithCancel(ctx)
+
+		// Try to claim the job atomically
+		run := &runningJob{ctx: jobCtx, cancel: cancel}
+		jobToProcess := sq.claimJob(id, entry.ID, run)
+		if jobToProcess != nil {
+			started := time.Now()
+			sq.processJob(jobCtx, jobToProcess)
+			if jobCtx.Err() == nil {
+				sq.dispatch.recordRun(time.Since(started))
 			}
+			sq.untrackJob(entry.ID, run)
 		}
+		cancel()
 	}
 }
 
-// claimNextJob atomically claims the next available queued job
-func (sq *SheetQueue) claimNextJob(workerID int) *QueuedJob {
+// claimJob atomically claims a queued job and tracks its run, so CancelJob can stop it from then on
+// Nil when the job is gone, was claimed already or has been processed
+func (sq *SheetQueue) claimJob(workerID int, jobID string, run *runningJob) *QueuedJob {
 	sq.mu.Lock()
 	defer sq.mu.Unlock()
 
-	// Load jobs from database
-	jobs, err := sq.loadJobs()
+	storeJob, err := store.GetQueuedJob(db.QueueDB, jobID)
 	if err != nil {
-		sq.logger.Printf("Worker %d: Error loading jobs: %v", workerID, err)
+		sq.logger.Printf("Worker %d: Error loading job %s: %v", workerID, jobID, err)
 		return nil
 	}
+	if storeJob == nil {
+		sq.logger.Printf("Worker %d: Job %s no longer exists", workerID, jobID)
+		return nil
+	}
+	job := fromStoreJob(*storeJob)
 
-	// Find a queued job to process
-	for jobID, job := range jobs {
-		// Only process jobs that are truly queued
-		if job.Status != "queued" {
-			continue
-		}
-
-		// Check if already processed
-		if job.Data != nil {
-			if processed, ok := job.Data["processed"].(bool); ok && processed {
-				sq.logger.Printf("Worker %d: Skipping already processed job %s", workerID, jobID)
-				continue
-			}
-		}
+	// Only process jobs that are truly queued
+	if job.Status != "queued" {
+		return nil
+	}
 
-		// Claim the job by updating status
-		job.Status = "processing"
-		job.UpdatedAt = time.Now()
-		if job.Data == nil {
-			job.Data = make(map[string]interface{})
+	// Check if already processed
+	if job.Data != nil {
+		if processed, ok := job.Data["processed"].(bool); ok && processed {
+			sq.logger.Printf("Worker %d: Skipping already processed job %s", workerID, jobID)
+			return nil
 		}
-		job.Data["processing_started"] = time.Now().Unix()
-		job.Data["worker_id"] = fmt.Sprintf("worker-%d", workerID)
+	}
 
-		// Save immediately to claim it
-		storeJob := toStoreJob(job)
-		if err := store.AddQueuedJob(db.QueueDB, storeJob); err != nil {
-			sq.logger.Printf("Worker %d: Failed to claim job %s: %v", workerID, jobID, err)
-			continue
-		}
+	// Claim the job by updating status
+	job.Status = "processing"
+	job.UpdatedAt = time.Now()
+	if job.Data == nil {
+		job.Data = make(map[string]interface{})
+	}
+	job.Data["processing_started"] = time.Now().Unix()
+	job.Data["worker_id"] = fmt.Sprintf("worker-%d", workerID)
 
-		sq.logger.Printf("Worker %d: Successfully claimed job %s", workerID, jobID)
-		jobCopy := job
-		jobCopy.ID = jobID
-		return &jobCopy
+	// Save immediately to claim it
+	if err := store.AddQueuedJob(db.QueueDB, toStoreJob(job)); err != nil {
+		sq.logger.Printf("Worker %d: Failed to claim job %s: %v", workerID, jobID, err)
+		return nil
 	}
 
-	return nil
+	sq.running[jobID] = run
+	sq.logger.Printf("Worker %d: Successfully claimed job %s", workerID, jobID)
+	job.ID = jobID
+	return &job
 }
 
 // processJob handles the actual sheet generation logic
@@ -320,23 +353,33 @@ func (sq *SheetQueue) processJob(ctx context.Context, job *QueuedJob) {
 	}
 
 	// Call AI generation
-	result, err := sq.generateWithAI(job)
+	result, err := sq.generateWithAI(ctx, job)
+	if ctx.Err() != nil {
+		// Cancelled, or the server is shutting down, either way this isn't a failure to retry
+		sq.logger.Printf("Job %s stopped: %v", job.ID, ctx.Err())
+		return
+	}
+	if errors.Is(err, errAwaitingReview) {
+		// Parked with its draft, the worker moves on and the job is queued again once reviewed
+		return
+	}
 	if err != nil {
 		sq.handleJobError(job, err)
 		return
 	}
 
 	// Mark as processed
-	jobs, _ := sq.loadJobs()
-	if finalJob, exists := jobs[job.ID]; exists {
+	sq.mu.Lock()
+	if storeJob, err := store.GetQueuedJob(db.QueueDB, job.ID); err == nil && storeJob != nil {
+		finalJob := fromStoreJob(*storeJob)
 		if finalJob.Data == nil {
 			finalJob.Data = make(map[string]interface{})
 		}
 		finalJob.Data["processed"] = true
 		finalJob.Data["completed_at"] = time.Now().Unix()
-		jobs[job.ID] = finalJob
-		sq.saveJobs(jobs)
+		store.AddQueuedJob(db.QueueDB, toStoreJob(finalJob))
 	}
+	sq.mu.Unlock()
 
 	fmt.Println(result)
 }
@@ -351,53 +394,78 @@ func (sq *SheetQueue) createSheetObject(job *QueuedJob) error {
 }
 
 // handleJobError processes failures with retry logic
+// The failed stage recorded where a retry starts, so the job is queued again and resumes there
+// instead of starting over with the AI generation
 func (sq *SheetQueue) handleJobError(job *QueuedJob, err error) {
 	sq.logger.Printf("Error processing job %s: %v", job.ID, err)
 
-	jobs, loadErr := sq.loadJobs()
-	if loadErr != nil {
-		sq.logger.Printf("Failed to load jobs for error handling: %v", loadErr)
-		return
+	failedStage := ""
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		failedStage = stageErr.Stage
 	}
 
-	currentJob, exists := jobs[job.ID]
-	if !exists {
-		sq.logger.Printf("Job %s no longer exists", job.ID)
+	sq.mu.Lock()
+	storeJob, loadErr := store.GetQueuedJob(db.QueueDB, job.ID)
+	if loadErr != nil || storeJob == nil {
+		sq.mu.Unlock()
+		sq.logger.Printf("Job %s no longer exists or failed to load for error handling: %v", job.ID, loadErr)
 		return
 	}
+	currentJob := fromStoreJob(*storeJob)
 
 	currentJob.Retries++
-	var status string
-	if currentJob.Retries <= currentJob.MaxRetry {
-		sq.logger.Printf("Retrying job %s (%d/%d)", job.ID, currentJob.Retries, currentJob.MaxRetry)
-		status = "retrying"
+	willRetry := currentJob.Retries <= currentJob.MaxRetry
+	status := "failed"
+	resumeFrom := ""
+	if willRetry {
+		resumeFrom = resumeStage(&currentJob)
+		sq.logger.Printf("Retrying job %s (%d/%d) from the %s stage", job.ID, currentJob.Retries, currentJob.MaxRetry, resumeFrom)
+		status = "queued"
 	} else {
 		sq.logger.Printf("Job %s failed after %d retries", job.ID, currentJob.Retries)
-		status = "failed"
 	}
 
-	currentJob.Status = status
+	// The status is left to statusHandler, so it lands after the updates the failed run sent
 	currentJob.UpdatedAt = time.Now()
-	jobs[job.ID] = currentJob
+	if err := store.AddQueuedJob(db.QueueDB, toStoreJob(currentJob)); err != nil {
+		sq.logger.Printf("Failed to save job after error handling: %v", err)
+	}
+	sq.mu.Unlock()
 
-	if err := sq.saveJobs(jobs); err != nil {
-		sq.logger.Printf("Failed to save jobs after error handling: %v", err)
+	message := "Job failed"
+	if failedStage != "" {
+		message = fmt.Sprintf("Job failed at the %s stage", failedStage)
+	}
+	if willRetry {
+		message += fmt.Sprintf(", retrying from the %s stage...", resumeFrom)
 	}
 
 	// Send only one status update using the Error helper
 	errorUpdate := ws.Retry(
-		"Job failed, retrying...",
+		message,
 		map[string]interface{}{
-			"retries":   currentJob.Retries,
-			"maxRetry":  currentJob.MaxRetry,
-			"willRetry": currentJob.Retries <= currentJob.MaxRetry,
+			"retries":    currentJob.Retries,
+			"maxRetry":   currentJob.MaxRetry,
+			"willRetry":  willRetry,
+			"stage":      failedStage,
+			"resumeFrom": resumeFrom,
 		},
 	)
+	// A failed compile keeps its parsed errors in the result
+	var result interface{} = err.Error()
+	if diagnostics := latex.CompileDiagnostics(err); diagnostics != nil {
+		result = map[string]interface{}{
+			"error":              err.Error(),
+			"compileDiagnostics": diagnostics,
+		}
+	}
 	sq.statusUpdates <- StatusUpdate{
-		ID:     job.ID,
-		Status: status,
-		Result: err.Error(),
-		Data:   errorUpdate["data"].(map[string]interface{}),
+		ID:      job.ID,
+		Status:  status,
+		Result:  result,
+		Data:    errorUpdate["data"].(map[string]interface{}),
+		Requeue: willRetry,
 	}
 }
 
@@ -419,35 +487,65 @@ func (sq *SheetQueue) statusHandler(ctx context.Context) {
 				return
 			}
 
-			jobs, err := sq.loadJobs()
+			// A cancelled job's worker may still be winding down, what it reports is dropped
+			if update.Status != StatusCancelled && sq.stopped(update.ID) {
+				continue
+			}
+
+			if update.Transient {
+				sq.notifyListener(update)
+				continue
+			}
+
+			// Only this job is read and written, under the lock, so data other code saved meanwhile
+			// (a claim, a cancel, a parked draft) is kept
+			sq.mu.Lock()
+			storeJob, err := store.GetQueuedJob(db.QueueDB, update.ID)
 			if err != nil {
-				sq.logger.Printf("Failed to load jobs for status update: %v", err)
+				sq.mu.Unlock()
+				sq.logger.Printf("Failed to load job for status update: %v", err)
 				continue
 			}
 
-			if job, exists := jobs[update.ID]; exists {
+			if storeJob != nil {
+				job := fromStoreJob(*storeJob)
+				if job.Status == StatusCancelled && update.Status != StatusCancelled {
+					sq.mu.Unlock()
+					continue
+				}
 				job.Status = update.Status
 				job.Result = update.Result
 				job.UpdatedAt = time.Now()
-				jobs[update.ID] = job
 
-				if err := sq.saveJobs(jobs); err != nil {
-					sq.logger.Printf("Failed to save jobs after status update: %v", err)
+				if err := store.AddQueuedJob(db.QueueDB, toStoreJob(job)); err != nil {
+					sq.logger.Printf("Failed to save job after status update: %v", err)
 				}
+				sq.mu.Unlock()
 
-				// Notify job-specific listener
-				sq.mu.Lock()
-				if listener, ok := sq.jobListeners[update.ID]; ok && listener != nil {
-					listener(update)
+				if update.Requeue {
+					sq.dispatch.push(sq.entryFor(job))
 				}
-				sq.mu.Unlock()
+
+				// Notify job-specific listener
+				sq.notifyListener(update)
 
 				sq.logger.Printf("Job %s status updated to %s (ready for websocket notification)", update.ID, update.Status)
+			} else {
+				sq.mu.Unlock()
 			}
 		}
 	}
 }
 
+// notifyListener passes an update to the job's websocket listener, if any
+func (sq *SheetQueue) notifyListener(update StatusUpdate) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if listener, ok := sq.jobListeners[update.ID]; ok && listener != nil {
+		listener(update)
+	}
+}
+
 // updateJobStatus sends a status update to the status handler
 // This is deprecated in favor of sending StatusUpdate directly to the channel
 func (sq *SheetQueue) updateJobStatus(id, status string, result interface{}) {
@@ -472,9 +570,13 @@ func (sq *SheetQueue) GetJobsByUser(userID string) ([]QueuedJob, error) {
 		return nil, fmt.Errorf("failed to load jobs: %w", err)
 	}
 
+	infos := sq.dispatch.queueInfos()
 	var userJobs []QueuedJob
-	for _, job := range jobs {
+	for id, job := range jobs {
 		if job.UserID == userID {
+			if info, ok := infos[id]; ok {
+				job.Queue = &info
+			}
 			userJobs = append(userJobs, job)
 		}
 	}
@@ -504,7 +606,11 @@ func (sq *SheetQueue) GetJobStatus(id string) (QueuedJob, bool) {
 	if err != nil || storeJob == nil {
 		return QueuedJob{}, false
 	}
-	return fromStoreJob(*storeJob), true
+	job := fromStoreJob(*storeJob)
+	if info, ok := sq.QueueInfo(id); ok {
+		job.Queue = &info
+	}
+	return job, true
 }
 
 // extractGenerationRequest extracts the GenerationRequest from a job's prompt
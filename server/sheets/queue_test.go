@@ -0,0 +1,106 @@
+package sheet
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nadhi.dev/sarvar/fun/ai"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	"nadhi.dev/sarvar/fun/latex"
+)
+
+// offlineServer runs the test in a fresh directory with set.json holding settings
+// set.json is found four directories above the binary, so os.Args[0] is moved below the directory
+func offlineServer(t *testing.T, settings map[string]interface{}) {
+	dir := t.TempDir()
+	data, err := json.Marshal([]map[string]interface{}{settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "set.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	realArg, realQueueDB := os.Args[0], db.QueueDB
+	os.Args[0] = filepath.Join(dir, "build", "bin", "server", "server")
+	db.QueueDB = &store.DB{Path: filepath.Join(dir, "queue")}
+	if err := os.MkdirAll(db.QueueDB.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Args[0], db.QueueDB = realArg, realQueueDB
+		os.Chdir(wd)
+	})
+}
+
+func TestProcessJobWithFakeProvider(t *testing.T) {
+	offlineServer(t, map[string]interface{}{"AI_PROVIDER": "fake"})
+
+	prompt, err := json.Marshal(ai.GenerationRequest{Subject: "Maths", Course: "Algebra", Description: "Squares"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := QueuedJob{ID: "fake-job", UserID: "u", Prompt: string(prompt), Status: "processing",
+		Data: map[string]interface{}{"processed": false}}
+	if err := store.AddQueuedJob(db.QueueDB, toStoreJob(job)); err != nil {
+		t.Fatal(err)
+	}
+
+	sq := &SheetQueue{
+		statusUpdates: make(chan StatusUpdate, 1000),
+		logger:        log.New(io.Discard, "", 0),
+		jobListeners:  make(map[string]func(StatusUpdate)),
+		dispatch:      newDispatcher(),
+		running:       make(map[string]*runningJob),
+	}
+	sq.processJob(context.Background(), &job)
+
+	stored, err := store.GetQueuedJob(db.QueueDB, job.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("job is gone after processing: %v", err)
+	}
+	finished := fromStoreJob(*stored)
+	stages := jobStages(&finished)
+	for _, name := range []string{StagePrompt, StageGenerate, StageExtract, StageLint} {
+		if stages[name].Status != stageDone {
+			t.Errorf("%s stage is %q, want done", name, stages[name].Status)
+		}
+	}
+
+	draft, err := jobDraft(&finished)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Provider != "fake" || !strings.Contains(draft.LaTeX, "Fake Worksheet") {
+		t.Errorf("draft from %s does not hold the fake worksheet:\n%s", draft.Provider, draft.LaTeX)
+	}
+
+	// Without a LaTeX engine the job stops at the compile stage, with one it is published
+	if _, err := latex.ResolveEngine(""); err != nil {
+		if stages[StageCompile].Status != stageFailed {
+			t.Errorf("compile stage is %q without an engine, want failed", stages[StageCompile].Status)
+		}
+		t.Logf("compile not checked: %v", err)
+		return
+	}
+	if processed, _ := finished.Data["processed"].(bool); !processed {
+		t.Fatalf("job was not processed, stages: %+v", stages)
+	}
+	if _, err := os.Stat(filepath.Join("storage", "bucket", job.ID+".pdf")); err != nil {
+		t.Errorf("no PDF in the bucket: %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package sheet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nadhi.dev/sarvar/fun/ai"
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	"nadhi.dev/sarvar/fun/latex"
+	logg "nadhi.dev/sarvar/fun/logs"
+	ws "nadhi.dev/sarvar/fun/websocket"
+)
+
+// StatusAwaitingReview is the status of a job parked with its draft until the user reviews it
+const StatusAwaitingReview = "awaiting_review"
+
+// Review actions
+const (
+	ReviewApprove    = "approve"
+	ReviewEdit       = "edit"
+	ReviewRegenerate = "regenerate"
+)
+
+var (
+	// ErrNotAwaitingReview is returned when reviewing a job that has no draft waiting
+	ErrNotAwaitingReview = errors.New("job is not awaiting review")
+	// ErrInvalidReview is returned for an unknown action, an empty edit or one regeneration too many
+	ErrInvalidReview = errors.New("invalid review")
+)
+
+// errAwaitingReview tells processJob the job was parked, it neither finished nor failed
+var errAwaitingReview = errors.New("job awaiting review")
+
+// ReviewConfig is read from SHEET_REVIEW
+// When Enabled every job waits for review, otherwise only the ones created with review set.
+// A draft nobody reviews is approved after TimeoutSec, 0 waits forever
+type ReviewConfig struct {
+	Enabled          bool `json:"enabled"`
+	TimeoutSec       int  `json:"timeoutSec"`
+	MaxRegenerations int  `json:"maxRegenerations"`
+}
+
+var defaultReview = ReviewConfig{
+	TimeoutSec:       600,
+	MaxRegenerations: 3,
+}
+
+func reviewFromConfig() ReviewConfig {
+	cfg := defaultReview
+	config.DecodeConfigValue("SHEET_REVIEW", &cfg)
+	return cfg
+}
+
+// needsReview reports whether a job waits for review before compiling
+func needsReview(request *ai.GenerationRequest) bool {
+	return request.Review || reviewFromConfig().Enabled
+}
+
+// sheetDraft is what generation produced for a job, the output of the extract stage
+type sheetDraft struct {
+	LaTeX       string                 `json:"latex"`
+	Metadata    map[string]string      `json:"metadata"`
+	Questions   []ai.WorksheetQuestion `json:"questions,omitempty"`
+	Diagnostics []latex.Diagnostic     `json:"diagnostics"`
+	OutputMode  string                 `json:"outputMode"`
+	Model       string                 `json:"model"`
+	Provider    string                 `json:"provider"`
+	// Edited is set when the user replaced the LaTeX in review
+	Edited bool `json:"edited,omitempty"`
+}
+
+// jobDraft returns the draft a job's extract stage saved
+func jobDraft(job *QueuedJob) (*sheetDraft, error) {
+	record, ok := jobStages(job)[StageExtract]
+	if !ok || record.Status != stageDone || len(record.Output) == 0 {
+		return nil, fmt.Errorf("job has no draft")
+	}
+	var draft sheetDraft
+	if err := json.Unmarshal(record.Output, &draft); err != nil {
+		return nil, fmt.Errorf("failed to decode draft: %w", err)
+	}
+	return &draft, nil
+}
+
+// reviewApproved reports whether a job's draft was approved, as is or edited
+func reviewApproved(job *QueuedJob) bool {
+	approved, ok := job.Data["review_approved"].(bool)
+	return ok && approved
+}
+
+// int64Value reads a number from job data, a float64 once the store was reloaded
+func int64Value(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// ReviewDecision is what the user chose for a draft
+// LaTeX replaces the draft for edit, Instructions are added to the request for regenerate
+type ReviewDecision struct {
+	Action       string `json:"action"`
+	LaTeX        string `json:"latex,omitempty"`
+	Instructions string `json:"instructions,omitempty"`
+}
+
+// awaitReview parks a job after its draft was extracted and releases the worker
+// The job leaves awaiting_review through ReviewJob, or on its own when the review times out
+func (sq *SheetQueue) awaitReview(job *QueuedJob, draft *sheetDraft) error {
+	cfg := reviewFromConfig()
+	var deadline int64
+	if cfg.TimeoutSec > 0 {
+		deadline = time.Now().Add(time.Duration(cfg.TimeoutSec) * time.Second).Unix()
+	}
+
+	sq.mu.Lock()
+	storeJob, err := store.GetQueuedJob(db.QueueDB, job.ID)
+	if err != nil || storeJob == nil {
+		sq.mu.Unlock()
+		return fmt.Errorf("failed to park job for review: %v", err)
+	}
+	current := fromStoreJob(*storeJob)
+	if isFinished(current.Status) {
+		sq.mu.Unlock()
+		return errAwaitingReview
+	}
+	if current.Data == nil {
+		current.Data = make(map[string]interface{})
+	}
+	// The draft itself was saved with the extract stage
+	current.Data["review_deadline"] = deadline
+	delete(current.Data, "review_approved")
+	// The status itself goes through statusHandler, after the updates the worker already sent
+	if err := store.AddQueuedJob(db.QueueDB, toStoreJob(current)); err != nil {
+		sq.mu.Unlock()
+		return fmt.Errorf("failed to park job for review: %w", err)
+	}
+	sq.mu.Unlock()
+
+	sq.armReview(job.ID, deadline)
+	logg.Info(fmt.Sprintf("Job %s is awaiting review", job.ID))
+
+	sq.statusUpdates <- StatusUpdate{
+		ID:     job.ID,
+		Status: StatusAwaitingReview,
+		Data:   reviewMessage(current, draft)["data"].(map[string]interface{}),
+	}
+	return errAwaitingReview
+}
+
+// reviewMessage is the websocket message asking for a draft's review
+func reviewMessage(job QueuedJob, draft *sheetDraft) map[string]interface{} {
+	deadline, _ := int64Value(job.Data["review_deadline"])
+	regenerations, _ := int64Value(job.Data["regenerations"])
+	return ws.AwaitReview("Review the generated LaTeX, then approve, edit or regenerate it", draft.LaTeX, deadline,
+		map[string]interface{}{
+			"metadata":         draft.Metadata,
+			"outputMode":       draft.OutputMode,
+			"diagnostics":      draft.Diagnostics,
+			"regenerations":    regenerations,
+			"maxRegenerations": reviewFromConfig().MaxRegenerations,
+		})
+}
+
+// ReviewMessage returns the review request of a job awaiting review, for clients that connect late
+func (sq *SheetQueue) ReviewMessage(job QueuedJob) (map[string]interface{}, bool) {
+	if job.Status != StatusAwaitingReview {
+		return nil, false
+	}
+	draft, err := jobDraft(&job)
+	if err != nil {
+		return nil, false
+	}
+	return reviewMessage(job, draft), true
+}
+
+// armReview approves a draft on its own once deadline passes, a zero deadline never does
+func (sq *SheetQueue) armReview(id string, deadline int64) {
+	sq.disarmReview(id)
+	if deadline == 0 {
+		return
+	}
+	timer := time.AfterFunc(time.Until(time.Unix(deadline, 0)), func() {
+		err := sq.review(id, ReviewDecision{Action: ReviewApprove}, true)
+		if err != nil && !errors.Is(err, ErrNotAwaitingReview) && !errors.Is(err, ErrJobNotFound) {
+			logg.Warning(fmt.Sprintf("Auto-approving job %s failed: %v", id, err))
+		}
+	})
+
+	sq.mu.Lock()
+	sq.reviewTimers[id] = timer
+	sq.mu.Unlock()
+}
+
+// disarmReview stops a job's review timeout
+func (sq *SheetQueue) disarmReview(id string) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if timer, ok := sq.reviewTimers[id]; ok {
+		timer.Stop()
+		delete(sq.reviewTimers, id)
+	}
+}
+
+// ReviewJob applies the user's decision to a job awaiting review and queues it again
+// Approved and edited drafts resume at the lint stage, a regeneration starts over from the prompt
+func (sq *SheetQueue) ReviewJob(id string, decision ReviewDecision) error {
+	return sq.review(id, decision, false)
+}
+
+func (sq *SheetQueue) review(id string, decision ReviewDecision, auto bool) error {
+	cfg := reviewFromConfig()
+
+	sq.mu.Lock()
+	storeJob, err := store.GetQueuedJob(db.QueueDB, id)
+	if err != nil {
+		sq.mu.Unlock()
+		return fmt.Errorf("failed to load job: %w", err)
+	}
+	if storeJob == nil {
+		sq.mu.Unlock()
+		return ErrJobNotFound
+	}
+	job := fromStoreJob(*storeJob)
+	if job.Status != StatusAwaitingReview {
+		sq.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotAwaitingReview, job.Status)
+	}
+	draft, err := jobDraft(&job)
+	if err != nil {
+		sq.mu.Unlock()
+		return err
+	}
+	stages := jobStages(&job)
+
+	var message string
+	switch decision.Action {
+	case ReviewApprove:
+		job.Data["review_approved"] = true
+		message = "Draft approved, compiling the PDF"
+		if auto {
+			message = "Nobody reviewed the draft in time, it was approved"
+		}
+	case ReviewEdit:
+		if strings.TrimSpace(decision.LaTeX) == "" {
+			sq.mu.Unlock()
+			return fmt.Errorf("%w: edited LaTeX is empty", ErrInvalidReview)
+		}
+		draft.LaTeX = decision.LaTeX
+		draft.Edited = true
+		// The parser diagnostics were about the LaTeX that was replaced
+		draft.Diagnostics = []latex.Diagnostic{}
+		output, err := json.Marshal(draft)
+		if err != nil {
+			sq.mu.Unlock()
+			return fmt.Errorf("failed to encode draft: %w", err)
+		}
+		record := stages[StageExtract]
+		record.Output = output
+		stages[StageExtract] = record
+		job.Data["stages"] = stages
+		job.Data["review_approved"] = true
+		message = "Edited draft approved, compiling the PDF"
+	case ReviewRegenerate:
+		regenerations, _ := int64Value(job.Data["regenerations"])
+		if cfg.MaxRegenerations > 0 && int(regenerations) >= cfg.MaxRegenerations {
+			sq.mu.Unlock()
+			return fmt.Errorf("%w: the draft was regenerated %d times already", ErrInvalidReview, regenerations)
+		}
+		if instructions := strings.TrimSpace(decision.Instructions); instructions != "" {
+			var request ai.GenerationRequest
+			if err := json.Unmarshal([]byte(job.Prompt), &request); err != nil {
+				sq.mu.Unlock()
+				return fmt.Errorf("failed to parse job request: %w", err)
+			}
+			request.SpecialInstructions = strings.TrimSpace(request.SpecialInstructions + "\n\n" + instructions)
+			prompt, err := json.Marshal(request)
+			if err != nil {
+				sq.mu.Unlock()
+				return fmt.Errorf("failed to encode job request: %w", err)
+			}
+			job.Prompt = string(prompt)
+		}
+		// The prompt changed, so every stage runs again
+		delete(job.Data, "stages")
+		job.Data["regenerations"] = regenerations + 1
+		message = "Regenerating the sheet"
+	default:
+		sq.mu.Unlock()
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidReview, decision.Action)
+	}
+
+	delete(job.Data, "review_deadline")
+	job.Data["review_action"] = decision.Action
+	job.Data["reviewed_at"] = time.Now().Unix()
+	if auto {
+		job.Data["review_auto"] = true
+	}
+	job.Status = "queued"
+	job.UpdatedAt = time.Now()
+	if err := store.AddQueuedJob(db.QueueDB, toStoreJob(job)); err != nil {
+		sq.mu.Unlock()
+		return fmt.Errorf("failed to queue reviewed job: %w", err)
+	}
+	if timer, ok := sq.reviewTimers[id]; ok {
+		timer.Stop()
+		delete(sq.reviewTimers, id)
+	}
+	sq.mu.Unlock()
+
+	logg.Info(fmt.Sprintf("Job %s reviewed: %s (auto: %v)", id, decision.Action, auto))
+	sq.dispatch.push(sq.entryFor(job))
+
+	// Transient, a worker may have claimed the job already and its status must not go back to queued
+	sq.statusUpdates <- StatusUpdate{
+		ID:     id,
+		Status: "queued",
+		Data: ws.Stage("Review", message, map[string]interface{}{
+			"action": decision.Action,
+			"auto":   auto,
+		})["data"].(map[string]interface{}),
+		Transient: true,
+	}
+	sq.announcePositions()
+	return nil
+}
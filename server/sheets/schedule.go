@@ -0,0 +1,175 @@
+package sheet
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"nadhi.dev/sarvar/fun/config"
+	store "nadhi.dev/sarvar/fun/database"
+	"nadhi.dev/sarvar/fun/db"
+	ws "nadhi.dev/sarvar/fun/websocket"
+)
+
+// Job classes, batch jobs only start when no interactive job is waiting
+const (
+	ClassInteractive = "interactive"
+	ClassBatch       = "batch"
+)
+
+// QueuePriorities is read from QUEUE_PRIORITIES
+// A rank's priority is how many extra jobs its users start per turn of the round-robin between
+// users, with 1 a teacher starts two sheets for every one of a student's. Unknown ranks get 0
+type QueuePriorities struct {
+	Ranks map[string]int `json:"ranks"`
+}
+
+var defaultQueuePriorities = QueuePriorities{
+	Ranks: map[string]int{"admin": 1, "teacher": 1},
+}
+
+// rankWeight returns how many jobs a user starts per turn
+func rankWeight(userID string) int {
+	priorities := defaultQueuePriorities
+	config.DecodeConfigValue("QUEUE_PRIORITIES", &priorities)
+
+	rank := "user"
+	if db.UsersDB != nil {
+		if user, err := store.GetUser(db.UsersDB, userID); err == nil && user != nil && user.Rank != "" {
+			rank = user.Rank
+		}
+	}
+	if priority := priorities.Ranks[rank]; priority > 0 {
+		return 1 + priority
+	}
+	return 1
+}
+
+// jobClass returns the class a job was enqueued with
+func jobClass(job QueuedJob) string {
+	if class, ok := job.Data["class"].(string); ok && class == ClassBatch {
+		return ClassBatch
+	}
+	return ClassInteractive
+}
+
+// entryFor builds the dispatcher entry of a queued job
+func (sq *SheetQueue) entryFor(job QueuedJob) queueEntry {
+	return queueEntry{
+		ID:        job.ID,
+		UserID:    job.UserID,
+		CreatedAt: job.CreatedAt,
+		Batch:     jobClass(job) == ClassBatch,
+		Weight:    rankWeight(job.UserID),
+	}
+}
+
+// QueueInfo is where a queued job stands, Position 1 starts next
+// ETASeconds is a rough estimate of when a worker picks it up, from the average run time
+type QueueInfo struct {
+	Position   int    `json:"position"`
+	Length     int    `json:"length"`
+	ETASeconds int    `json:"etaSeconds"`
+	Class      string `json:"class"`
+}
+
+// defaultRunTime is the ETA basis until a job has finished
+const defaultRunTime = 90 * time.Second
+
+// recordRun folds how long a job held a worker into the moving average
+func (d *dispatcher) recordRun(took time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.avgRun == 0 {
+		d.avgRun = took
+		return
+	}
+	d.avgRun = time.Duration(0.8*float64(d.avgRun) + 0.2*float64(took))
+}
+
+// queueInfos returns the standing of every waiting job
+func (d *dispatcher) queueInfos() map[string]QueueInfo {
+	order := d.order()
+
+	d.mu.Lock()
+	workers, avg := d.workers, d.avgRun
+	d.mu.Unlock()
+	if workers < 1 {
+		workers = 1
+	}
+	if avg == 0 {
+		avg = defaultRunTime
+	}
+
+	infos := make(map[string]QueueInfo, len(order))
+	for i, e := range order {
+		// Jobs ahead are shared between the workers, and the running ones are half done on average
+		wait := (float64(i)/float64(workers) + 0.5) * avg.Seconds()
+		class := ClassInteractive
+		if e.Batch {
+			class = ClassBatch
+		}
+		infos[e.ID] = QueueInfo{
+			Position:   i + 1,
+			Length:     len(order),
+			ETASeconds: int(math.Ceil(wait)),
+			Class:      class,
+		}
+	}
+	return infos
+}
+
+// QueueInfo returns where a job stands, false when it isn't waiting for a worker
+func (sq *SheetQueue) QueueInfo(id string) (QueueInfo, bool) {
+	info, ok := sq.dispatch.queueInfos()[id]
+	return info, ok
+}
+
+// announcePositions tells every waiting job with a websocket listener where it now stands
+// It runs in HTTP handlers, so it never waits for room in statusUpdates. A position that is
+// dropped is only a hint, the next announcement brings the listener up to date
+func (sq *SheetQueue) announcePositions() {
+	sq.mu.Lock()
+	ids := make([]string, 0, len(sq.jobListeners))
+	for id := range sq.jobListeners {
+		ids = append(ids, id)
+	}
+	sq.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	infos := sq.dispatch.queueInfos()
+	for _, id := range ids {
+		info, ok := infos[id]
+		if !ok {
+			continue
+		}
+		select {
+		case sq.statusUpdates <- StatusUpdate{
+			ID:        id,
+			Status:    "queued",
+			Data:      QueueMessage(info)["data"].(map[string]interface{}),
+			Transient: true,
+		}:
+		default:
+			sq.logger.Printf("Status updates are backed up, skipped the queue position of job %s", id)
+		}
+	}
+}
+
+// QueueMessage is the websocket message for a job's place in the queue
+func QueueMessage(info QueueInfo) map[string]interface{} {
+	return ws.Queue(fmt.Sprintf("Position %d of %d in the queue, starting in about %s", info.Position, info.Length, formatETA(info.ETASeconds)),
+		info.Position, info.ETASeconds, map[string]interface{}{
+			"length": info.Length,
+			"class":  info.Class,
+		})
+}
+
+func formatETA(seconds int) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%ds", seconds)
+	}
+	return fmt.Sprintf("%dm", int(math.Ceil(float64(seconds)/60)))
+}
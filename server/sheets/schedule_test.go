@@ -0,0 +1,39 @@
+package sheet
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestAnnouncePositionsDoesNotBlock(t *testing.T) {
+	sq := &SheetQueue{
+		statusUpdates: make(chan StatusUpdate, 1),
+		logger:        log.New(io.Discard, "", 0),
+		jobListeners:  make(map[string]func(StatusUpdate)),
+		dispatch:      newDispatcher(),
+	}
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c"} {
+		sq.jobListeners[id] = func(StatusUpdate) {}
+		sq.dispatch.push(queueEntry{ID: id, UserID: "u", CreatedAt: now.Add(time.Duration(i) * time.Second), Weight: 1})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// Nobody reads statusUpdates, only the first position fits
+		sq.announcePositions()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("announcePositions blocked on a full status channel")
+	}
+
+	update := <-sq.statusUpdates
+	if update.Status != "queued" || !update.Transient {
+		t.Errorf("announced %+v, want a transient queued update", update)
+	}
+}
@@ -0,0 +1,78 @@
+package sheet
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"nadhi.dev/sarvar/fun/ai"
+	websocket "nadhi.dev/sarvar/fun/websocket"
+)
+
+const (
+	// Chunks are batched so a fast model doesn't flood the websocket
+	streamFlushInterval = 300 * time.Millisecond
+	streamFlushBytes    = 2048
+)
+
+// chunkForwarder batches streamed AI output into transient status updates
+type chunkForwarder struct {
+	sq        *SheetQueue
+	jobID     string
+	mu        sync.Mutex
+	buf       strings.Builder
+	attempt   int
+	received  int
+	lastFlush time.Time
+}
+
+func newChunkForwarder(sq *SheetQueue, jobID string) *chunkForwarder {
+	return &chunkForwarder{
+		sq:        sq,
+		jobID:     jobID,
+		attempt:   1,
+		lastFlush: time.Now(),
+	}
+}
+
+// OnChunk is passed to the ai package as its StreamFunc
+func (f *chunkForwarder) OnChunk(chunk ai.StreamChunk) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if chunk.Attempt != f.attempt {
+		// A retry started over, drop what we had buffered for the old attempt
+		f.buf.Reset()
+		f.attempt = chunk.Attempt
+		f.received = 0
+	}
+
+	f.buf.WriteString(chunk.Text)
+	f.received += len(chunk.Text)
+
+	if f.buf.Len() >= streamFlushBytes || time.Since(f.lastFlush) >= streamFlushInterval {
+		f.flushLocked()
+	}
+}
+
+// Flush sends whatever is still buffered
+func (f *chunkForwarder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushLocked()
+}
+
+func (f *chunkForwarder) flushLocked() {
+	f.lastFlush = time.Now()
+	if f.buf.Len() == 0 {
+		return
+	}
+
+	f.sq.statusUpdates <- StatusUpdate{
+		ID:        f.jobID,
+		Status:    "processing",
+		Data:      websocket.Stream(f.buf.String(), f.attempt, f.received, nil)["data"].(map[string]interface{}),
+		Transient: true,
+	}
+	f.buf.Reset()
+}
@@ -1,7 +1,7 @@
 package sheet
 
 import (
-	_"time"
+	"time"
 	"sync"
 	"log"
 )
@@ -13,6 +13,13 @@ type StatusUpdate struct {
 	Status string                 `json:"status"`
 	Result interface{}            `json:"result,omitempty"`
 	Data   map[string]interface{} `json:"data,omitempty"` // optional due to omitempty
+
+	// Transient updates only go to listeners, they are not written to the store
+	Transient bool `json:"-"`
+
+	// Requeue hands the job back to the dispatcher once the update is saved, after everything
+	// the failed run sent before it
+	Requeue bool `json:"-"`
 }
 
 type SheetQueue struct {
@@ -22,4 +29,7 @@ type SheetQueue struct {
 	logger        *log.Logger
 	mu            sync.Mutex
 	jobListeners  map[string]func(StatusUpdate)
+	dispatch      *dispatcher
+	running       map[string]*runningJob
+	reviewTimers  map[string]*time.Timer
 }
\ No newline at end of file
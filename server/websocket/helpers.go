@@ -82,6 +82,35 @@ func Stage(stage string, step string, extra map[string]interface{}) map[string]i
     }
 }
 
+// Stream carries a piece of the document while the AI is still writing it
+// attempt goes up when a retry starts over, clients should clear what they have
+func Stream(chunk string, attempt int, received int, extra map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "type": "stream",
+        "data": map[string]interface{}{
+            "type":     "stream",
+            "chunk":    chunk,
+            "attempt":  attempt,
+            "received": received,
+            "extra":    extra,
+        },
+    }
+}
+
+// Queue tells a waiting job where it stands, position 1 starts next
+func Queue(message string, position int, etaSeconds int, extra map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "type": "queue",
+        "data": map[string]interface{}{
+            "type":       "queue",
+            "message":    message,
+            "position":   position,
+            "etaSeconds": etaSeconds,
+            "extra":      extra,
+        },
+    }
+}
+
 func Review_output(heading string, content string, need bool, extra map[string]interface{}) map[string]interface{} {
     return map[string]interface{}{
        
@@ -126,6 +155,34 @@ func Completed(message string, result interface{}, extra map[string]interface{})
     }
 }
 
+// Cancelled ends a job that was cancelled, nothing else is sent for it afterwards
+func Cancelled(message string, extra map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "type": "cancelled",
+        "data": map[string]interface{}{
+            "type":    "cancelled",
+            "message": message,
+            "extra":   extra,
+        },
+    }
+}
+
+// AwaitReview asks the user to approve, edit or regenerate a draft before it is compiled
+// deadline is when the draft is approved on its own, in Unix seconds
+func AwaitReview(message string, latex string, deadline int64, extra map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "type": "awaiting-review",
+        "data": map[string]interface{}{
+            "type":     "awaiting-review",
+            "message":  message,
+            "latex":    latex,
+            "deadline": deadline,
+            "actions":  []string{"approve", "edit", "regenerate"},
+            "extra":    extra,
+        },
+    }
+}
+
 func Error(message string, err string, extra map[string]interface{}) map[string]interface{} {
     return map[string]interface{}{
         "type": "error",